@@ -27,11 +27,14 @@ from .config import Config
 from .db import init as init_db, upgrade_table
 from .matrix import MatrixHandler
 from .portal import Portal
+from .portal_bot import PortalBot
 from .puppet import Puppet
 from .user import User
+from .util.tracing import init_tracing, stop_tracing
 from .version import linkified_version, version
 from .web.provisioning import ProvisioningAPI
 from .web.public import PublicBridgeWebsite
+from .web.webhook import WebhookAPI
 
 from .abstract_user import AbstractUser  # isort: skip
 
@@ -55,6 +58,7 @@ class TelegramBridge(Bridge):
     matrix: MatrixHandler
     public_website: PublicBridgeWebsite | None
     provisioning_api: ProvisioningAPI | None
+    webhook_api: WebhookAPI | None
 
     def prepare_db(self) -> None:
         super().prepare_db()
@@ -77,8 +81,20 @@ class TelegramBridge(Bridge):
         else:
             self.public_website = None
 
+        if self.config["appservice.webhook.enabled"]:
+            self.webhook_api = WebhookAPI(self)
+            self.az.app.add_subapp(self.config["appservice.webhook.prefix"], self.webhook_api.app)
+        else:
+            self.webhook_api = None
+
     def prepare_bridge(self) -> None:
         self._prepare_website()
+        if self.config["tracing.enabled"]:
+            init_tracing(
+                self.config["tracing.endpoint"],
+                self.config["tracing.service_name"],
+                self.config["tracing.headers"],
+            )
         AbstractUser.init_cls(self)
         bot_token: str = self.config["telegram.bot_token"]
         if bot_token and not bot_token.lower().startswith("disable"):
@@ -92,6 +108,7 @@ class TelegramBridge(Bridge):
         self.add_startup_actions(Portal.restart_scheduled_disappearing())
         if self.bot:
             self.add_startup_actions(self.bot.start())
+        self.add_startup_actions(PortalBot.start_all())
         if self.config["bridge.resend_bridge_info"]:
             self.add_startup_actions(self.resend_bridge_info())
 
@@ -107,6 +124,8 @@ class TelegramBridge(Bridge):
         self.add_shutdown_actions(user.stop() for user in User.by_tgid.values())
         if self.bot:
             self.add_shutdown_actions(self.bot.stop())
+        self.add_shutdown_actions(bot.stop() for bot in PortalBot.by_token.values())
+        self.add_shutdown_actions(stop_tracing())
 
     async def get_user(self, user_id: UserID, create: bool = True) -> User | None:
         user = await User.get_by_mxid(user_id, create=create)
@@ -20,6 +20,7 @@ from abc import ABC, abstractmethod
 import asyncio
 import logging
 import platform
+import random
 import time
 
 from telethon.errors import AuthKeyError, UnauthorizedError
@@ -33,6 +34,7 @@ from telethon.tl.patched import Message, MessageService
 from telethon.tl.types import (
     Channel,
     Chat,
+    EncryptedChatRequested,
     MessageActionChannelMigrateFrom,
     MessageEmpty,
     PeerChannel,
@@ -42,6 +44,7 @@ from telethon.tl.types import (
     TypeUpdate,
     UpdateBotMessageReaction,
     UpdateChannel,
+    UpdateChannelReadMessagesContents,
     UpdateChannelUserTyping,
     UpdateChatDefaultBannedRights,
     UpdateChatParticipantAdmin,
@@ -51,18 +54,24 @@ from telethon.tl.types import (
     UpdateDeleteMessages,
     UpdateEditChannelMessage,
     UpdateEditMessage,
+    UpdateEncryption,
     UpdateFolderPeers,
     UpdateMessageReactions,
     UpdateNewChannelMessage,
     UpdateNewMessage,
+    UpdateNewScheduledMessage,
     UpdateNotifySettings,
+    UpdatePendingJoinRequests,
     UpdatePhoneCall,
     UpdatePinnedChannelMessages,
     UpdatePinnedDialogs,
     UpdatePinnedMessages,
     UpdateReadChannelInbox,
+    UpdateReadChannelOutbox,
     UpdateReadHistoryInbox,
     UpdateReadHistoryOutbox,
+    UpdateReadMessagesContents,
+    UpdateSavedReactionTags,
     UpdateShort,
     UpdateShortChatMessage,
     UpdateShortMessage,
@@ -84,9 +93,10 @@ from mautrix.util.opt_prometheus import Counter, Histogram
 
 from . import __version__, portal as po, puppet as pu
 from .config import Config
-from .db import Message as DBMessage, PgSession
+from .db import Message as DBMessage, PgSession, TTLMedia
 from .tgclient import MautrixTelegramClient
 from .types import TelegramID
+from .util import tracing
 
 if TYPE_CHECKING:
     from .__main__ import TelegramBridge
@@ -114,6 +124,13 @@ UPDATE_ERRORS = Counter(
     documentation="Number of fatal errors while handling Telegram updates",
     labelnames=("update_type",),
 )
+UPDATE_ERROR_RESTARTS = Counter(
+    name="bridge_telegram_update_error_restarts",
+    documentation="Number of times the Telegram connection was recreated after an update error",
+    labelnames=("error_type",),
+)
+# If the connection stays up this long after being recreated, the next error starts a new backoff
+UPDATE_ERROR_RESET_TIME = 10 * 60
 
 
 class AbstractUser(ABC):
@@ -140,6 +157,9 @@ class AbstractUser(ABC):
     relaybot_whitelisted: bool
     matrix_puppet_whitelisted: bool
     is_admin: bool
+    device_name: str | None = None
+    _update_error_restarts: int
+    _update_error_restarted_at: float
 
     def __init__(self) -> None:
         self.is_admin = False
@@ -150,6 +170,8 @@ class AbstractUser(ABC):
         self.client = None
         self.is_relaybot = False
         self.is_bot = False
+        self._update_error_restarts = 0
+        self._update_error_restarted_at = 0
 
     @property
     def connected(self) -> bool:
@@ -205,7 +227,7 @@ class AbstractUser(ABC):
         else:
             base_logger = logging.getLogger(f"telethon.{self.tgid or -hash(self.mxid)}")
 
-        device = self.config["telegram.device_info.device_model"]
+        device = self.device_name or self.config["telegram.device_info.device_model"]
         sysversion = self.config["telegram.device_info.system_version"]
         appversion = self.config["telegram.device_info.app_version"]
         connection, proxy = self._proxy_settings
@@ -272,11 +294,37 @@ class AbstractUser(ABC):
             self.log.critical(f"Stopping due to update handling error {type(err).__name__}")
             self.bridge.manual_stop(50)
         else:
-            self.log.info("Recreating Telethon connection in 60 seconds")
-            await asyncio.sleep(60)
+            delay = self._next_update_error_delay()
+            UPDATE_ERROR_RESTARTS.labels(error_type=type(err).__name__).inc()
+            if self._update_error_restarts == self.config["telegram.update_error_backoff.alert"]:
+                await self.send_bridge_notice(
+                    f"The Telegram connection has failed {self._update_error_restarts} times in "
+                    f"a row (latest error: {type(err).__name__}). The bridge will keep retrying."
+                )
+            self.log.info(
+                f"Recreating Telethon connection in {delay:.0f} seconds "
+                f"(attempt {self._update_error_restarts})"
+            )
+            await asyncio.sleep(delay)
             self.log.debug("Now recreating Telethon connection")
             await self.stop()
             await self.start()
+            self._update_error_restarted_at = time.monotonic()
+
+    def _next_update_error_delay(self) -> float:
+        if time.monotonic() - self._update_error_restarted_at > UPDATE_ERROR_RESET_TIME:
+            self._update_error_restarts = 0
+        self._update_error_restarts += 1
+        initial = self.config["telegram.update_error_backoff.initial"]
+        delay = min(
+            initial * 2 ** (self._update_error_restarts - 1),
+            self.config["telegram.update_error_backoff.max"],
+        )
+        # Randomize the delay so that users who failed at the same time don't reconnect together
+        return random.uniform(delay / 2, delay)
+
+    async def send_bridge_notice(self, text: str) -> None:
+        pass
 
     @abstractmethod
     async def update(self, update: TypeUpdate) -> bool:
@@ -298,8 +346,9 @@ class AbstractUser(ABC):
         start_time = time.time()
         update_type = type(update).__name__
         try:
-            if not await self.update(update):
-                await self._update(update)
+            with tracing.span("telegram.update", update_type=update_type, user=self.name):
+                if not await self.update(update):
+                    await self._update(update)
         except Exception:
             self.log.exception("Failed to handle Telegram update")
             UPDATE_ERRORS.labels(update_type=update_type).inc()
@@ -380,6 +429,8 @@ class AbstractUser(ABC):
             await self.delete_channel_message(update)
         elif isinstance(update, UpdatePhoneCall):
             await self.update_phone_call(update)
+        elif isinstance(update, UpdateEncryption):
+            await self.update_encryption(update)
         elif isinstance(update, UpdateMessageReactions):
             await self.update_reactions(update)
         elif isinstance(update, UpdateBotMessageReaction):
@@ -398,7 +449,7 @@ class AbstractUser(ABC):
             await self.update_pinned_messages(update)
         elif isinstance(update, (UpdateUserName, UpdateUser)):
             await self.update_others_info(update)
-        elif isinstance(update, UpdateReadHistoryOutbox):
+        elif isinstance(update, (UpdateReadHistoryOutbox, UpdateReadChannelOutbox)):
             await self.update_read_receipt(update)
         elif isinstance(update, (UpdateReadHistoryInbox, UpdateReadChannelInbox)):
             await self.update_own_read_receipt(update)
@@ -410,6 +461,14 @@ class AbstractUser(ABC):
             await self.update_notify_settings(update)
         elif isinstance(update, UpdateChannel):
             await self.update_channel(update)
+        elif isinstance(update, UpdateSavedReactionTags):
+            await self.update_saved_reaction_tags(update)
+        elif isinstance(update, UpdatePendingJoinRequests):
+            await self.update_pending_join_requests(update)
+        elif isinstance(update, UpdateNewScheduledMessage):
+            await self.update_scheduled_message(update)
+        elif isinstance(update, (UpdateReadMessagesContents, UpdateChannelReadMessagesContents)):
+            await self.update_read_contents(update)
         else:
             self.log.trace("Unhandled update: %s", update)
 
@@ -422,6 +481,37 @@ class AbstractUser(ABC):
     async def update_notify_settings(self, update: UpdateNotifySettings) -> None:
         pass
 
+    async def update_saved_reaction_tags(self, update: UpdateSavedReactionTags) -> None:
+        pass
+
+    async def update_pending_join_requests(self, update: UpdatePendingJoinRequests) -> None:
+        if self.is_bot:
+            return
+        portal = await po.Portal.get_by_entity(update.peer, tg_receiver=self.tgid, create=False)
+        if portal and portal.mxid:
+            await portal.handle_telegram_join_requests(self, update.recent_requesters)
+
+    async def update_read_contents(
+        self, update: UpdateReadMessagesContents | UpdateChannelReadMessagesContents
+    ) -> None:
+        if isinstance(update, UpdateChannelReadMessagesContents):
+            space = TelegramID(update.channel_id)
+        else:
+            space = self.tgid
+        for ttl_media in await TTLMedia.get_by_tgids(update.messages, space):
+            portal = await po.Portal.get_by_mxid(ttl_media.mx_room)
+            if portal:
+                await portal.handle_telegram_media_opened(ttl_media)
+
+    async def update_scheduled_message(self, update: UpdateNewScheduledMessage) -> None:
+        if self.is_bot or not isinstance(update.message, Message):
+            return
+        portal = await po.Portal.get_by_entity(
+            update.message.peer_id, tg_receiver=self.tgid, create=False
+        )
+        if portal and portal.mxid:
+            await portal.handle_telegram_scheduled_message(self, update.message)
+
     async def update_pinned_messages(
         self, update: UpdatePinnedMessages | UpdatePinnedChannelMessages
     ) -> None:
@@ -446,8 +536,13 @@ class AbstractUser(ABC):
         if portal and portal.mxid:
             await portal.update_default_banned_rights(update.default_banned_rights)
 
-    async def update_read_receipt(self, update: UpdateReadHistoryOutbox) -> None:
-        if not isinstance(update.peer, PeerUser):
+    async def update_read_receipt(
+        self, update: UpdateReadHistoryOutbox | UpdateReadChannelOutbox
+    ) -> None:
+        if isinstance(update, UpdateReadChannelOutbox) or isinstance(update.peer, PeerChat):
+            await self.update_group_read_receipt(update)
+            return
+        elif not isinstance(update.peer, PeerUser):
             self.log.debug("Unexpected read receipt peer: %s", update.peer)
             return
 
@@ -467,6 +562,20 @@ class AbstractUser(ABC):
         puppet = await pu.Puppet.get_by_peer(update.peer)
         await puppet.intent.mark_read(portal.mxid, message.mxid)
 
+    async def update_group_read_receipt(
+        self, update: UpdateReadHistoryOutbox | UpdateReadChannelOutbox
+    ) -> None:
+        # Group outbox updates only say that someone read the message, so the portal has to
+        # ask Telegram who it was.
+        if self.is_bot or not self.config["bridge.group_read_receipts"]:
+            return
+        if isinstance(update, UpdateReadChannelOutbox):
+            portal = await po.Portal.get_by_tgid(TelegramID(update.channel_id))
+        else:
+            portal = await po.Portal.get_by_tgid(TelegramID(update.peer.chat_id))
+        if portal and portal.mxid:
+            await portal.poll_read_participants(self, TelegramID(update.max_id))
+
     async def update_own_read_receipt(
         self, update: UpdateReadHistoryInbox | UpdateReadChannelInbox
     ) -> None:
@@ -655,6 +764,7 @@ class AbstractUser(ABC):
         portal = await po.Portal.get_by_entity(update.peer, tg_receiver=self.tgid)
         if not portal or not portal.mxid or not portal.allow_bridging:
             return
+        portal.mark_reactions_pushed(self, TelegramID(update.msg_id))
         await portal.handle_telegram_reactions(self, TelegramID(update.msg_id), update.reactions)
 
     async def update_bot_reactions(self, update: UpdateBotMessageReaction) -> None:
@@ -676,12 +786,32 @@ class AbstractUser(ABC):
         sender = await pu.Puppet.get_by_tgid(TelegramID(update.phone_call.admin_id))
         await portal.handle_telegram_direct_call(self, sender, update)
 
+    async def update_encryption(self, update: UpdateEncryption) -> None:
+        # Secret chats use a separate end-to-end encryption protocol that the bridge doesn't
+        # implement, so they can't be bridged. Tell the user instead of silently ignoring them.
+        chat = update.chat
+        if not isinstance(chat, EncryptedChatRequested) or chat.admin_id == self.tgid:
+            return
+        tgid = TelegramID(chat.admin_id)
+        portal = await po.Portal.get_by_tgid(tgid, tg_receiver=self.tgid, peer_type="user")
+        if not portal or not portal.mxid or not portal.allow_bridging:
+            return
+        sender = await pu.Puppet.get_by_tgid(tgid)
+        await portal.handle_telegram_secret_chat_request(self, sender)
+
     async def update_channel(self, update: UpdateChannel) -> None:
         portal = await po.Portal.get_by_tgid(TelegramID(update.channel_id))
         if not portal:
             return
         if getattr(update, "mau_telethon_is_leave", False):
             self.log.debug("UpdateChannel has mau_telethon_is_leave, leaving portal")
+            if (
+                portal.mxid
+                and not self.is_bot
+                and self.config["bridge.archive_left_channels"]
+                and await portal.archive_if_last_user(self)
+            ):
+                return
             await portal.delete_telegram_user(self.tgid, sender=None)
         elif chan := getattr(update, "mau_channel", None):
             if not portal.mxid:
@@ -722,9 +852,13 @@ class AbstractUser(ABC):
         if not portal:
             return
         elif portal and not portal.allow_bridging:
-            self.log.debug(
-                f"Ignoring message {update.id} in portal {portal.tgid_log} (bridging disallowed)"
-            )
+            if portal.is_ignored:
+                portal.log_ignored_update()
+            else:
+                self.log.debug(
+                    f"Ignoring message {update.id} in portal {portal.tgid_log} "
+                    "(bridging disallowed)"
+                )
             return
 
         if not portal.mxid and getattr(original_update, "mau_left_channel", False):
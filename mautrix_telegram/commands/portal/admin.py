@@ -15,9 +15,13 @@
 # along with this program.  If not, see <https://www.gnu.org/licenses/>.
 import asyncio
 
+from mautrix.errors import IntentError, MatrixRequestError
 from mautrix.types import EventID
+from mautrix.util.simple_template import SimpleTemplate
 
 from ... import portal as po, puppet as pu, user as u
+from ...db import DisappearingMessage, Message, PgSession, Portal as DBPortal, Reaction
+from ...types import TelegramID
 from .. import SECTION_ADMIN, CommandEvent, command_handler
 
 
@@ -75,3 +79,151 @@ async def reload_user(evt: CommandEvent) -> EventID:
     if puppet:
         await puppet.start()
     return await evt.reply(f"Reloaded and reconnected {user.mxid} (telegram: {user.human_tg_id})")
+
+
+@command_handler(
+    needs_admin=True,
+    needs_auth=False,
+    help_section=SECTION_ADMIN,
+    help_text="Remove database rows that belong to deleted logins or portals",
+)
+async def db_prune(evt: CommandEvent) -> EventID:
+    counts = await PgSession.delete_orphaned()
+    counts["message"] = await Message.delete_orphaned()
+    counts["reaction"] = await Reaction.delete_orphaned()
+    counts["disappearing_message"] = await DisappearingMessage.delete_orphaned()
+    counts["portal_settings_backup"] = await DBPortal.delete_orphaned_settings_backups()
+    total = sum(counts.values())
+    if not total:
+        return await evt.reply("No orphaned rows found")
+    lines = "\n".join(
+        f"* `{table}`: {count} rows" for table, count in counts.items() if count > 0
+    )
+    return await evt.reply(
+        f"Deleted {total} orphaned rows:\n\n{lines}\n\n"
+        "Cached files are shared between users and portals, so they were left as-is. "
+        "Deleting rows doesn't shrink the database files by itself, see the documentation "
+        "of your database (e.g. `VACUUM`) for that."
+    )
+
+
+@command_handler(
+    needs_admin=True,
+    needs_auth=False,
+    help_section=SECTION_ADMIN,
+    help_args="<_old username template_>",
+    help_text="Move ghost users created with a previous username template to the current one",
+)
+async def migrate_ghosts(evt: CommandEvent) -> EventID:
+    usage = "**Usage:** `$cmdprefix+sp migrate-ghosts <old username template>`"
+    if len(evt.args) != 1 or "{userid}" not in evt.args[0]:
+        return await evt.reply(usage)
+    old_template = evt.args[0]
+    if old_template == evt.config["bridge.username_template"]:
+        return await evt.reply("That is the current username template.")
+    old_tpl = SimpleTemplate(
+        old_template,
+        "userid",
+        prefix="@",
+        suffix=f":{pu.Puppet.hs_domain}",
+        type=int,
+    )
+    await evt.reply(
+        "Migrating ghost users in all portal rooms. This may take a while, and requires the "
+        "appservice registration to cover the old namespace until the migration is done."
+    )
+    migrated: set[TelegramID] = set()
+    rooms = failed = 0
+    async for portal in po.Portal.all():
+        if not portal.mxid:
+            continue
+        if portal.peer_type == "user":
+            # The room creator in private chats is the other user's ghost
+            reader = evt.az.intent.user(old_tpl.format_full(portal.tgid))
+        else:
+            reader = portal.main_intent
+        try:
+            members = await reader.get_joined_members(portal.mxid)
+        except (MatrixRequestError, IntentError) as e:
+            evt.log.warning(f"Failed to get members of {portal.mxid} for migration: {e}")
+            failed += 1
+            continue
+        room_migrated = False
+        for mxid in members:
+            tgid = old_tpl.parse(mxid)
+            if tgid is None:
+                continue
+            puppet = await pu.Puppet.get_by_tgid(TelegramID(tgid))
+            if puppet.default_mxid == mxid:
+                continue
+            try:
+                await puppet.migrate_ghost(mxid, portal)
+            except (MatrixRequestError, IntentError) as e:
+                evt.log.warning(f"Failed to migrate {mxid} in {portal.mxid}: {e}")
+                failed += 1
+                continue
+            migrated.add(puppet.tgid)
+            room_migrated = True
+        rooms += room_migrated
+    for tgid in migrated:
+        puppet = await pu.Puppet.get_by_tgid(tgid)
+        try:
+            await puppet.copy_profile_to_ghost()
+        except (MatrixRequestError, IntentError) as e:
+            evt.log.warning(f"Failed to copy profile to {puppet.default_mxid}: {e}")
+    result = f"Migrated {len(migrated)} ghost users in {rooms} rooms."
+    if failed:
+        result += f" {failed} rooms or memberships failed to migrate, check the logs for details."
+    return await evt.reply(result)
+
+
+@command_handler(
+    needs_admin=True,
+    needs_auth=True,
+    help_section=SECTION_ADMIN,
+    help_args="<_chat ID_>",
+    help_text=(
+        "Clear the ignored marker of a Telegram chat and bridge it even if it's above the "
+        "member limit. The ID must be prefixed like the `/id` bot command output."
+    ),
+)
+async def unignore(evt: CommandEvent) -> EventID:
+    if len(evt.args) != 1:
+        return await evt.reply("**Usage:** `$cmdprefix+sp unignore <Telegram chat ID>`")
+    tgid_str = evt.args[0]
+    tgid = None
+    try:
+        if tgid_str.startswith("-100"):
+            tgid = TelegramID(int(tgid_str[4:]))
+        elif tgid_str.startswith("-"):
+            tgid = TelegramID(-int(tgid_str))
+    except ValueError:
+        pass
+    if not tgid:
+        return await evt.reply(
+            "That doesn't seem like a prefixed Telegram chat ID. Prefix channel/supergroup IDs "
+            "with `-100` and non-super group IDs with `-`."
+        )
+    portal = await po.Portal.get_by_tgid(tgid)
+    if not portal:
+        return await evt.reply("That Telegram chat isn't known to the bridge.")
+    elif portal.mxid:
+        return await evt.reply(
+            f"That chat is already bridged to [{portal.alias or portal.mxid}]"
+            f"(https://matrix.to/#/{portal.mxid})."
+        )
+    if portal.ignored_reason:
+        evt.log.info(f"Unignoring {portal.tgid_log} (was {portal.ignored_reason})")
+        await portal.unignore()
+    if not portal.allow_bridging:
+        return await evt.reply(
+            "Cleared the ignored marker, but the chat is still blocked by the bridge filter. "
+            "Try `$cmdprefix+sp filter whitelist <Telegram chat ID>` first."
+        )
+    await evt.reply("Creating room for chat, ignoring the member limit...")
+    mxid = await portal.create_matrix_room(
+        evt.sender, invites=[evt.sender.mxid], ignore_member_limit=True
+    )
+    if not mxid:
+        return await evt.reply("Failed to create room, check the logs for details.")
+    return await evt.reply(f"Created room [{portal.alias or mxid}](https://matrix.to/#/{mxid}).")
@@ -17,6 +17,7 @@ from __future__ import annotations
 
 from typing import Any, Awaitable
 from io import StringIO
+import json
 
 from ruamel.yaml import YAMLError
 
@@ -26,6 +27,9 @@ from mautrix.util.config import yaml
 from ... import portal as po, util
 from .. import SECTION_PORTAL_MANAGEMENT, CommandEvent, command_handler
 
+# Config keys that enforce privacy policies, so room admins must not be able to change them
+ADMIN_ONLY_KEYS = ("metadata_only",)
+
 
 @command_handler(
     needs_auth=False,
@@ -36,7 +40,7 @@ from .. import SECTION_PORTAL_MANAGEMENT, CommandEvent, command_handler
 )
 async def config(evt: CommandEvent) -> None:
     cmd = evt.args[0].lower() if len(evt.args) > 0 else "help"
-    if cmd not in ("view", "defaults", "set", "unset", "add", "del"):
+    if cmd not in ("view", "defaults", "set", "unset", "add", "del", "export", "import"):
         await config_help(evt)
         return
     elif cmd == "defaults":
@@ -50,12 +54,21 @@ async def config(evt: CommandEvent) -> None:
     elif cmd == "view":
         await config_view(evt, portal)
         return
+    elif cmd == "export":
+        await config_export(evt, portal)
+        return
 
     if not await portal.can_user_perform(evt.sender, "config"):
         await evt.reply("You do not have the permissions to configure this room.")
         return
+    elif cmd == "import":
+        await config_import(evt, portal)
+        return
 
     key = evt.args[1] if len(evt.args) > 1 else None
+    if key and key.split(".")[0] in ADMIN_ONLY_KEYS and not evt.sender.is_admin:
+        await evt.reply(f"Only bridge admins can change `{key}`.")
+        return
     try:
         value = yaml.load(" ".join(evt.args[2:])) if len(evt.args) > 2 else None
     except YAMLError as e:
@@ -83,6 +96,8 @@ def config_help(evt: CommandEvent) -> Awaitable[EventID]:
 * **unset** <_key_> - Remove a config value.
 * **add** <_key_> <_value_> - Add a value to an array.
 * **del** <_key_> <_value_> - Remove a value from an array.
+* **export** - Export all portal settings (config, relay user and send-as identities) as JSON.
+* **import** <_json_> - Replace the portal settings with previously exported ones.
 """
     )
 
@@ -91,6 +106,37 @@ def config_view(evt: CommandEvent, portal: po.Portal) -> Awaitable[EventID]:
     return evt.reply(f"Room-specific config:\n{_str_value(portal.local_config).rstrip()}")
 
 
+async def config_export(evt: CommandEvent, portal: po.Portal) -> EventID:
+    settings = json.dumps(await portal.export_settings())
+    return await evt.reply(
+        f"Portal settings:\n\n```json\n{settings}\n```\n\n"
+        f"Use `$cmdprefix+sp config import <json>` to apply them to another portal."
+    )
+
+
+async def config_import(evt: CommandEvent, portal: po.Portal) -> EventID:
+    if len(evt.args) < 2:
+        return await evt.reply("**Usage:** `$cmdprefix+sp config import <json>`")
+    try:
+        settings = json.loads(" ".join(evt.args[1:]))
+        if not evt.sender.is_admin and isinstance(settings, dict):
+            # Relay users and send-as identities act on other users' Telegram accounts, so only
+            # bridge admins can import them. For others, the current ones are kept.
+            settings["relay_user_id"] = portal.relay_user_id
+            settings.pop("send_as", None)
+            config = settings.get("config")
+            if isinstance(config, dict):
+                for key in ADMIN_ONLY_KEYS:
+                    if key in portal.local_config:
+                        config[key] = portal.local_config[key]
+                    else:
+                        config.pop(key, None)
+        await portal.import_settings(settings)
+    except (ValueError, TypeError, AttributeError) as e:
+        return await evt.reply(f"Invalid settings: {e}")
+    return await evt.reply("Successfully imported portal settings.")
+
+
 def config_defaults(evt: CommandEvent) -> Awaitable[EventID]:
     value = _str_value(
         {
@@ -99,7 +145,12 @@ def config_defaults(evt: CommandEvent) -> Awaitable[EventID]:
                 "exceptions": evt.config["bridge.bridge_notices.exceptions"],
             },
             "bot_messages_as_notices": evt.config["bridge.bot_messages_as_notices"],
+            "silent_bot_messages": evt.config["bridge.silent_bot_messages"],
             "caption_in_message": evt.config["bridge.caption_in_message"],
+            "metadata_only": evt.config["bridge.metadata_only"],
+            "policy_lists": evt.config["bridge.policy_lists"],
+            "policy_list_report": evt.config["bridge.policy_list_report"],
+            "scheduled_message_notices": evt.config["bridge.scheduled_message_notices"],
             "message_formats": evt.config["bridge.message_formats"],
             "emote_format": evt.config["bridge.emote_format"],
             "state_event_formats": evt.config["bridge.state_event_formats"],
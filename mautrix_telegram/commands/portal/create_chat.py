@@ -15,7 +15,7 @@
 # along with this program.  If not, see <https://www.gnu.org/licenses/>.
 from __future__ import annotations
 
-from mautrix.types import EventID
+from mautrix.types import EventID, UserID
 
 from ... import portal as po
 from ...types import TelegramID
@@ -25,18 +25,23 @@ from .util import get_initial_state, user_has_power_level, warn_missing_power
 
 @command_handler(
     help_section=SECTION_CREATING_PORTALS,
-    help_args="[_type_]",
+    help_args="[_type_] [_user ID_...]",
     help_text=(
         "Create a Telegram chat of the given type for the current Matrix room. "
-        "The type is either `group`, `supergroup` or `channel` (defaults to `supergroup`)."
+        "The type is either `group`, `supergroup` or `channel` (defaults to `supergroup`). "
+        "Telegram ghosts listed after the type are invited in addition to the room members."
     ),
 )
 async def create(evt: CommandEvent) -> EventID:
-    type = evt.args[0] if len(evt.args) > 0 else "supergroup"
-    if type not in ("chat", "group", "supergroup", "channel"):
+    args = list(evt.args)
+    type = args.pop(0) if len(args) > 0 and not args[0].startswith("@") else "supergroup"
+    if type not in ("chat", "group", "supergroup", "channel") or any(
+        not arg.startswith("@") or ":" not in arg for arg in args
+    ):
         return await evt.reply(
-            "**Usage:** `$cmdprefix+sp create ['group'/'supergroup'/'channel']`"
+            "**Usage:** `$cmdprefix+sp create ['group'/'supergroup'/'channel'] [user ID...]`"
         )
+    invite = [UserID(arg) for arg in args]
 
     if await po.Portal.get_by_mxid(evt.room_id):
         return await evt.reply("This is already a portal room.")
@@ -69,7 +74,7 @@ async def create(evt: CommandEvent) -> EventID:
     await warn_missing_power(levels, evt)
 
     try:
-        await portal.create_telegram_chat(evt.sender, supergroup=supergroup)
+        await portal.create_telegram_chat(evt.sender, supergroup=supergroup, invite=invite)
     except ValueError as e:
         await portal.delete()
         return await evt.reply(e.args[0])
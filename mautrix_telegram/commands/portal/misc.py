@@ -20,27 +20,41 @@ import re
 
 from telethon.errors import (
     ChatAdminRequiredError,
+    EmoticonInvalidError,
     RPCError,
+    ScheduleStatusPrivateError,
     UsernameInvalidError,
     UsernameNotModifiedError,
     UsernameOccupiedError,
 )
 from telethon.helpers import add_surrogate
-from telethon.tl.functions.channels import GetFullChannelRequest
-from telethon.tl.functions.messages import GetExportedChatInvitesRequest, GetFullChatRequest
+from telethon.tl.functions.channels import GetFullChannelRequest, GetSendAsRequest
+from telethon.tl.functions.messages import (
+    GetExportedChatInvitesRequest,
+    GetFullChatRequest,
+    SaveDefaultSendAsRequest,
+    SetChatThemeRequest,
+    SetHistoryTTLRequest,
+)
 from telethon.tl.types import (
     ChatInviteExported,
     InputMessageEntityMentionName,
+    InputPeerSelf,
     InputUserSelf,
     MessageEntityMention,
     TypeInputPeer,
     TypeInputUser,
 )
 from telethon.tl.types.messages import ExportedChatInvites
+from telethon.utils import get_display_name, get_input_peer, get_peer_id
 
-from mautrix.types import EventID
+from mautrix.errors import MatrixRequestError
+from mautrix.types import EventID, RoomAlias, RoomID
+from mautrix.util.format_duration import format_duration
 
-from ... import formatter as fmt, portal as po, puppet as pu
+from ... import formatter as fmt, portal as po, portal_bot as pb, puppet as pu
+from ...db import Message as DBMessage
+from ...types import TelegramID
 from .. import SECTION_MISC, SECTION_PORTAL_MANAGEMENT, CommandEvent, command_handler
 from .util import user_has_power_level
 
@@ -64,7 +78,11 @@ async def sync_state(evt: CommandEvent) -> EventID:
 
 
 @command_handler(
-    needs_admin=False, needs_puppeting=False, needs_auth=False, help_section=SECTION_MISC
+    needs_admin=False,
+    needs_puppeting=False,
+    needs_auth=False,
+    help_section=SECTION_MISC,
+    help_text="Fetch the Telegram chat info and members to resync the portal room.",
 )
 async def sync_full(evt: CommandEvent) -> EventID:
     portal = await po.Portal.get_by_mxid(evt.room_id)
@@ -90,6 +108,32 @@ async def sync_full(evt: CommandEvent) -> EventID:
     return await evt.reply("Portal synced successfully.")
 
 
+@command_handler(
+    needs_admin=False,
+    needs_puppeting=False,
+    needs_auth=False,
+    help_section=SECTION_MISC,
+    help_text="Recompute the room power levels from the Telegram admin rights and repair drift.",
+)
+async def fix_power_levels(evt: CommandEvent) -> EventID:
+    portal = await po.Portal.get_by_mxid(evt.room_id)
+    if not portal:
+        return await evt.reply("This is not a portal room.")
+    elif portal.peer_type == "user":
+        return await evt.reply("This is not a channel or chat portal.")
+    elif not await user_has_power_level(evt.room_id, evt.az.intent, evt.sender, "power_levels"):
+        return await evt.reply("You do not have the permissions to change power levels.")
+
+    src = evt.tgbot if await evt.sender.needs_relaybot(portal) else evt.sender
+    try:
+        changed = await portal.resync_power_levels(src)
+    except (ValueError, RPCError):
+        return await evt.reply("Failed to get portal info from Telegram.")
+    if changed:
+        return await evt.reply("Power levels were out of sync and have been fixed.")
+    return await evt.reply("Power levels are already in sync with Telegram.")
+
+
 @command_handler(
     name="id",
     needs_admin=False,
@@ -287,6 +331,119 @@ async def list_invite_links(evt: CommandEvent) -> EventID:
         )
 
 
+@command_handler(
+    help_section=SECTION_PORTAL_MANAGEMENT,
+    help_text="Revoke a Telegram invite link to the current chat.",
+    help_args="<_link_>",
+)
+async def revoke_invite_link(evt: CommandEvent) -> EventID:
+    if not evt.is_portal:
+        return await evt.reply("This is not a portal room.")
+    elif len(evt.args) == 0:
+        return await evt.reply("**Usage:** `$cmdprefix+sp revoke-invite-link <link>`")
+    try:
+        await evt.portal.revoke_invite_link(evt.sender, evt.args[0])
+    except ValueError as e:
+        return await evt.reply(e.args[0])
+    except ChatAdminRequiredError:
+        return await evt.reply("You don't have the permission to revoke that invite link.")
+    except RPCError as e:
+        return await evt.reply(f"Failed to revoke the invite link: {e}")
+    return await evt.reply("Invite link revoked.")
+
+
+@command_handler(
+    needs_auth=False,
+    needs_puppeting=False,
+    help_section=SECTION_MISC,
+    help_args="[`add`|`remove` <_keyword_>]",
+    help_text="View or change the keywords that mention you when they appear in this chat.",
+)
+async def keywords(evt: CommandEvent) -> EventID:
+    portal = await po.Portal.get_by_mxid(evt.room_id)
+    if not portal:
+        return await evt.reply("This is not a portal room.")
+    current = list((await portal.get_mention_keywords()).get(evt.sender.mxid, []))
+    if len(evt.args) == 0:
+        if not current:
+            return await evt.reply("You don't have any mention keywords in this chat.")
+        return await evt.reply(
+            "Your mention keywords in this chat:\n\n"
+            + "\n".join(f"* `{keyword}`" for keyword in current)
+        )
+    action = evt.args[0].lower()
+    keyword = " ".join(evt.args[1:]).strip()
+    if action not in ("add", "remove") or not keyword:
+        return await evt.reply("**Usage:** `$cmdprefix+sp keywords [add|remove <keyword>]`")
+    elif action == "add":
+        if keyword.lower() in (kw.lower() for kw in current):
+            return await evt.reply(f"`{keyword}` is already one of your keywords.")
+        await portal.add_mention_keyword(evt.sender.mxid, keyword)
+        return await evt.reply(f"Messages containing `{keyword}` will now mention you.")
+    else:
+        removed = [kw for kw in current if kw.lower() == keyword.lower()]
+        if not removed:
+            return await evt.reply(f"`{keyword}` is not one of your keywords.")
+        for kw in removed:
+            await portal.remove_mention_keyword(evt.sender.mxid, kw)
+        return await evt.reply(f"Removed `{keyword}` from your keywords.")
+
+
+@command_handler(
+    needs_auth=False,
+    needs_puppeting=False,
+    help_section=SECTION_PORTAL_MANAGEMENT,
+    help_args="[`add`|`remove` <_room ID or alias_>]",
+    help_text=(
+        "View or change the moderation policy lists whose user bans are applied to incoming "
+        "Telegram messages in this chat."
+    ),
+)
+async def policy_lists(evt: CommandEvent) -> EventID:
+    portal = await po.Portal.get_by_mxid(evt.room_id)
+    if not portal:
+        return await evt.reply("This is not a portal room.")
+    current: list[RoomID] = portal.get_config("policy_lists") or []
+    if len(evt.args) == 0:
+        if not current:
+            return await evt.reply("This chat isn't subscribed to any policy lists.")
+        return await evt.reply(
+            "Policy lists applied in this chat:\n\n"
+            + "\n".join(f"* [{room_id}](https://matrix.to/#/{room_id})" for room_id in current)
+        )
+    action = evt.args[0].lower()
+    if action not in ("add", "remove") or len(evt.args) != 2:
+        return await evt.reply(
+            "**Usage:** `$cmdprefix+sp policy-lists [add|remove <room ID or alias>]`"
+        )
+    elif not await portal.can_user_perform(evt.sender, "config"):
+        return await evt.reply("You do not have the permissions to configure this room.")
+    room_id = RoomID(evt.args[1])
+    try:
+        if room_id.startswith("#"):
+            room_id = (await evt.az.intent.resolve_room_alias(RoomAlias(room_id))).room_id
+        if action == "add":
+            # The bot needs to be in the room to see rule changes
+            await evt.az.intent.join_room(room_id)
+    except MatrixRequestError as e:
+        return await evt.reply(f"Failed to access the policy list: {e.message}")
+    if action == "add":
+        if room_id in current:
+            return await evt.reply("This chat is already subscribed to that policy list.")
+        portal.local_config["policy_lists"] = [*current, room_id]
+        await portal.save()
+        po.Portal.policy_lists.invalidate(room_id)
+        rules = await po.Portal.policy_lists.get_rules(evt.az.intent, room_id)
+        return await evt.reply(
+            f"Subscribed to the policy list, which currently has {len(rules)} user bans."
+        )
+    elif room_id not in current:
+        return await evt.reply("This chat is not subscribed to that policy list.")
+    portal.local_config["policy_lists"] = [rid for rid in current if rid != room_id]
+    await portal.save()
+    return await evt.reply("Unsubscribed from the policy list.")
+
+
 @command_handler(
     help_section=SECTION_PORTAL_MANAGEMENT,
     help_text="Upgrade a normal Telegram group to a supergroup.",
@@ -345,3 +502,451 @@ async def group_name(evt: CommandEvent) -> EventID:
         return await evt.reply("That username is already in use.")
     except UsernameInvalidError:
         return await evt.reply("Invalid username")
+
+
+@command_handler(
+    help_section=SECTION_PORTAL_MANAGEMENT,
+    help_args="<_time delta, e.g. 1d_|`off`>",
+    help_text="Set the auto-delete timer for new messages in the current chat.",
+)
+async def ttl(evt: CommandEvent) -> EventID:
+    if len(evt.args) == 0:
+        return await evt.reply("**Usage:** `$cmdprefix+sp ttl <time delta, e.g. 1d|off>`")
+    elif not evt.is_portal:
+        return await evt.reply("This is not a portal room.")
+
+    if evt.args[0].lower() in ("off", "0"):
+        period = 0
+    else:
+        delta = _parse_delta(evt.args[0].lower())
+        if not delta:
+            return await evt.reply("Invalid format for auto-delete time delta")
+        period = int(delta.total_seconds())
+
+    try:
+        await evt.sender.client(
+            SetHistoryTTLRequest(peer=await evt.portal.get_input_entity(evt.sender), period=period)
+        )
+    except ChatAdminRequiredError:
+        return await evt.reply("You don't have the permission to change the auto-delete timer.")
+    except RPCError as e:
+        return await evt.reply(f"Failed to change the auto-delete timer: {e}")
+    if period == 0:
+        return await evt.reply("Disabled auto-deleting messages in this chat.")
+    return await evt.reply(f"New messages will be auto-deleted after {format_duration(period)}.")
+
+
+async def _set_archived(evt: CommandEvent, archived: bool) -> EventID:
+    if not evt.is_portal:
+        return await evt.reply("This is not a portal room.")
+    elif evt.sender.is_bot:
+        return await evt.reply("Bots can't archive chats.")
+    try:
+        await evt.portal.set_archived(evt.sender, archived)
+    except RPCError as e:
+        return await evt.reply(f"Failed to {'' if archived else 'un'}archive the chat: {e}")
+    if archived:
+        return await evt.reply("Moved the chat to the archive on Telegram.")
+    return await evt.reply("Moved the chat out of the archive on Telegram.")
+
+
+@command_handler(
+    help_section=SECTION_PORTAL_MANAGEMENT,
+    help_text=(
+        "Archive the current chat on Telegram. If `archive_tag` is set in the bridge config, "
+        "the room is also tagged with it on Matrix."
+    ),
+)
+async def archive(evt: CommandEvent) -> EventID:
+    return await _set_archived(evt, True)
+
+
+@command_handler(
+    help_section=SECTION_PORTAL_MANAGEMENT,
+    help_text="Unarchive the current chat on Telegram.",
+)
+async def unarchive(evt: CommandEvent) -> EventID:
+    return await _set_archived(evt, False)
+
+
+async def _handle_join_request(evt: CommandEvent, approved: bool) -> EventID:
+    if not evt.is_portal:
+        return await evt.reply("This is not a portal room.")
+    elif evt.portal.peer_type == "user":
+        return await evt.reply("Private chats don't have join requests.")
+    elif not await user_has_power_level(evt.room_id, evt.az.intent, evt.sender, "join_requests"):
+        return await evt.reply("You do not have the permissions to handle join requests.")
+
+    try:
+        pending = await evt.portal.update_join_requests(evt.sender)
+    except RPCError as e:
+        return await evt.reply(f"Failed to get pending join requests: {e}")
+    if len(evt.args) == 0:
+        if not pending:
+            return await evt.reply("There are no pending join requests.")
+        users = "\n".join(
+            f"* [{tgid}](https://matrix.to/#/{pu.Puppet.get_mxid_from_id(tgid)})"
+            for tgid in pending
+        )
+        return await evt.reply(f"Pending join requests:\n\n{users}")
+
+    tgid = pu.Puppet.get_id_from_mxid(evt.args[0])
+    if not tgid and evt.args[0].isdecimal():
+        tgid = TelegramID(int(evt.args[0]))
+    if not tgid:
+        return await evt.reply(
+            f"**Usage:** `$cmdprefix+sp {evt.command} [Telegram ghost user ID or Telegram ID]`"
+        )
+    elif tgid not in pending:
+        return await evt.reply("That user hasn't requested to join this chat.")
+    puppet = await pu.Puppet.get_by_tgid(tgid)
+    try:
+        await evt.portal.handle_join_request(evt.sender, puppet, approved=approved)
+    except RPCError as e:
+        return await evt.reply(f"Failed to {evt.command} the join request: {e}")
+    if approved:
+        return await evt.reply(f"Approved the join request of {puppet.displayname or tgid}.")
+    return await evt.reply(f"Declined the join request of {puppet.displayname or tgid}.")
+
+
+@command_handler(
+    help_section=SECTION_PORTAL_MANAGEMENT,
+    help_args="[_user_]",
+    help_text=(
+        "Approve a pending request to join the current chat. "
+        "Lists the pending requests if no user is given."
+    ),
+)
+async def approve(evt: CommandEvent) -> EventID:
+    return await _handle_join_request(evt, True)
+
+
+@command_handler(
+    help_section=SECTION_PORTAL_MANAGEMENT,
+    help_args="[_user_]",
+    help_text=(
+        "Decline a pending request to join the current chat. "
+        "Lists the pending requests if no user is given."
+    ),
+)
+async def deny(evt: CommandEvent) -> EventID:
+    return await _handle_join_request(evt, False)
+
+
+@command_handler(
+    help_section=SECTION_PORTAL_MANAGEMENT,
+    help_args="[_emoji_|`off`]",
+    help_text="View or change the chat theme of the current chat.",
+)
+async def theme(evt: CommandEvent) -> EventID:
+    if not evt.is_portal:
+        return await evt.reply("This is not a portal room.")
+    elif len(evt.args) == 0:
+        if evt.portal.theme_emoticon:
+            return await evt.reply(f"The chat theme is {evt.portal.theme_emoticon}")
+        return await evt.reply("This chat doesn't have a theme set.")
+
+    emoticon = "" if evt.args[0].lower() == "off" else evt.args[0]
+    peer = await evt.portal.get_input_entity(evt.sender)
+    try:
+        await evt.sender.client(SetChatThemeRequest(peer=peer, emoticon=emoticon))
+    except EmoticonInvalidError:
+        return await evt.reply("That emoji isn't a valid chat theme.")
+    except RPCError as e:
+        return await evt.reply(f"Failed to change the chat theme: {e}")
+    if not emoticon:
+        return await evt.reply("Removed the chat theme.")
+    return await evt.reply(f"Changed the chat theme to {emoticon}")
+
+
+async def _get_send_as_peers(evt: CommandEvent) -> list[tuple[int, str, TypeInputPeer, bool]]:
+    res = await evt.sender.client(
+        GetSendAsRequest(peer=await evt.portal.get_input_entity(evt.sender))
+    )
+    entities = {get_peer_id(entity): entity for entity in (*res.chats, *res.users)}
+    peers = []
+    for item in res.peers:
+        peer_id = get_peer_id(item.peer)
+        entity = entities.get(peer_id)
+        if not entity:
+            continue
+        name = get_display_name(entity)
+        peers.append((peer_id, name, get_input_peer(entity), item.premium_required))
+    return peers
+
+
+@command_handler(
+    help_section=SECTION_PORTAL_MANAGEMENT,
+    help_args="[`list`|`anonymous`|`self`|_number from list_]",
+    help_text=(
+        "View or change the identity your messages in the current supergroup are sent as: "
+        "yourself, anonymously as the group (requires being an anonymous admin) or one of your "
+        "channels (e.g. when commenting on channel posts)."
+    ),
+)
+async def send_as(evt: CommandEvent) -> EventID:
+    if not evt.is_portal:
+        return await evt.reply("This is not a portal room.")
+    elif not evt.portal.megagroup:
+        return await evt.reply("Choosing the identity to send as is only possible in supergroups.")
+    elif len(evt.args) == 0:
+        current = await evt.sender.get_send_as(evt.portal)
+        if current is None:
+            return await evt.reply("Your messages in this chat are sent as yourself.")
+        elif current == get_peer_id(evt.portal.peer):
+            return await evt.reply("Your messages in this chat are sent anonymously.")
+        try:
+            name = get_display_name(await evt.sender.client.get_entity(current))
+        except ValueError:
+            name = str(current)
+        return await evt.reply(f"Your messages in this chat are sent as {name}.")
+
+    mode = evt.args[0].lower()
+    send_as_id = None
+    if mode == "list":
+        try:
+            peers = await _get_send_as_peers(evt)
+        except RPCError as e:
+            return await evt.reply(f"Failed to get the identities you can send as: {e}")
+        lines = "\n".join(
+            f"{i}. {name}{' (requires Telegram Premium)' if premium else ''}"
+            for i, (_, name, _, premium) in enumerate(peers, start=1)
+        )
+        return await evt.reply(
+            f"You can send messages in this chat as:\n\n{lines}\n\n"
+            "Use `$cmdprefix+sp send-as <number>` to choose one."
+        )
+    elif mode in ("anonymous", "anon", "group"):
+        send_as_peer = await evt.portal.get_input_entity(evt.sender)
+    elif mode in ("self", "me", "off"):
+        send_as_peer = InputPeerSelf()
+    elif mode.isdigit():
+        try:
+            peers = await _get_send_as_peers(evt)
+        except RPCError as e:
+            return await evt.reply(f"Failed to get the identities you can send as: {e}")
+        index = int(mode) - 1
+        if not 0 <= index < len(peers):
+            return await evt.reply(
+                "Invalid number. Use `$cmdprefix+sp send-as list` to see the options."
+            )
+        send_as_id, _, send_as_peer, _ = peers[index]
+        if send_as_id == evt.sender.tgid:
+            send_as_peer = InputPeerSelf()
+    else:
+        return await evt.reply(
+            "**Usage:** `$cmdprefix+sp send-as [list|anonymous|self|<number from list>]`"
+        )
+    try:
+        # Save the default on Telegram too, so official clients use the same identity
+        await evt.sender.client(
+            SaveDefaultSendAsRequest(
+                peer=await evt.portal.get_input_entity(evt.sender), send_as=send_as_peer
+            )
+        )
+    except ChatAdminRequiredError:
+        return await evt.reply("You must be an anonymous admin to send messages as the group.")
+    except RPCError as e:
+        return await evt.reply(f"Failed to change the identity to send as: {e}")
+    if isinstance(send_as_peer, InputPeerSelf):
+        await evt.sender.set_send_as(evt.portal, None)
+        return await evt.reply("Your messages in this chat will now be sent as yourself.")
+    elif send_as_id is not None and send_as_id != get_peer_id(evt.portal.peer):
+        await evt.sender.set_send_as(evt.portal, send_as_id)
+        return await evt.reply("Your messages in this chat will now be sent as that channel.")
+    await evt.sender.set_send_as(evt.portal, get_peer_id(evt.portal.peer))
+    return await evt.reply("Your messages in this chat will now be sent anonymously.")
+
+
+@command_handler(
+    help_section=SECTION_MISC,
+    help_args="[_reason_]",
+    help_text="Report the message you're replying to to Telegram.",
+)
+async def report(evt: CommandEvent) -> EventID:
+    portal = await po.Portal.get_by_mxid(evt.room_id)
+    if not portal:
+        return await evt.reply("This is not a portal room.")
+    reply_to = evt.content.get_reply_to()
+    if not reply_to:
+        return await evt.reply("**Usage:** `$cmdprefix+sp report [reason]` (as a reply)")
+    reason = " ".join(evt.args) or None
+    try:
+        await portal.handle_matrix_report(evt.sender, reply_to, reason)
+    except ValueError as e:
+        return await evt.reply(f"{e}.")
+    except RPCError as e:
+        return await evt.reply(f"Failed to report message: {e}")
+    return await evt.reply("Reported the message to Telegram.")
+
+
+@command_handler(
+    help_section=SECTION_MISC,
+    help_args="<_amount_>",
+    help_text="Send a paid star reaction to the channel post you're replying to.",
+)
+async def star(evt: CommandEvent) -> EventID:
+    if len(evt.args) != 1 or not evt.args[0].isdecimal():
+        return await evt.reply("**Usage:** `$cmdprefix+sp star <amount>` (as a reply)")
+    portal = await po.Portal.get_by_mxid(evt.room_id)
+    if not portal:
+        return await evt.reply("This is not a portal room.")
+    elif not evt.config["bridge.allow_paid_reactions"]:
+        return await evt.reply("Sending paid reactions is not allowed on this bridge.")
+    elif portal.peer_type != "channel" or portal.megagroup:
+        return await evt.reply("Paid reactions can only be sent to channel posts.")
+    reply_to = evt.content.get_reply_to()
+    if not reply_to:
+        return await evt.reply("You must reply to the post you want to react to.")
+    msg = await DBMessage.get_by_mxid(reply_to, portal.mxid, portal.tgid)
+    if not msg:
+        return await evt.reply("That message is not bridged to Telegram.")
+    amount = int(evt.args[0])
+    if not 1 <= amount <= 2500:
+        return await evt.reply("The amount must be between 1 and 2500 stars.")
+
+    async def confirm_star(confirm: CommandEvent) -> EventID:
+        confirm.sender.command_status = None
+        if len(confirm.args) == 0 or confirm.args[0] != "confirm-star":
+            return await confirm.reply("Paid reaction cancelled.")
+        try:
+            await portal.send_paid_reaction(confirm.sender, msg.tgid, amount)
+        except (NotImplementedError, RPCError) as e:
+            return await confirm.reply(f"Failed to send paid reaction: {e}")
+        return await confirm.reply(f"Sent {amount} \u2b50 to the post.")
+
+    evt.sender.command_status = {"next": confirm_star, "action": "Paid reaction"}
+    return await evt.reply(
+        f"This will spend **{amount}** Telegram Stars from your account. "
+        "To confirm, use `$cmdprefix+sp confirm-star`, or use `$cmdprefix+sp cancel` to cancel."
+    )
+
+
+@command_handler(
+    help_section=SECTION_MISC,
+    help_args="<_message_>",
+    help_text="Send a message in the current private chat when the other user comes online.",
+)
+async def send_when_online(evt: CommandEvent) -> EventID:
+    if len(evt.args) == 0:
+        return await evt.reply("**Usage:** `$cmdprefix+sp send-when-online <message>`")
+    portal = await po.Portal.get_by_mxid(evt.room_id)
+    if not portal:
+        return await evt.reply("This is not a portal room.")
+    elif portal.peer_type != "user":
+        return await evt.reply("Messages can only be scheduled for when online in private chats.")
+    elif portal.tgid == evt.sender.tgid:
+        return await evt.reply("You can't schedule messages for when you come online.")
+    try:
+        await portal.send_when_online(evt.sender, " ".join(evt.args))
+    except ScheduleStatusPrivateError:
+        return await evt.reply(
+            "The other user's privacy settings hide when they're online, so the message "
+            "can't be scheduled for that. Send it normally or schedule it for a specific "
+            "time in a Telegram app instead."
+        )
+    except RPCError as e:
+        return await evt.reply(f"Failed to schedule message: {e}")
+    return await evt.reply("The message will be sent when the other user comes online.")
+
+
+@command_handler(
+    help_section=SECTION_PORTAL_MANAGEMENT,
+    help_text=(
+        "Relay messages in this room from Matrix users who aren't logged in through your "
+        "Telegram account."
+    ),
+)
+async def set_relay(evt: CommandEvent) -> EventID:
+    portal = await po.Portal.get_by_mxid(evt.room_id)
+    if not portal:
+        return await evt.reply("This is not a portal room.")
+    elif not evt.config["bridge.relaybot.allow_relay_user"]:
+        return await evt.reply("Relay users are not enabled on this bridge.")
+    elif evt.config["bridge.relaybot.relay_user_admin_only"] and not evt.sender.is_admin:
+        return await evt.reply("Only bridge admins can set relay users.")
+    elif portal.peer_type == "user":
+        return await evt.reply("Relay users can only be set in group chats.")
+    elif not await user_has_power_level(evt.room_id, evt.az.intent, evt.sender, "bridge"):
+        return await evt.reply("You do not have the permissions to set the relay user.")
+    elif portal.tgid_full not in await evt.sender.get_cached_portals():
+        return await evt.reply("You are not in this chat on Telegram.")
+    await portal.set_relay_user(evt.sender)
+    return await evt.reply(
+        "Messages from Matrix users who aren't logged in will now be relayed through "
+        "your Telegram account."
+    )
+
+
+@command_handler(
+    help_section=SECTION_PORTAL_MANAGEMENT,
+    help_text="Stop relaying messages in this room through a user's Telegram account.",
+)
+async def unset_relay(evt: CommandEvent) -> EventID:
+    portal = await po.Portal.get_by_mxid(evt.room_id)
+    if not portal:
+        return await evt.reply("This is not a portal room.")
+    elif not portal.relay_user_id:
+        return await evt.reply("This room does not have a relay user.")
+    elif portal.relay_user_id != evt.sender.mxid and not await user_has_power_level(
+        evt.room_id, evt.az.intent, evt.sender, "bridge"
+    ):
+        return await evt.reply("You do not have the permissions to unset the relay user.")
+    await portal.set_relay_user(None)
+    if portal.has_bot:
+        return await evt.reply("Messages will now be relayed through the relay bot.")
+    return await evt.reply("Messages from Matrix users who aren't logged in will not be bridged.")
+
+
+@command_handler(
+    needs_auth=False,
+    help_section=SECTION_PORTAL_MANAGEMENT,
+    help_args="<_bot token_>",
+    help_text=(
+        "Send all Matrix messages in this channel through a Telegram bot, for channels where "
+        "posting is only possible via a bot."
+    ),
+)
+async def set_bot_token(evt: CommandEvent) -> EventID:
+    portal = await po.Portal.get_by_mxid(evt.room_id)
+    if not portal:
+        return await evt.reply("This is not a portal room.")
+    elif len(evt.args) != 1:
+        return await evt.reply("**Usage:** `$cmdprefix+sp set-bot-token <bot token>`")
+    # The token is a secret, so don't leave it in the room
+    await evt.redact()
+    if portal.peer_type != "channel":
+        return await evt.reply("Bot tokens can only be set in channels.")
+    elif not await user_has_power_level(evt.room_id, evt.az.intent, evt.sender, "bridge"):
+        return await evt.reply("You do not have the permissions to set the bot token.")
+    token = evt.args[0]
+    try:
+        bot = await pb.PortalBot.get(token)
+    except (RPCError, ValueError) as e:
+        return await evt.reply(f"Failed to log in with the bot token: {e}")
+    try:
+        await bot.prepare_portal(portal)
+    except (RPCError, ValueError) as e:
+        await pb.PortalBot.release(token)
+        return await evt.reply(f"The bot can't post in this channel: {e}")
+    await portal.set_bot_token(token)
+    return await evt.reply(
+        f"Matrix messages in this room will now be sent through @{bot.tg_username}."
+    )
+
+
+@command_handler(
+    needs_auth=False,
+    help_section=SECTION_PORTAL_MANAGEMENT,
+    help_text="Stop sending Matrix messages in this channel through a bot.",
+)
+async def unset_bot_token(evt: CommandEvent) -> EventID:
+    portal = await po.Portal.get_by_mxid(evt.room_id)
+    if not portal:
+        return await evt.reply("This is not a portal room.")
+    elif not portal.bot_token:
+        return await evt.reply("This room does not have a bot token.")
+    elif not await user_has_power_level(evt.room_id, evt.az.intent, evt.sender, "bridge"):
+        return await evt.reply("You do not have the permissions to unset the bot token.")
+    await portal.set_bot_token(None)
+    return await evt.reply("Matrix messages will now be sent through the senders' accounts.")
@@ -59,7 +59,7 @@ async def warn_missing_power(levels: PowerLevelStateEventContent, evt: CommandEv
 
 
 async def user_has_power_level(
-    room_id: RoomID, intent: IntentAPI, sender: u.User, event: str
+    room_id: RoomID, intent: IntentAPI, sender: u.User, event: str | EventType
 ) -> bool:
     if sender.is_admin:
         return True
@@ -68,5 +68,8 @@ async def user_has_power_level(
         await intent.get_power_levels(room_id)
     except MatrixRequestError:
         return False
-    event_type = EventType.find(f"fi.mau.telegram.{event}", t_class=EventType.Class.STATE)
+    if isinstance(event, EventType):
+        event_type = event
+    else:
+        event_type = EventType.find(f"fi.mau.telegram.{event}", t_class=EventType.Class.STATE)
     return await intent.state_store.has_power_level(room_id, sender.mxid, event_type)
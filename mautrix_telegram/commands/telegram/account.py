@@ -17,9 +17,10 @@ from __future__ import annotations
 
 from telethon.errors import (
     AboutTooLongError,
-    AuthKeyError,
     FirstNameInvalidError,
+    FreshResetAuthorisationForbiddenError,
     HashInvalidError,
+    RPCError,
     UsernameInvalidError,
     UsernameNotModifiedError,
     UsernameOccupiedError,
@@ -130,44 +131,115 @@ def _format_session(sess: Authorization) -> str:
 async def session(evt: CommandEvent) -> EventID:
     if len(evt.args) == 0:
         return await evt.reply("**Usage:** `$cmdprefix+sp session <list|terminate> [hash]`")
-    elif evt.sender.is_bot:
-        return await evt.reply("Bots can't manage their sessions")
     cmd = evt.args[0].lower()
     if cmd == "list":
-        res = await evt.sender.client(GetAuthorizationsRequest())
-        session_list = res.authorizations
-        current = [s for s in session_list if s.current][0]
-        current_text = _format_session(current)
-        other_text = "\n".join(
-            f"* {_format_session(sess)}  \n  **Hash:** {sess.hash}"
-            for sess in session_list
-            if not sess.current
-        )
-        return await evt.reply(
-            f"### Current session\n"
-            f"{current_text}\n"
-            f"\n"
-            f"### Other active sessions\n"
-            f"{other_text}"
-        )
+        return await _list_sessions(evt)
     elif cmd == "terminate" and len(evt.args) > 1:
-        try:
-            session_hash = int(evt.args[1])
-        except ValueError:
-            return await evt.reply("Hash must be an integer")
-        try:
-            ok = await evt.sender.client(ResetAuthorizationRequest(hash=session_hash))
-        except HashInvalidError:
-            return await evt.reply("Invalid session hash.")
-        except AuthKeyError as e:
-            if e.message == "FRESH_RESET_AUTHORISATION_FORBIDDEN":
-                return await evt.reply(
-                    "New sessions can't terminate other sessions. Please wait a while."
-                )
-            raise
-        if ok:
-            return await evt.reply("Session terminated successfully.")
-        else:
-            return await evt.reply("Session not found.")
+        return await _terminate_session(evt, evt.args[1])
     else:
         return await evt.reply("**Usage:** `$cmdprefix+sp session <list|terminate> [hash]`")
+
+
+@command_handler(
+    needs_auth=True,
+    help_section=SECTION_AUTH,
+    help_text="List your active Telegram sessions.",
+)
+async def list_sessions(evt: CommandEvent) -> EventID:
+    return await _list_sessions(evt)
+
+
+@command_handler(
+    needs_auth=True,
+    help_section=SECTION_AUTH,
+    help_args="<_hash_>",
+    help_text="Terminate another Telegram session. Get the hash with `list-sessions`.",
+)
+async def terminate_session(evt: CommandEvent) -> EventID:
+    if len(evt.args) == 0:
+        return await evt.reply("**Usage:** `$cmdprefix+sp terminate-session <hash>`")
+    return await _terminate_session(evt, evt.args[0])
+
+
+async def _list_sessions(evt: CommandEvent) -> EventID:
+    if evt.sender.is_bot:
+        return await evt.reply("Bots can't manage their sessions")
+    res = await evt.sender.client(GetAuthorizationsRequest())
+    session_list = res.authorizations
+    current = [s for s in session_list if s.current][0]
+    current_text = _format_session(current)
+    other_text = "\n".join(
+        f"* {_format_session(sess)}  \n  **Hash:** {sess.hash}"
+        for sess in session_list
+        if not sess.current
+    )
+    return await evt.reply(
+        f"### Current session\n"
+        f"{current_text}\n"
+        f"\n"
+        f"### Other active sessions\n"
+        f"{other_text or 'No other active sessions'}"
+    )
+
+
+async def _terminate_session(evt: CommandEvent, raw_hash: str) -> EventID:
+    if evt.sender.is_bot:
+        return await evt.reply("Bots can't manage their sessions")
+    try:
+        session_hash = int(raw_hash)
+    except ValueError:
+        return await evt.reply("Hash must be an integer")
+    if session_hash == 0:
+        return await evt.reply("You can't terminate the bridge's own session, use `logout`.")
+    try:
+        ok = await evt.sender.client(ResetAuthorizationRequest(hash=session_hash))
+    except HashInvalidError:
+        return await evt.reply("Invalid session hash.")
+    except FreshResetAuthorisationForbiddenError:
+        return await evt.reply(
+            "Telegram doesn't allow sessions that logged in less than 24 hours ago to terminate "
+            "other sessions. Please try again later."
+        )
+    except RPCError as e:
+        return await evt.reply(f"Failed to terminate session: {e.message}")
+    if ok:
+        return await evt.reply("Session terminated successfully.")
+    else:
+        return await evt.reply("Session not found.")
+
+
+@command_handler(
+    needs_auth=False,
+    management_only=True,
+    help_section=SECTION_AUTH,
+    help_args="[_name_|`-`]",
+    help_text=(
+        "View or change the device name this bridge uses for your Telegram session. "
+        "Use `-` to go back to the default from the bridge config."
+    ),
+)
+async def device_name(evt: CommandEvent) -> EventID:
+    if len(evt.args) == 0:
+        if evt.sender.device_name:
+            return await evt.reply(f"Your session device name is `{evt.sender.device_name}`")
+        return await evt.reply("You're using the default device name from the bridge config")
+    elif evt.sender.command_status:
+        return await evt.reply(
+            "Please finish or cancel the current login before changing the device name"
+        )
+    name = " ".join(evt.args)
+    if name == "-":
+        name = None
+    elif len(name) > 64:
+        return await evt.reply("The device name can be at most 64 characters long")
+    evt.sender.device_name = name
+    await evt.sender.save()
+    if evt.sender.client:
+        # The device info is only sent when connecting, so reconnect to apply the new name
+        was_logged_in = await evt.sender.is_logged_in()
+        await evt.sender.stop()
+        if was_logged_in:
+            await evt.sender.start()
+    if name:
+        return await evt.reply(f"Device name changed to `{name}`")
+    return await evt.reply("Device name reset to the default from the bridge config")
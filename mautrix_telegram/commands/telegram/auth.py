@@ -50,6 +50,7 @@ from mautrix.util.format_duration import format_duration as fmt_duration
 from ... import user as u
 from ...commands import SECTION_AUTH, CommandEvent, command_handler
 from ...types import TelegramID
+from ...util.session_import import SessionImportError, parse_session_string
 
 try:
     from telethon.tl.custom import QRLogin
@@ -264,6 +265,44 @@ async def _request_code(
         evt.sender.command_status = next_status if ok else None
 
 
+@command_handler(
+    needs_auth=False,
+    management_only=True,
+    help_section=SECTION_AUTH,
+    help_args="<_session string_>",
+    help_text=(
+        "Log in by importing the session of another client. Supports Telethon StringSessions, "
+        "Pyrogram session strings and `<dc id>:<hex auth key>`."
+    ),
+)
+async def login_session(evt: CommandEvent) -> EventID:
+    if len(evt.args) != 1:
+        return await evt.reply("**Usage:** `$cmdprefix+sp login-session <session string>`")
+    await evt.redact()
+    if not evt.config.get("bridge.allow_matrix_login", True):
+        return await evt.reply(
+            "This bridge instance does not allow in-Matrix login. "
+            "Please use `$cmdprefix+sp login` to get login instructions"
+        )
+    elif await evt.sender.is_logged_in():
+        return await evt.reply(f"You are already logged in as {evt.sender.human_tg_id}.")
+    try:
+        user = await evt.sender.import_session(parse_session_string(evt.args[0]))
+    except SessionImportError as e:
+        return await evt.reply(f"Failed to import session: {e}")
+    except FloodWaitError as e:
+        return await evt.reply(
+            "Too many login attempts with that session. "
+            f"Please wait for {fmt_duration(e.seconds)} before trying again."
+        )
+    except Exception:
+        evt.log.exception("Error importing session")
+        return await evt.reply(
+            "Unhandled exception while importing session. Check console for more details."
+        )
+    return await _finish_sign_in(evt, user)
+
+
 @command_handler(needs_auth=False)
 async def enter_phone_or_token(evt: CommandEvent) -> EventID | None:
     if len(evt.args) == 0:
@@ -276,8 +315,18 @@ async def enter_phone_or_token(evt: CommandEvent) -> EventID | None:
 
     # phone numbers don't contain colons but telegram bot auth tokens do
     if evt.args[0].find(":") > 0:
+        await evt.redact()
         try:
             await _sign_in(evt, bot_token=evt.args[0])
+        except AccessTokenInvalidError:
+            return await evt.reply("That bot token is not valid.")
+        except AccessTokenExpiredError:
+            return await evt.reply("That bot token has expired.")
+        except FloodWaitError as e:
+            return await evt.reply(
+                "Too many login attempts with that bot token. "
+                f"Please wait for {fmt_duration(e.seconds)} before trying again."
+            )
         except Exception:
             evt.log.exception("Error sending auth token")
             return await evt.reply(
@@ -323,10 +372,6 @@ async def enter_password(evt: CommandEvent) -> EventID | None:
             login_as=evt.sender.command_status.get("login_as", None),
             password=" ".join(evt.args),
         )
-    except AccessTokenInvalidError:
-        return await evt.reply("That bot token is not valid.")
-    except AccessTokenExpiredError:
-        return await evt.reply("That bot token has expired.")
     except Exception:
         evt.log.exception("Error sending password")
         return await evt.reply(
@@ -366,6 +411,9 @@ async def _finish_sign_in(evt: CommandEvent, user: User, login_as: u.User = None
             f"[{existing_user.displayname}] (https://matrix.to/#/{existing_user.mxid})"
             " was logged out from the account."
         )
+    if login_as == evt.sender and evt.is_management:
+        evt.sender.notice_room = evt.room_id
+        await evt.sender.save()
     background_task.create(login_as.post_login(user, first_login=True))
     evt.sender.command_status = None
     name = f"@{user.username}" if user.username else f"+{user.phone}"
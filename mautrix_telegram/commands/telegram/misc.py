@@ -18,6 +18,7 @@ from __future__ import annotations
 from typing import cast
 import base64
 import codecs
+import json
 import re
 import shlex
 
@@ -29,20 +30,31 @@ from telethon.errors import (
     InviteHashInvalidError,
     InviteRequestSentError,
     OptionsTooMuchError,
+    RPCError,
+    StickersetInvalidError,
     UserAlreadyParticipantError,
+    UsernameInvalidError,
+    UsernameNotOccupiedError,
 )
 from telethon.tl.functions.channels import JoinChannelRequest
 from telethon.tl.functions.contacts import DeleteByPhonesRequest, ImportContactsRequest
 from telethon.tl.functions.messages import (
     CheckChatInviteRequest,
     GetBotCallbackAnswerRequest,
+    GetStickerSetRequest,
     ImportChatInviteRequest,
     SendVoteRequest,
 )
 from telethon.tl.patched import Message
 from telethon.tl.types import (
+    Channel,
     InputMediaDice,
     InputPhoneContact,
+    InputStickerSetShortName,
+    KeyboardButtonCallback,
+    KeyboardButtonGame,
+    KeyboardButtonUrl,
+    KeyboardButtonUrlAuth,
     MessageMediaGame,
     MessageMediaPoll,
     TypeInputPeer,
@@ -50,9 +62,11 @@ from telethon.tl.types import (
     User as TLUser,
 )
 from telethon.tl.types.contacts import ImportedContacts
-from telethon.tl.types.messages import BotCallbackAnswer
+from telethon.tl.types.messages import BotCallbackAnswer, StickerSet
 
+from mautrix.errors import IntentError, MatrixRequestError, MForbidden
 from mautrix.types import EventID, Format
+from mautrix.util.format_duration import format_duration
 
 from ... import portal as po, puppet as pu
 from ...abstract_user import AbstractUser
@@ -64,7 +78,11 @@ from ...commands import (
     command_handler,
 )
 from ...db import Message as DBMessage
+from ...formatter.from_telegram import message_link_regex, parse_bot_start_link
+from ...portal_util import get_inline_buttons
+from ...portal_util.emote_pack import RoomEmotes, import_sticker_pack, sticker_short_name_regex
 from ...types import TelegramID
+from ..portal.util import user_has_power_level
 
 
 @command_handler(
@@ -140,15 +158,18 @@ async def search(evt: CommandEvent) -> EventID:
     help_text=(
         "Open a private chat with the given Telegram user. You can also use a "
         "phone number instead of username, but you must have the number in "
-        "your Telegram contacts for that to work."
+        "your Telegram contacts for that to work. Bots can also be started with "
+        "a `t.me/bot?start=payload` link."
     ),
 )
 async def pm(evt: CommandEvent) -> EventID:
     if len(evt.args) == 0:
         return await evt.reply("**Usage:** `$cmdprefix+sp pm <username>`")
 
+    id, start_param = parse_bot_start_link("".join(evt.args))
     try:
-        id = "".join(evt.args).translate({ord(c): None for c in "+()- "})
+        if not start_param:
+            id = id.translate({ord(c): None for c in "+()- "})
         user = await evt.sender.client.get_entity(id)
     except ValueError:
         return await evt.reply("Invalid user identifier or user not found.")
@@ -157,9 +178,18 @@ async def pm(evt: CommandEvent) -> EventID:
         return await evt.reply("User not found.")
     elif not isinstance(user, TLUser):
         return await evt.reply("That doesn't seem to be a user.")
+    elif start_param and not user.bot:
+        return await evt.reply("Start parameters can only be used with bots.")
     portal = await po.Portal.get_by_entity(user, tg_receiver=evt.sender.tgid)
     await portal.create_matrix_room(evt.sender, user, [evt.sender.mxid])
     displayname, _ = pu.Puppet.get_displayname(user, False)
+    if start_param:
+        try:
+            await portal.start_bot(evt.sender, start_param)
+        except RPCError as e:
+            return await evt.reply(
+                f"Created private chat room with {displayname}, but failed to start the bot: {e}"
+            )
     return await evt.reply(f"Created private chat room with {displayname}")
 
 
@@ -250,23 +280,40 @@ async def _join(
         except InviteRequestSentError:
             return None, await evt.reply("Invite request sent successfully.")
     else:
-        channel = await evt.sender.client.get_entity(identifier)
+        try:
+            channel = await evt.sender.client.get_entity(identifier)
+        except (ValueError, UsernameInvalidError, UsernameNotOccupiedError):
+            channel = None
+        except RPCError as e:
+            return None, await evt.reply(f"Failed to resolve username: {e}")
         if not channel:
             return None, await evt.reply("Channel/supergroup not found.")
-        return await evt.sender.client(JoinChannelRequest(channel)), None
+        elif not isinstance(channel, Channel):
+            return None, await evt.reply(
+                "That username belongs to a user. Use `$cmdprefix+sp pm` to start a private chat."
+            )
+        try:
+            return await evt.sender.client(JoinChannelRequest(channel)), None
+        except InviteRequestSentError:
+            return None, await evt.reply("Join request sent successfully.")
+        except RPCError as e:
+            return None, await evt.reply(f"Failed to join chat: {e}")
 
 
 @command_handler(
     help_section=SECTION_CREATING_PORTALS,
-    help_args="<_link_>",
-    help_text="Join a chat with an invite link.",
+    help_args="<_link_|_@username_>",
+    help_text="Join a chat with an invite link or a public channel/supergroup by its username.",
 )
 async def join(evt: CommandEvent) -> EventID | None:
     if len(evt.args) == 0:
-        return await evt.reply("**Usage:** `$cmdprefix+sp join <invite link>`")
+        return await evt.reply("**Usage:** `$cmdprefix+sp join <invite link or @username>`")
 
     url = evt.args[0]
-    if evt.config["bridge.invite_link_resolve"]:
+    if url.startswith("@"):
+        updates, _ = await _join(evt, url[1:], None)
+        return await _create_joined_portal(evt, updates)
+    elif evt.config["bridge.invite_link_resolve"]:
         try:
             async with ClientSession() as sess, sess.get(url) as resp:
                 url = str(resp.url)
@@ -290,6 +337,10 @@ async def join(evt: CommandEvent) -> EventID | None:
         link_type = "joinchat"
         identifier = identifier[1:]
     updates, _ = await _join(evt, identifier, link_type)
+    return await _create_joined_portal(evt, updates)
+
+
+async def _create_joined_portal(evt: CommandEvent, updates: TypeUpdates | None) -> EventID | None:
     if not updates:
         return None
 
@@ -339,6 +390,19 @@ async def sync(evt: CommandEvent) -> EventID:
     return await evt.reply("Synchronization complete.")
 
 
+@command_handler(
+    help_section=SECTION_MISC,
+    help_text=(
+        "Do a full resync of your chat list, including archived chats and chats beyond the "
+        "normal sync limit."
+    ),
+)
+async def sync_chats(evt: CommandEvent) -> EventID:
+    await evt.reply("Resynchronizing all chats, this may take a while...")
+    await evt.sender.sync_dialogs(full=True)
+    return await evt.reply("Chat list resync complete.")
+
+
 PEER_TYPE_CHAT = b"g"
 
 
@@ -382,6 +446,52 @@ async def _parse_encoded_msgid(
     return peer, cast(Message, msg)
 
 
+@command_handler(
+    help_section=SECTION_MISC,
+    help_args="<_t.me link_|_message ID_>",
+    help_text="Convert a Telegram message into a Matrix event without sending it, to help with "
+    "debugging formatting and media problems. Message IDs are looked up in the current portal.",
+)
+async def debug_convert(evt: CommandEvent) -> EventID:
+    if len(evt.args) < 1:
+        return await evt.reply(
+            "**Usage:** `$cmdprefix+sp debug-convert <t.me link or message ID>`"
+        )
+
+    link_match = message_link_regex.match(evt.args[0])
+    if link_match:
+        group, msg_id = link_match.groups()
+        if group.lower().startswith("c/"):
+            portal = await po.Portal.get_by_tgid(TelegramID(int(group[2:])))
+        else:
+            portal = await po.Portal.find_by_username(group)
+    elif evt.args[0].isdecimal() and evt.is_portal:
+        portal, msg_id = evt.portal, evt.args[0]
+    else:
+        return await evt.reply(
+            "Please give a t.me message link, or a message ID when using the command in a portal."
+        )
+    if not portal:
+        return await evt.reply("That chat isn't bridged.")
+
+    try:
+        msg = await evt.sender.client.get_messages(
+            entity=await portal.get_input_entity(evt.sender), ids=int(msg_id)
+        )
+    except (ValueError, RPCError) as e:
+        return await evt.reply(f"Failed to get message: {e}")
+    if not msg:
+        return await evt.reply("Message not found.")
+    elif not isinstance(msg, Message):
+        return await evt.reply("Service messages aren't supported by debug-convert.")
+    data = await portal.debug_convert(evt.sender, msg)
+    if not data:
+        return await evt.reply("The message was not converted into any Matrix event.")
+    return await evt.reply(
+        f"```json\n{json.dumps(data, indent=2, ensure_ascii=False)}\n```", allow_html=False
+    )
+
+
 @command_handler(
     help_section=SECTION_MISC, help_args="<_play ID_>", help_text="Play a Telegram game."
 )
@@ -413,6 +523,53 @@ async def play(evt: CommandEvent) -> EventID:
     )
 
 
+@command_handler(
+    help_section=SECTION_MISC,
+    help_args="<_click ID_> <_button number_>",
+    help_text="Click a button in the inline keyboard of a Telegram bot message.",
+)
+async def click(evt: CommandEvent) -> EventID:
+    if len(evt.args) < 2 or not evt.args[1].isdecimal():
+        return await evt.reply("**Usage:** `$cmdprefix+sp click <click ID> <button number>`")
+    elif not await evt.sender.is_logged_in():
+        return await evt.reply("You must be logged in with a real account to click buttons.")
+    elif evt.sender.is_bot:
+        return await evt.reply("Bots can't click buttons :(")
+
+    try:
+        peer, msg = await _parse_encoded_msgid(evt.sender, evt.args[0], type_name="click")
+    except MessageIDError as e:
+        return await evt.reply(e.message)
+
+    buttons = get_inline_buttons(msg)
+    index = int(evt.args[1])
+    if not 1 <= index <= len(buttons):
+        return await evt.reply(f"Invalid button number, the message has {len(buttons)} buttons")
+    button = buttons[index - 1]
+    if isinstance(button, (KeyboardButtonUrl, KeyboardButtonUrlAuth)):
+        return await evt.reply(f"[{button.text}]({button.url})")
+    elif isinstance(button, KeyboardButtonCallback):
+        if button.requires_password:
+            return await evt.reply("Buttons that require your password can't be clicked here.")
+        request = GetBotCallbackAnswerRequest(peer=peer, msg_id=msg.id, data=button.data)
+    elif isinstance(button, KeyboardButtonGame):
+        request = GetBotCallbackAnswerRequest(peer=peer, msg_id=msg.id, game=True)
+    else:
+        return await evt.reply("That type of button can only be clicked in a Telegram app.")
+
+    try:
+        answer = await evt.sender.client(request)
+    except RPCError as e:
+        return await evt.reply(f"Failed to click button: {e}")
+    if not isinstance(answer, BotCallbackAnswer):
+        return await evt.reply("Button click response invalid")
+    elif answer.url:
+        return await evt.reply(f"The bot answered with a link: {answer.url}")
+    elif answer.message:
+        return await evt.reply(f"The bot answered: {answer.message}")
+    return await evt.reply(f"Clicked {button.text}")
+
+
 @command_handler(
     help_section=SECTION_MISC,
     help_args="<_poll ID_> <_choice number_>",
@@ -492,6 +649,35 @@ async def random(evt: CommandEvent) -> EventID:
         return await evt.reply("Invalid emoji for randomization")
 
 
+@command_handler(
+    help_section=SECTION_MISC,
+    help_args="[`-n` _number_] <_query_>",
+    help_text="Search for a GIF with Telegram's @gif bot and send it to the chat. "
+    "Sends the first result unless another number is given with `-n`.",
+)
+async def gif(evt: CommandEvent) -> EventID | None:
+    if not evt.is_portal:
+        return await evt.reply("You can only send GIFs in portal rooms")
+    elif evt.sender.is_bot:
+        return await evt.reply("Bots can't use inline bots")
+    args = list(evt.args)
+    index = 0
+    if len(args) > 2 and args[0] == "-n" and args[1].isdecimal() and int(args[1]) > 0:
+        index = int(args[1]) - 1
+        args = args[2:]
+    if not args:
+        return await evt.reply("**Usage:** `$cmdprefix+sp gif [-n <number>] <query>`")
+    peer = await evt.portal.get_input_entity(evt.sender)
+    try:
+        results = await evt.sender.client.inline_query("gif", " ".join(args), entity=peer)
+        if len(results) <= index:
+            return await evt.reply("No GIFs found" if not results else "Not enough GIFs found")
+        await results[index].click(peer)
+    except RPCError as e:
+        return await evt.reply(f"Failed to send GIF: {e}")
+    return None
+
+
 @command_handler(
     help_section=SECTION_PORTAL_MANAGEMENT,
     help_args="[_limit_]",
@@ -514,3 +700,112 @@ async def backfill(evt: CommandEvent) -> None:
         return
     output = await portal.forward_backfill(evt.sender, initial=False, override_limit=limit)
     await evt.reply(output)
+
+
+@command_handler(
+    help_section=SECTION_MISC,
+    help_args="[`status`|`retry`|`cancel`|`restart`]",
+    help_text="View or control the progress of backfilling message history with a takeout.",
+)
+async def takeout(evt: CommandEvent) -> EventID:
+    action = evt.args[0].lower() if evt.args else "status"
+    if action == "cancel":
+        if not evt.sender.cancel_takeout():
+            return await evt.reply("There's no takeout or backfill running.")
+        return await evt.reply(
+            "Takeout cancelled. Use `$cmdprefix+sp takeout restart` to start it again."
+        )
+    elif action == "restart":
+        if not evt.config["bridge.backfill.enable"]:
+            return await evt.reply("Backfilling is disabled in the bridge config")
+        evt.sender.restart_takeout()
+        return await evt.reply("Takeout restarted.")
+    elif action == "retry":
+        if not evt.sender.takeout_requested:
+            return await evt.reply("There's no takeout request waiting for approval.")
+        evt.sender.takeout_retry_immediate.set()
+        return await evt.reply("Retrying takeout.")
+    elif action != "status":
+        return await evt.reply("**Usage:** `$cmdprefix+sp takeout [status|retry|cancel|restart]`")
+
+    status = await evt.sender.get_takeout_status()
+    if status["state"] == "pending":
+        return await evt.reply(
+            "Waiting for the data export request to be accepted in the Service Notifications "
+            "chat on another Telegram client. Use `$cmdprefix+sp takeout retry` after accepting."
+        )
+    elif status["state"] == "running":
+        eta = (
+            f", about {format_duration(status['eta_seconds'])} left"
+            if status["eta_seconds"] is not None
+            else ""
+        )
+        return await evt.reply(
+            f"Backfilling: {status['chats_done']} chats done "
+            f"({status['requests_done']} batches, {status['requests_remaining']} queued{eta})."
+        )
+    elif status["state"] == "cancelled":
+        return await evt.reply(
+            f"Takeout was cancelled with {status['requests_remaining']} batches still queued."
+        )
+    return await evt.reply(
+        f"No takeout is running. {status['requests_remaining']} backfill batches are queued."
+    )
+
+
+sticker_link_regex = re.compile(
+    r"(?:https?://)?t(?:elegram)?\.(?:dog|me)/addstickers/(?P<name>[A-Za-z0-9_]+)/?",
+    flags=re.IGNORECASE,
+)
+
+
+@command_handler(
+    help_section=SECTION_MISC,
+    help_args="<_sticker pack link or short name_>",
+    help_text="Import a Telegram sticker pack into the current room as a Matrix sticker pack.",
+)
+async def import_stickers(evt: CommandEvent) -> EventID:
+    if len(evt.args) == 0:
+        return await evt.reply("**Usage:** `$cmdprefix+sp import-stickers <link or short name>`")
+    match = sticker_link_regex.fullmatch(evt.args[0])
+    short_name = match.group("name") if match else evt.args[0]
+    if not sticker_short_name_regex.fullmatch(short_name):
+        return await evt.reply("That doesn't look like a sticker pack link or short name.")
+
+    intent = evt.portal.main_intent if evt.portal else evt.az.intent
+    try:
+        await intent.ensure_joined(evt.room_id)
+    except (MatrixRequestError, IntentError):
+        evt.log.warning(f"Failed to join {evt.room_id} to import stickers", exc_info=True)
+        return await evt.reply("The bridge failed to join this room to add the sticker pack.")
+    if not await user_has_power_level(evt.room_id, intent, evt.sender, RoomEmotes):
+        return await evt.reply(
+            "You do not have the permissions to add sticker packs to this room."
+        )
+
+    try:
+        sticker_set: StickerSet = await evt.sender.client(
+            GetStickerSetRequest(InputStickerSetShortName(short_name), hash=0)
+        )
+    except StickersetInvalidError:
+        return await evt.reply("That sticker pack doesn't exist.")
+    except RPCError as e:
+        return await evt.reply(f"Failed to get the sticker pack: {po.humanize_rpc_error(e)}")
+
+    await evt.reply(
+        f"Importing {len(sticker_set.documents)} stickers from {sticker_set.set.title}..."
+    )
+    try:
+        count = await import_sticker_pack(
+            evt.sender, intent, evt.room_id, sticker_set, evt.config, evt.log
+        )
+    except MForbidden:
+        return await evt.reply(
+            "The bridge doesn't have the permission to add sticker packs to this room."
+        )
+    except MatrixRequestError as e:
+        evt.log.warning(f"Failed to send sticker pack to {evt.room_id}: {e}")
+        return await evt.reply(f"Failed to add the sticker pack to this room: {e.message}")
+    if not count:
+        return await evt.reply("Failed to import any stickers from that pack.")
+    return await evt.reply(f"Imported {count} stickers from {sticker_set.set.title}.")
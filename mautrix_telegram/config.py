@@ -84,6 +84,10 @@ class Config(BaseBridgeConfig):
         if base["appservice.provisioning.shared_secret"] == "generate":
             base["appservice.provisioning.shared_secret"] = self._new_token()
 
+        copy("appservice.webhook.enabled")
+        copy("appservice.webhook.prefix")
+        copy_dict("appservice.webhook.hooks")
+
         if "pool_size" in base["appservice.database_opts"]:
             pool_size = base["appservice.database_opts"].pop("pool_size")
             base["appservice.database_opts.min_size"] = pool_size
@@ -93,6 +97,10 @@ class Config(BaseBridgeConfig):
 
         copy("metrics.enabled")
         copy("metrics.listen_port")
+        copy("tracing.enabled")
+        copy("tracing.endpoint")
+        copy("tracing.headers")
+        copy("tracing.service_name")
 
         copy("bridge.username_template")
         copy("bridge.alias_template")
@@ -102,12 +110,18 @@ class Config(BaseBridgeConfig):
         copy("bridge.displayname_max_length")
         copy("bridge.allow_avatar_remove")
         copy("bridge.allow_contact_info")
+        copy("bridge.stale_ghost_refresh.max_age")
+        copy("bridge.stale_ghost_refresh.interval")
 
         copy("bridge.max_initial_member_sync")
         copy("bridge.max_member_count")
+        copy("bridge.skip_membership_actions_member_count")
         copy("bridge.sync_channel_members")
         copy("bridge.skip_deleted_members")
         copy("bridge.startup_sync")
+        copy("bridge.consistency_check.enabled")
+        copy("bridge.consistency_check.cleanup")
+        copy("bridge.personal_filtering_spaces")
         if "bridge.sync_dialog_limit" in self:
             base["bridge.sync_create_limit"] = self["bridge.sync_dialog_limit"]
             base["bridge.sync_update_limit"] = self["bridge.sync_dialog_limit"]
@@ -134,18 +148,29 @@ class Config(BaseBridgeConfig):
         copy("bridge.telegram_link_preview")
         copy("bridge.invite_link_resolve")
         copy("bridge.caption_in_message")
+        copy("bridge.metadata_only")
+        copy("bridge.policy_lists")
+        copy("bridge.policy_list_report")
+        copy("bridge.scheduled_message_notices")
         copy("bridge.image_as_file_size")
         copy("bridge.image_as_file_pixels")
+        copy("bridge.album_batch_window")
         copy("bridge.document_as_link_size.bot")
         copy("bridge.document_as_link_size.channel")
         copy("bridge.parallel_file_transfer")
         copy("bridge.federate_rooms")
         copy("bridge.always_custom_emoji_reaction")
+        copy("bridge.allow_paid_reactions")
+        copy("bridge.custom_emoji_pack")
+        copy("bridge.bridge_wallpapers")
+        copy("bridge.contact_vcard_file")
         copy("bridge.animated_sticker.target")
         copy("bridge.animated_sticker.convert_from_webm")
         copy("bridge.animated_sticker.args.width")
         copy("bridge.animated_sticker.args.height")
         copy("bridge.animated_sticker.args.fps")
+        copy("bridge.round_video.target")
+        copy("bridge.round_video.args.size")
         copy("bridge.animated_emoji.target")
         copy("bridge.animated_emoji.args.width")
         copy("bridge.animated_emoji.args.height")
@@ -170,7 +195,17 @@ class Config(BaseBridgeConfig):
         copy("bridge.archive_tag")
         copy("bridge.tag_only_on_create")
         copy("bridge.bridge_matrix_leave")
+        copy("bridge.matrix_leave_archive_only")
+        copy("bridge.archive_left_channels")
+        copy("bridge.channel_comment_threads")
+        copy("bridge.group_read_receipts")
         copy("bridge.kick_on_logout")
+        copy("bridge.rejoin_kicked_ghosts")
+        copy("bridge.power_level_resync_interval")
+        copy("bridge.chat_resync_interval")
+        copy("bridge.post_stats.refresh_interval")
+        copy("bridge.post_stats.recent_posts")
+        copy("bridge.birthday_notices")
         copy("bridge.always_read_joined_telegram_notice")
         copy("bridge.backfill.enable")
         copy("bridge.backfill.normal_groups")
@@ -196,6 +231,8 @@ class Config(BaseBridgeConfig):
             copy("bridge.backfill.forward_limits.sync.supergroup")
             copy("bridge.backfill.forward_limits.sync.channel")
         copy("bridge.backfill.forward_timeout")
+        copy("bridge.backfill.request_delay")
+        copy("bridge.backfill.use_takeout")
         copy("bridge.backfill.incremental.messages_per_batch")
         copy("bridge.backfill.incremental.post_batch_delay")
         copy("bridge.backfill.incremental.max_batches.user")
@@ -207,6 +244,7 @@ class Config(BaseBridgeConfig):
         copy("bridge.initial_power_level_overrides.user")
 
         copy("bridge.bot_messages_as_notices")
+        copy("bridge.silent_bot_messages")
         if isinstance(self["bridge.bridge_notices"], bool):
             base["bridge.bridge_notices"]["default"] = self["bridge.bridge_notices"]
         else:
@@ -228,6 +266,7 @@ class Config(BaseBridgeConfig):
         copy("bridge.filter.users")
 
         copy("bridge.command_prefix")
+        copy("bridge.create_notice_room")
 
         migrate_permissions = (
             "bridge.permissions" not in self
@@ -256,6 +295,8 @@ class Config(BaseBridgeConfig):
             copy("bridge.relaybot.whitelist_group_admins")
             copy("bridge.relaybot.whitelist")
             copy("bridge.relaybot.ignore_own_incoming_events")
+            copy("bridge.relaybot.allow_relay_user")
+            copy("bridge.relaybot.relay_user_admin_only")
 
         copy("telegram.api_id")
         copy("telegram.api_hash")
@@ -264,6 +305,9 @@ class Config(BaseBridgeConfig):
         copy("telegram.catch_up")
         copy("telegram.sequential_updates")
         copy("telegram.exit_on_update_error")
+        copy("telegram.update_error_backoff.initial")
+        copy("telegram.update_error_backoff.max")
+        copy("telegram.update_error_backoff.alert")
         copy("telegram.force_refresh_interval_seconds")
 
         copy("telegram.connection.timeout")
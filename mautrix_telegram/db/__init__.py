@@ -24,6 +24,7 @@ from .puppet import Puppet
 from .reaction import Reaction
 from .telegram_file import TelegramFile
 from .telethon_session import PgSession
+from .ttl_media import TTLMedia
 from .upgrade import upgrade_table
 from .user import User
 
@@ -40,6 +41,7 @@ def init(db: Database) -> None:
         PgSession,
         DisappearingMessage,
         Backfill,
+        TTLMedia,
     ):
         table.db = db
 
@@ -57,4 +59,5 @@ __all__ = [
     "PgSession",
     "DisappearingMessage",
     "Backfill",
+    "TTLMedia",
 ]
@@ -34,6 +34,9 @@ fake_db = Database.create("") if TYPE_CHECKING else None
 class BackfillType(Enum):
     HISTORICAL = "historical"
     SYNC_DIALOG = "sync_dialog"
+    # Backfills requested through the provisioning API. They work like historical backfills,
+    # but don't replace the queued incremental backfill of the portal or each other.
+    ON_DEMAND = "on_demand"
 
 
 @dataclass
@@ -135,6 +138,11 @@ class Backfill:
             await cls.db.fetchrow(q, user_mxid, datetime.now() - timedelta(minutes=15))
         )
 
+    @classmethod
+    async def get(cls, queue_id: int) -> Backfill | None:
+        q = f"SELECT queue_id, {cls.columns_str} FROM backfill_queue WHERE queue_id=$1"
+        return cls._from_row(await cls.db.fetchrow(q, queue_id))
+
     @classmethod
     async def delete_existing(
         cls,
@@ -168,6 +176,11 @@ class Backfill:
             await cls.db.fetchrow(q, user_mxid, portal_tgid, portal_tg_receiver, type.value)
         )
 
+    @classmethod
+    async def count_remaining(cls, user_mxid: UserID) -> int:
+        q = "SELECT COUNT(*) FROM backfill_queue WHERE user_mxid=$1 AND completed_at IS NULL"
+        return await cls.db.fetchval(q, user_mxid)
+
     @classmethod
     async def delete_all(cls, user_mxid: UserID, conn: Connection | None = None) -> None:
         await (conn or cls.db).execute("DELETE FROM backfill_queue WHERE user_mxid=$1", user_mxid)
@@ -194,13 +207,15 @@ class Backfill:
         RETURNING queue_id
         """
         async with self.db.acquire() as conn, conn.transaction():
-            deleted_rows = await conn.fetch(
-                delete_q,
-                self.user_mxid,
-                self.portal_tgid,
-                self.portal_tg_receiver,
-                self.type.value,
-            )
+            deleted_rows = []
+            if self.type != BackfillType.ON_DEMAND:
+                deleted_rows = await conn.fetch(
+                    delete_q,
+                    self.user_mxid,
+                    self.portal_tgid,
+                    self.portal_tg_receiver,
+                    self.type.value,
+                )
             self.queue_id = await conn.fetchval(
                 q,
                 self.user_mxid,
@@ -50,11 +50,21 @@ class DisappearingMessage(AbstractDisappearingMessage):
     def _from_row(cls, row: asyncpg.Record) -> DisappearingMessage:
         return cls(**row)
 
+    @classmethod
+    async def delete_orphaned(cls) -> int:
+        """Delete rows in rooms that aren't portals anymore and return the number of rows."""
+        where = "room_id NOT IN (SELECT mxid FROM portal WHERE mxid IS NOT NULL)"
+        async with cls.db.acquire() as conn, conn.transaction():
+            count = await conn.fetchval(f"SELECT COUNT(*) FROM disappearing_message WHERE {where}")
+            if count:
+                await conn.execute(f"DELETE FROM disappearing_message WHERE {where}")
+        return count
+
     @classmethod
     async def get(cls, room_id: RoomID, event_id: EventID) -> DisappearingMessage | None:
         q = """
             SELECT room_id, event_id, expiration_seconds, expiration_ts FROM disappearing_message
-            WHERE room_id=$1 AND mxid=$2
+            WHERE room_id=$1 AND event_id=$2
         """
         try:
             return cls._from_row(await cls.db.fetchrow(q, room_id, event_id))
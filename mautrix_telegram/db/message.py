@@ -63,6 +63,16 @@ class Message:
         )
     )
 
+    @classmethod
+    async def delete_orphaned(cls) -> int:
+        """Delete rows in rooms that aren't portals anymore and return the number of rows."""
+        where = "mx_room NOT IN (SELECT mxid FROM portal WHERE mxid IS NOT NULL)"
+        async with cls.db.acquire() as conn, conn.transaction():
+            count = await conn.fetchval(f"SELECT COUNT(*) FROM message WHERE {where}")
+            if count:
+                await conn.execute(f"DELETE FROM message WHERE {where}")
+        return count
+
     @classmethod
     async def get_all_by_tgid(cls, tgid: TelegramID, tg_space: TelegramID) -> list[Message]:
         q = f"SELECT {cls.columns} FROM message WHERE tgid=$1 AND tg_space=$2"
@@ -123,6 +133,14 @@ class Message:
         )
         return cls._from_row(await cls.db.fetchrow(q, mx_room, tg_space))
 
+    @classmethod
+    async def find_last_n(cls, mx_room: RoomID, tg_space: TelegramID, limit: int) -> list[Message]:
+        q = (
+            f"SELECT {cls.columns} FROM message WHERE mx_room=$1 AND tg_space=$2 "
+            f"AND edit_index=0 ORDER BY tgid DESC LIMIT $3"
+        )
+        return [cls._from_row(row) for row in await cls.db.fetch(q, mx_room, tg_space, limit)]
+
     @classmethod
     async def find_first(cls, mx_room: RoomID, tg_space: TelegramID) -> Message | None:
         q = (
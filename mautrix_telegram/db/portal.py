@@ -22,7 +22,7 @@ from asyncpg import Record
 from attr import dataclass
 import attr
 
-from mautrix.types import BatchID, ContentURI, EventID, RoomID
+from mautrix.types import BatchID, ContentURI, EventID, RoomID, UserID
 from mautrix.util.async_db import Database
 
 from ..types import TelegramID
@@ -59,6 +59,16 @@ class Portal:
     photo_id: str | None
     name_set: bool
     avatar_set: bool
+    theme_emoticon: str | None
+
+    relay_user_id: UserID | None
+    ignored_reason: str | None
+    bot_token: str | None
+    ignored_member_limit: int | None
+    ttl_period: int | None
+    noforwards: bool
+    linked_chat_id: TelegramID | None
+    birthday_notice_year: int | None
 
     local_config: dict[str, Any] = attr.ib(factory=lambda: {})
 
@@ -92,6 +102,15 @@ class Portal:
             "name_set",
             "avatar_set",
             "config",
+            "theme_emoticon",
+            "relay_user_id",
+            "ignored_reason",
+            "bot_token",
+            "ignored_member_limit",
+            "ttl_period",
+            "noforwards",
+            "linked_chat_id",
+            "birthday_notice_year",
         )
     )
 
@@ -125,6 +144,20 @@ class Portal:
         rows = await cls.db.fetch(f"SELECT {cls.columns} FROM portal")
         return [cls._from_row(row) for row in rows]
 
+    @classmethod
+    async def all_with_bot_token(cls) -> list[Portal]:
+        q = f"SELECT {cls.columns} FROM portal WHERE bot_token IS NOT NULL"
+        return [cls._from_row(row) for row in await cls.db.fetch(q)]
+
+    @classmethod
+    async def is_bot_token_used(cls, bot_token: str) -> bool:
+        q = "SELECT EXISTS(SELECT 1 FROM portal WHERE bot_token=$1)"
+        return await cls.db.fetchval(q, bot_token)
+
+    @classmethod
+    async def clear_bot_token(cls, bot_token: str) -> None:
+        await cls.db.execute("UPDATE portal SET bot_token=NULL WHERE bot_token=$1", bot_token)
+
     @property
     def _values(self):
         return (
@@ -148,6 +181,15 @@ class Portal:
             self.avatar_set,
             self.megagroup,
             json.dumps(self.local_config) if self.local_config else None,
+            self.theme_emoticon,
+            self.relay_user_id,
+            self.ignored_reason,
+            self.bot_token,
+            self.ignored_member_limit,
+            self.ttl_period,
+            self.noforwards,
+            self.linked_chat_id,
+            self.birthday_notice_year,
         )
 
     async def save(self) -> None:
@@ -157,7 +199,9 @@ class Portal:
             first_event_id=$7, next_batch_id=$8, base_insertion_id=$9,
             sponsored_event_id=$10, sponsored_event_ts=$11, sponsored_msg_random_id=$12,
             username=$13, title=$14, about=$15, photo_id=$16, name_set=$17, avatar_set=$18,
-            megagroup=$19, config=$20
+            megagroup=$19, config=$20, theme_emoticon=$21, relay_user_id=$22,
+            ignored_reason=$23, bot_token=$24, ignored_member_limit=$25, ttl_period=$26,
+            noforwards=$27, linked_chat_id=$28, birthday_notice_year=$29
         WHERE tgid=$1 AND tg_receiver=$2 AND (peer_type=$3 OR true)
         """
         await self.db.execute(q, *self._values)
@@ -181,12 +225,74 @@ class Portal:
             tgid, tg_receiver, peer_type, mxid, avatar_url, encrypted,
             first_event_id, base_insertion_id, next_batch_id,
             sponsored_event_id, sponsored_event_ts, sponsored_msg_random_id,
-            username, title, about, photo_id, name_set, avatar_set, megagroup, config
+            username, title, about, photo_id, name_set, avatar_set, megagroup, config,
+            theme_emoticon, relay_user_id, ignored_reason, bot_token, ignored_member_limit,
+            ttl_period, noforwards, linked_chat_id, birthday_notice_year
         ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18,
-                  $19, $20)
+                  $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $29)
         """
         await self.db.execute(q, *self._values)
 
     async def delete(self) -> None:
         q = "DELETE FROM portal WHERE tgid=$1 AND tg_receiver=$2"
         await self.db.execute(q, self.tgid, self.tg_receiver)
+
+    async def get_send_as_settings(self) -> dict[TelegramID, int]:
+        q = 'SELECT "user", send_as FROM user_send_as WHERE portal=$1 AND portal_receiver=$2'
+        rows = await self.db.fetch(q, self.tgid, self.tg_receiver)
+        return {TelegramID(row["user"]): row["send_as"] for row in rows}
+
+    async def load_mention_keywords(self) -> dict[UserID, list[str]]:
+        q = "SELECT mxid, keyword FROM mention_keyword WHERE portal=$1 AND portal_receiver=$2"
+        keywords: dict[UserID, list[str]] = {}
+        for row in await self.db.fetch(q, self.tgid, self.tg_receiver):
+            keywords.setdefault(UserID(row["mxid"]), []).append(row["keyword"])
+        return keywords
+
+    async def insert_mention_keyword(self, mxid: UserID, keyword: str) -> None:
+        q = (
+            "INSERT INTO mention_keyword (portal, portal_receiver, mxid, keyword) "
+            "VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING"
+        )
+        await self.db.execute(q, self.tgid, self.tg_receiver, mxid, keyword)
+
+    async def delete_mention_keyword(self, mxid: UserID, keyword: str) -> None:
+        q = (
+            "DELETE FROM mention_keyword "
+            "WHERE portal=$1 AND portal_receiver=$2 AND mxid=$3 AND keyword=$4"
+        )
+        await self.db.execute(q, self.tgid, self.tg_receiver, mxid, keyword)
+
+    async def backup_settings(self, settings: dict[str, Any]) -> None:
+        q = (
+            "INSERT INTO portal_settings_backup (tgid, tg_receiver, settings) VALUES ($1, $2, $3) "
+            "ON CONFLICT (tgid, tg_receiver) DO UPDATE SET settings=excluded.settings"
+        )
+        await self.db.execute(q, self.tgid, self.tg_receiver, json.dumps(settings))
+
+    @classmethod
+    async def delete_orphaned_settings_backups(cls) -> int:
+        """
+        Delete settings backups of portals that exist again, as well as backups of private chats
+        whose receiver is no longer logged in. Returns the number of deleted rows.
+        """
+        where = (
+            "(tgid, tg_receiver) IN (SELECT tgid, tg_receiver FROM portal WHERE mxid IS NOT NULL) "
+            "OR (tg_receiver<>tgid AND tg_receiver NOT IN "
+            '(SELECT tgid FROM "user" WHERE tgid IS NOT NULL))'
+        )
+        table = "portal_settings_backup"
+        async with cls.db.acquire() as conn, conn.transaction():
+            count = await conn.fetchval(f"SELECT COUNT(*) FROM {table} WHERE {where}")
+            if count:
+                await conn.execute(f"DELETE FROM {table} WHERE {where}")
+        return count
+
+    async def pop_settings_backup(self) -> dict[str, Any] | None:
+        q = "SELECT settings FROM portal_settings_backup WHERE tgid=$1 AND tg_receiver=$2"
+        settings = await self.db.fetchval(q, self.tgid, self.tg_receiver)
+        if settings is None:
+            return None
+        q = "DELETE FROM portal_settings_backup WHERE tgid=$1 AND tg_receiver=$2"
+        await self.db.execute(q, self.tgid, self.tg_receiver)
+        return json.loads(settings)
@@ -57,6 +57,8 @@ class Puppet:
     access_token: str | None
     next_batch: SyncToken | None
     base_url: URL | None
+    is_deleted: bool
+    info_refreshed_at: int
 
     @classmethod
     def _from_row(cls, row: Record | None) -> Puppet | None:
@@ -70,7 +72,7 @@ class Puppet:
         "id, is_registered, displayname, displayname_source, displayname_contact, "
         "displayname_quality, disable_updates, username, phone, photo_id, avatar_url, "
         "name_set, avatar_set, contact_info_set, is_bot, is_channel, is_premium, "
-        "custom_mxid, access_token, next_batch, base_url"
+        "custom_mxid, access_token, next_batch, base_url, is_deleted, info_refreshed_at"
     )
 
     @classmethod
@@ -117,6 +119,8 @@ class Puppet:
             self.access_token,
             self.next_batch,
             str(self.base_url) if self.base_url else None,
+            self.is_deleted,
+            self.info_refreshed_at,
         )
 
     async def save(self) -> None:
@@ -126,7 +130,7 @@ class Puppet:
             displayname_quality=$6, disable_updates=$7, username=$8, phone=$9, photo_id=$10,
             avatar_url=$11, name_set=$12, avatar_set=$13, contact_info_set=$14, is_bot=$15,
             is_channel=$16, is_premium=$17, custom_mxid=$18, access_token=$19, next_batch=$20,
-            base_url=$21
+            base_url=$21, is_deleted=$22, info_refreshed_at=$23
         WHERE id=$1
         """
         await self.db.execute(q, *self._values)
@@ -137,8 +141,8 @@ class Puppet:
             id, is_registered, displayname, displayname_source, displayname_contact,
             displayname_quality, disable_updates, username, phone, photo_id, avatar_url, name_set,
             avatar_set, contact_info_set, is_bot, is_channel, is_premium, custom_mxid,
-            access_token, next_batch, base_url
+            access_token, next_batch, base_url, is_deleted, info_refreshed_at
         ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18,
-                  $19, $20, $21)
+                  $19, $20, $21, $22, $23)
         """
         await self.db.execute(q, *self._values)
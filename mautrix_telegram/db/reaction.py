@@ -51,6 +51,16 @@ class Reaction:
     async def delete_all(cls, mx_room: RoomID) -> None:
         await cls.db.execute("DELETE FROM reaction WHERE mx_room=$1", mx_room)
 
+    @classmethod
+    async def delete_orphaned(cls) -> int:
+        """Delete rows in rooms that aren't portals anymore and return the number of rows."""
+        where = "mx_room NOT IN (SELECT mxid FROM portal WHERE mxid IS NOT NULL)"
+        async with cls.db.acquire() as conn, conn.transaction():
+            count = await conn.fetchval(f"SELECT COUNT(*) FROM reaction WHERE {where}")
+            if count:
+                await conn.execute(f"DELETE FROM reaction WHERE {where}")
+        return count
+
     @classmethod
     async def get_by_mxid(cls, mxid: EventID, mx_room: RoomID) -> Reaction | None:
         q = f"SELECT {cls.columns} FROM reaction WHERE mxid=$1 AND mx_room=$2"
@@ -86,6 +86,28 @@ class PgSession(MemorySession):
         count = await cls.db.fetchval(q, session_id)
         return count > 0
 
+    @classmethod
+    async def delete_orphaned(cls) -> dict[str, int]:
+        """
+        Delete sessions of Matrix users that no longer exist, as well as any session data that
+        doesn't have a session. Sessions of the relaybot and per-portal bots are kept.
+        Returns the number of deleted rows per table.
+        """
+        counts = {}
+        async with cls.db.acquire() as conn, conn.transaction():
+            for table in cls._tables:
+                if table == "telethon_sessions":
+                    where = (
+                        "session_id<>'bot' AND session_id NOT LIKE 'portal-bot-%' "
+                        "AND session_id NOT IN (SELECT mxid FROM \"user\")"
+                    )
+                else:
+                    where = "session_id NOT IN (SELECT session_id FROM telethon_sessions)"
+                counts[table] = await conn.fetchval(f"SELECT COUNT(*) FROM {table} WHERE {where}")
+                if counts[table]:
+                    await conn.execute(f"DELETE FROM {table} WHERE {where}")
+        return counts
+
     async def save(self) -> None:
         q = (
             "INSERT INTO telethon_sessions (session_id, dc_id, server_address, port, auth_key) "
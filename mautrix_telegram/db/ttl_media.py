@@ -0,0 +1,77 @@
+# mautrix-telegram - A Matrix-Telegram puppeting bridge
+# Copyright (C) 2026 Tulir Asokan
+#
+# This program is free software: you can redistribute it and/or modify
+# it under the terms of the GNU Affero General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# This program is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU Affero General Public License for more details.
+#
+# You should have received a copy of the GNU Affero General Public License
+# along with this program.  If not, see <https://www.gnu.org/licenses/>.
+from __future__ import annotations
+
+from typing import TYPE_CHECKING, ClassVar
+
+from asyncpg import Record
+from attr import dataclass
+
+from mautrix.types import EventID, RoomID
+from mautrix.util.async_db import Database, Scheme
+
+from ..types import TelegramID
+
+fake_db = Database.create("") if TYPE_CHECKING else None
+
+
+@dataclass
+class TTLMedia:
+    """Self-destructing media whose disappearing timer starts when the media is opened."""
+
+    db: ClassVar[Database] = fake_db
+
+    mx_room: RoomID
+    event_id: EventID
+    tgid: TelegramID
+    tg_space: TelegramID
+    ttl: int
+
+    @classmethod
+    def _from_row(cls, row: Record | None) -> TTLMedia | None:
+        if row is None:
+            return None
+        return cls(**row)
+
+    @classmethod
+    async def get_by_tgids(cls, tgids: list[TelegramID], tg_space: TelegramID) -> list[TTLMedia]:
+        if not tgids:
+            return []
+        if cls.db.scheme in (Scheme.POSTGRES, Scheme.COCKROACH):
+            q = (
+                "SELECT mx_room, event_id, tgid, tg_space, ttl FROM ttl_media"
+                " WHERE tgid=ANY($1) AND tg_space=$2"
+            )
+            rows = await cls.db.fetch(q, tgids, tg_space)
+        else:
+            tgid_placeholders = ("?," * len(tgids)).rstrip(",")
+            q = (
+                "SELECT mx_room, event_id, tgid, tg_space, ttl FROM ttl_media "
+                f"WHERE tg_space=? AND tgid IN ({tgid_placeholders})"
+            )
+            rows = await cls.db.fetch(q, tg_space, *tgids)
+        return [cls._from_row(row) for row in rows]
+
+    async def insert(self) -> None:
+        q = (
+            "INSERT INTO ttl_media (mx_room, event_id, tgid, tg_space, ttl) "
+            "VALUES ($1, $2, $3, $4, $5)"
+        )
+        await self.db.execute(q, self.mx_room, self.event_id, self.tgid, self.tg_space, self.ttl)
+
+    async def delete(self) -> None:
+        q = "DELETE FROM ttl_media WHERE mx_room=$1 AND event_id=$2"
+        await self.db.execute(q, self.mx_room, self.event_id)
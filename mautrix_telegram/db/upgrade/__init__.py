@@ -21,4 +21,21 @@ from . import (
     v16_backfill_type,
     v17_message_find_recent,
     v18_puppet_contact_info_set,
+    v19_user_notice_room,
+    v20_puppet_is_deleted,
+    v21_portal_theme_emoticon,
+    v22_user_send_as,
+    v23_portal_relay_user,
+    v24_user_device_name,
+    v25_portal_settings_backup,
+    v26_user_space_room,
+    v27_puppet_info_refreshed_at,
+    v28_ttl_media,
+    v29_portal_ignored,
+    v30_portal_bot_token,
+    v31_mention_keyword,
+    v32_portal_ignored_member_limit,
+    v33_portal_ttl_noforwards,
+    v34_portal_linked_chat,
+    v35_portal_birthday_notice,
 )
@@ -15,7 +15,7 @@
 # along with this program.  If not, see <https://www.gnu.org/licenses/>.
 from mautrix.util.async_db import Connection, Scheme
 
-latest_version = 18
+latest_version = 35
 
 
 async def create_latest_tables(conn: Connection, scheme: Scheme) -> int:
@@ -27,7 +27,10 @@ async def create_latest_tables(conn: Connection, scheme: Scheme) -> int:
             tg_phone       TEXT,
             is_bot         BOOLEAN NOT NULL DEFAULT false,
             is_premium     BOOLEAN NOT NULL DEFAULT false,
-            saved_contacts INTEGER NOT NULL DEFAULT 0
+            saved_contacts INTEGER NOT NULL DEFAULT 0,
+            notice_room    TEXT,
+            device_name    TEXT,
+            space_room     TEXT
         )"""
     )
     await conn.execute(
@@ -47,6 +50,16 @@ async def create_latest_tables(conn: Connection, scheme: Scheme) -> int:
             megagroup   BOOLEAN,
             config      jsonb,
 
+            theme_emoticon TEXT,
+            relay_user_id  TEXT,
+            ignored_reason TEXT,
+            bot_token      TEXT,
+            ignored_member_limit INTEGER,
+            ttl_period INTEGER,
+            noforwards BOOLEAN NOT NULL DEFAULT false,
+            linked_chat_id BIGINT,
+            birthday_notice_year INTEGER,
+
             first_event_id    TEXT,
             next_batch_id     TEXT,
             base_insertion_id TEXT,
@@ -96,6 +109,19 @@ async def create_latest_tables(conn: Connection, scheme: Scheme) -> int:
             PRIMARY KEY (room_id, event_id)
         )"""
     )
+    await conn.execute(
+        """CREATE TABLE ttl_media (
+            mx_room  TEXT,
+            event_id TEXT,
+            tgid     BIGINT NOT NULL,
+            tg_space BIGINT NOT NULL,
+            ttl      INTEGER NOT NULL,
+            PRIMARY KEY (mx_room, event_id),
+            FOREIGN KEY (mx_room, event_id) REFERENCES disappearing_message(room_id, event_id)
+                ON DELETE CASCADE
+        )"""
+    )
+    await conn.execute("CREATE INDEX ttl_media_tgid_idx ON ttl_media (tg_space, tgid)")
     await conn.execute(
         """CREATE TABLE puppet (
             id BIGINT PRIMARY KEY,
@@ -117,6 +143,8 @@ async def create_latest_tables(conn: Connection, scheme: Scheme) -> int:
             is_bot              BOOLEAN,
             is_channel          BOOLEAN NOT NULL DEFAULT false,
             is_premium          BOOLEAN NOT NULL DEFAULT false,
+            is_deleted          BOOLEAN NOT NULL DEFAULT false,
+            info_refreshed_at   BIGINT NOT NULL DEFAULT 0,
 
             access_token TEXT,
             custom_mxid  TEXT,
@@ -160,6 +188,37 @@ async def create_latest_tables(conn: Connection, scheme: Scheme) -> int:
                  ON DELETE CASCADE ON UPDATE CASCADE
         )"""
     )
+    await conn.execute(
+        """CREATE TABLE portal_settings_backup (
+            tgid        BIGINT,
+            tg_receiver BIGINT,
+            settings    jsonb NOT NULL,
+            PRIMARY KEY (tgid, tg_receiver)
+        )"""
+    )
+    await conn.execute(
+        """CREATE TABLE user_send_as (
+            "user"          BIGINT,
+            portal          BIGINT,
+            portal_receiver BIGINT,
+            send_as         BIGINT NOT NULL,
+            PRIMARY KEY ("user", portal, portal_receiver),
+            FOREIGN KEY ("user") REFERENCES "user"(tgid) ON DELETE CASCADE ON UPDATE CASCADE,
+            FOREIGN KEY (portal, portal_receiver) REFERENCES portal(tgid, tg_receiver)
+                 ON DELETE CASCADE ON UPDATE CASCADE
+        )"""
+    )
+    await conn.execute(
+        """CREATE TABLE mention_keyword (
+            portal          BIGINT,
+            portal_receiver BIGINT,
+            mxid            TEXT,
+            keyword         TEXT,
+            PRIMARY KEY (portal, portal_receiver, mxid, keyword),
+            FOREIGN KEY (portal, portal_receiver) REFERENCES portal(tgid, tg_receiver)
+                 ON DELETE CASCADE ON UPDATE CASCADE
+        )"""
+    )
     await conn.execute(
         """CREATE TABLE contact (
             "user"  BIGINT,
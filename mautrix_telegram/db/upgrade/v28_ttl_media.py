@@ -0,0 +1,35 @@
+# mautrix-telegram - A Matrix-Telegram puppeting bridge
+# Copyright (C) 2026 Tulir Asokan
+#
+# This program is free software: you can redistribute it and/or modify
+# it under the terms of the GNU Affero General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# This program is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU Affero General Public License for more details.
+#
+# You should have received a copy of the GNU Affero General Public License
+# along with this program.  If not, see <https://www.gnu.org/licenses/>.
+from mautrix.util.async_db import Connection
+
+from . import upgrade_table
+
+
+@upgrade_table.register(description="Add table for tracking self-destructing media")
+async def upgrade_v28(conn: Connection) -> None:
+    await conn.execute(
+        """CREATE TABLE ttl_media (
+            mx_room  TEXT,
+            event_id TEXT,
+            tgid     BIGINT NOT NULL,
+            tg_space BIGINT NOT NULL,
+            ttl      INTEGER NOT NULL,
+            PRIMARY KEY (mx_room, event_id),
+            FOREIGN KEY (mx_room, event_id) REFERENCES disappearing_message(room_id, event_id)
+                ON DELETE CASCADE
+        )"""
+    )
+    await conn.execute("CREATE INDEX ttl_media_tgid_idx ON ttl_media (tg_space, tgid)")
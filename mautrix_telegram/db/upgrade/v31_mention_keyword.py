@@ -0,0 +1,57 @@
+# mautrix-telegram - A Matrix-Telegram puppeting bridge
+# Copyright (C) 2026 Tulir Asokan
+#
+# This program is free software: you can redistribute it and/or modify
+# it under the terms of the GNU Affero General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# This program is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU Affero General Public License for more details.
+#
+# You should have received a copy of the GNU Affero General Public License
+# along with this program.  If not, see <https://www.gnu.org/licenses/>.
+import json
+
+from mautrix.util.async_db import Connection
+
+from . import upgrade_table
+
+
+@upgrade_table.register(description="Move per-portal mention keywords into a separate table")
+async def upgrade_v31(conn: Connection) -> None:
+    await conn.execute(
+        """CREATE TABLE mention_keyword (
+            portal          BIGINT,
+            portal_receiver BIGINT,
+            mxid            TEXT,
+            keyword         TEXT,
+            PRIMARY KEY (portal, portal_receiver, mxid, keyword),
+            FOREIGN KEY (portal, portal_receiver) REFERENCES portal(tgid, tg_receiver)
+                 ON DELETE CASCADE ON UPDATE CASCADE
+        )"""
+    )
+    q = "SELECT tgid, tg_receiver, config FROM portal WHERE config IS NOT NULL"
+    for row in await conn.fetch(q):
+        config = json.loads(row["config"])
+        keywords = config.pop("mention_keywords", None)
+        if not keywords:
+            continue
+        for mxid, user_keywords in keywords.items():
+            for keyword in user_keywords:
+                await conn.execute(
+                    "INSERT INTO mention_keyword (portal, portal_receiver, mxid, keyword) "
+                    "VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING",
+                    row["tgid"],
+                    row["tg_receiver"],
+                    mxid,
+                    keyword,
+                )
+        await conn.execute(
+            "UPDATE portal SET config=$1 WHERE tgid=$2 AND tg_receiver=$3",
+            json.dumps(config) if config else None,
+            row["tgid"],
+            row["tg_receiver"],
+        )
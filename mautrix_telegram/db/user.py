@@ -20,7 +20,7 @@ from typing import TYPE_CHECKING, ClassVar, Iterable
 from asyncpg import Record
 from attr import dataclass
 
-from mautrix.types import UserID
+from mautrix.types import RoomID, UserID
 from mautrix.util.async_db import Connection, Database, Scheme
 
 from ..types import TelegramID
@@ -40,6 +40,9 @@ class User:
     is_bot: bool
     is_premium: bool
     saved_contacts: int
+    notice_room: RoomID | None = None
+    device_name: str | None = None
+    space_room: RoomID | None = None
 
     @classmethod
     def _from_row(cls, row: Record | None) -> User | None:
@@ -48,7 +51,18 @@ class User:
         return cls(**row)
 
     columns: ClassVar[str] = ", ".join(
-        ("mxid", "tgid", "tg_username", "tg_phone", "is_bot", "is_premium", "saved_contacts")
+        (
+            "mxid",
+            "tgid",
+            "tg_username",
+            "tg_phone",
+            "is_bot",
+            "is_premium",
+            "saved_contacts",
+            "notice_room",
+            "device_name",
+            "space_room",
+        )
     )
 
     @classmethod
@@ -98,20 +112,27 @@ class User:
             self.is_bot,
             self.is_premium,
             self.saved_contacts,
+            self.notice_room,
+            self.device_name,
+            self.space_room,
         )
 
     async def save(self, conn: Connection | None = None) -> None:
         q = """
         UPDATE "user" SET tgid=$2, tg_username=$3, tg_phone=$4, is_bot=$5, is_premium=$6,
-                          saved_contacts=$7
+                          saved_contacts=$7, notice_room=$8, device_name=$9,
+                          space_room=$10
         WHERE mxid=$1
         """
         await (conn or self.db).execute(q, *self._values)
 
     async def insert(self) -> None:
         q = """
-        INSERT INTO "user" (mxid, tgid, tg_username, tg_phone, is_bot, is_premium, saved_contacts)
-        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        INSERT INTO "user" (
+            mxid, tgid, tg_username, tg_phone, is_bot, is_premium, saved_contacts, notice_room,
+            device_name, space_room
+        )
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
         """
         await self.db.execute(q, *self._values)
 
@@ -156,3 +177,21 @@ class User:
     async def unregister_portal(self, tgid: TelegramID, tg_receiver: TelegramID) -> None:
         q = 'DELETE FROM user_portal WHERE "user"=$1 AND portal=$2 AND portal_receiver=$3'
         await self.db.execute(q, self.tgid, tgid, tg_receiver)
+
+    async def get_send_as(self, tgid: TelegramID, tg_receiver: TelegramID) -> int | None:
+        q = 'SELECT send_as FROM user_send_as WHERE "user"=$1 AND portal=$2 AND portal_receiver=$3'
+        return await self.db.fetchval(q, self.tgid, tgid, tg_receiver)
+
+    async def set_send_as(
+        self, tgid: TelegramID, tg_receiver: TelegramID, send_as: int | None
+    ) -> None:
+        if send_as is None:
+            q = 'DELETE FROM user_send_as WHERE "user"=$1 AND portal=$2 AND portal_receiver=$3'
+            await self.db.execute(q, self.tgid, tgid, tg_receiver)
+            return
+        q = (
+            'INSERT INTO user_send_as ("user", portal, portal_receiver, send_as) '
+            "VALUES ($1, $2, $3, $4) "
+            'ON CONFLICT ("user", portal, portal_receiver) DO UPDATE SET send_as=excluded.send_as'
+        )
+        await self.db.execute(q, self.tgid, tgid, tg_receiver, send_as)
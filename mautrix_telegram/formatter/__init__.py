@@ -1,2 +1,2 @@
-from .from_matrix import matrix_reply_to_telegram, matrix_to_telegram
+from .from_matrix import matrix_reply_to_telegram, matrix_to_telegram, split_long_message
 from .from_telegram import telegram_text_to_matrix_html, telegram_to_matrix
@@ -15,11 +15,12 @@
 # along with this program.  If not, see <https://www.gnu.org/licenses/>.
 from __future__ import annotations
 
+import copy
 import re
 
 from telethon import TelegramClient
 from telethon.helpers import add_surrogate, del_surrogate, strip_text
-from telethon.tl.types import MessageEntityItalic, TypeMessageEntity
+from telethon.tl.types import MessageEntityBotCommand, MessageEntityItalic, TypeMessageEntity
 
 from mautrix.types import MessageEventContent, RoomID
 
@@ -27,8 +28,10 @@ from ...db import Message as DBMessage
 from ...types import TelegramID
 from .parser import MatrixParser
 
-command_regex = re.compile(r"^!([A-Za-z0-9@]+)")
-not_command_regex = re.compile(r"^\\(![A-Za-z0-9@]+)")
+# Bot commands can be written with ! instead of /, as most Matrix clients intercept messages
+# starting with a slash. Slash commands that make it through are also marked as commands.
+command_regex = re.compile(r"^[!/]([A-Za-z0-9_@]+)")
+not_command_regex = re.compile(r"^\\([!/][A-Za-z0-9_@]+)")
 
 MAX_LENGTH = 4096
 CUTOFF_TEXT = " [message cut]"
@@ -54,18 +57,22 @@ async def matrix_reply_to_telegram(
 
 
 async def matrix_to_telegram(
-    client: TelegramClient, *, text: str | None = None, html: str | None = None
+    client: TelegramClient,
+    *,
+    text: str | None = None,
+    html: str | None = None,
+    cut: bool = True,
 ) -> tuple[str, list[TypeMessageEntity]]:
     if html is not None:
-        return await _matrix_html_to_telegram(client, html)
+        return await _matrix_html_to_telegram(client, html, cut)
     elif text is not None:
-        return _matrix_text_to_telegram(text)
+        return _matrix_text_to_telegram(text, cut)
     else:
         raise ValueError("text or html must be provided to convert formatting")
 
 
 async def _matrix_html_to_telegram(
-    client: TelegramClient, html: str
+    client: TelegramClient, html: str, cut: bool = True
 ) -> tuple[str, list[TypeMessageEntity]]:
     try:
         html = command_regex.sub(r"<command>\1</command>", html)
@@ -73,7 +80,9 @@ async def _matrix_html_to_telegram(
         html = not_command_regex.sub(r"\1", html)
 
         parsed = await MatrixParser(client).parse(add_surrogate(html))
-        text, entities = _cut_long_message(parsed.text, parsed.telegram_entities)
+        text, entities = parsed.text, parsed.telegram_entities
+        if cut:
+            text, entities = _cut_long_message(text, entities)
         text = del_surrogate(strip_text(text, entities))
 
         return text, entities
@@ -98,13 +107,94 @@ def _cut_long_message(
     return message, entities
 
 
-def _matrix_text_to_telegram(text: str) -> tuple[str, list[TypeMessageEntity]]:
-    text = command_regex.sub(r"/\1", text)
+def _find_split_point(
+    message: str, entities: list[TypeMessageEntity], max_length: int
+) -> int:
+    def inside_entity(pos: int) -> bool:
+        return any(entity.offset < pos < entity.offset + entity.length for entity in entities)
+
+    # Prefer splitting at a line break, then at a space, as long as it's not in the middle of
+    # an entity. Don't bother looking at the first half to avoid creating tiny chunks.
+    min_pos = max_length // 2
+    for separator in ("\n", " "):
+        pos = message.rfind(separator, min_pos, max_length)
+        while pos > 0:
+            if not inside_entity(pos + 1):
+                return pos + 1
+            pos = message.rfind(separator, min_pos, pos)
+    pos = max_length
+    # Don't split surrogate pairs
+    if "\ud800" <= message[pos - 1] <= "\udbff":
+        pos -= 1
+    return pos
+
+
+def _split_entities(
+    entities: list[TypeMessageEntity], pos: int
+) -> tuple[list[TypeMessageEntity], list[TypeMessageEntity]]:
+    before, after = [], []
+    for entity in entities:
+        end = entity.offset + entity.length
+        if entity.offset < pos:
+            before_entity = copy.copy(entity)
+            before_entity.length = min(end, pos) - entity.offset
+            before.append(before_entity)
+        if end > pos:
+            after_entity = copy.copy(entity)
+            after_entity.offset = max(entity.offset - pos, 0)
+            after_entity.length = end - pos - after_entity.offset
+            after.append(after_entity)
+    return before, after
+
+
+def split_long_message(
+    message: str,
+    entities: list[TypeMessageEntity],
+    max_length: int = MAX_LENGTH,
+    first_max_length: int | None = None,
+) -> list[tuple[str, list[TypeMessageEntity]]]:
+    """
+    Split a message that doesn't fit in a single Telegram message into multiple chunks.
+    Splits are done at line breaks or spaces outside formatting entities when possible.
+
+    Args:
+        message: The message text as returned by :meth:`matrix_to_telegram` with ``cut=False``.
+        entities: The formatting entities of the message.
+        max_length: The maximum length of a single chunk in UTF-16 code units.
+        first_max_length: The maximum length of the first chunk, e.g. when the first chunk
+                          will be used as a media caption. Defaults to ``max_length``.
+
+    Returns:
+        A list of text and entity tuples, each of which fits in a single message.
+    """
+    message = add_surrogate(message)
+    entities = entities or []
+    chunks = []
+    limit = first_max_length or max_length
+    while len(message) > limit:
+        pos = _find_split_point(message, entities, limit)
+        chunk_entities, entities = _split_entities(entities, pos)
+        chunk = strip_text(message[:pos], chunk_entities)
+        if chunk:
+            chunks.append((del_surrogate(chunk), chunk_entities))
+        message = message[pos:]
+        limit = max_length
+    message = strip_text(message, entities)
+    if message or not chunks:
+        chunks.append((del_surrogate(message), entities))
+    return chunks
+
+
+def _matrix_text_to_telegram(text: str, cut: bool = True) -> tuple[str, list[TypeMessageEntity]]:
+    entities = []
+    command_match = command_regex.match(text)
+    if command_match:
+        text = command_regex.sub(r"/\1", text)
+        entities.append(MessageEntityBotCommand(offset=0, length=len(command_match.group(0))))
     text = text.replace("\t", " " * 4)
     text = not_command_regex.sub(r"\1", text)
-    entities = []
     surrogated_text = add_surrogate(text)
-    if len(surrogated_text) > MAX_LENGTH:
+    if cut and len(surrogated_text) > MAX_LENGTH:
         surrogated_text, entities = _cut_long_message(surrogated_text, entities)
         text = del_surrogate(surrogated_text)
     return text, entities
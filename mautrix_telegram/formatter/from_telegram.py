@@ -415,6 +415,24 @@ message_link_regex = re.compile(
 )
 
 
+bot_start_link_regex = re.compile(
+    r"(?:(?:https?://)?t(?:elegram)?\.(?:me|dog)/|@)?"
+    r"([A-Za-z][A-Za-z0-9_]{3,31}[A-Za-z0-9])"
+    r"(?:\?start=([A-Za-z0-9_-]{1,64}))?/?"
+)
+
+
+def parse_bot_start_link(identifier: str) -> tuple[str, str | None]:
+    """
+    Split a ``t.me/bot?start=payload`` style link into the username and start parameter.
+    Identifiers that aren't such links are returned as-is without a start parameter.
+    """
+    match = bot_start_link_regex.fullmatch(identifier.strip())
+    if not match:
+        return identifier, None
+    return match.group(1), match.group(2)
+
+
 async def _parse_url(html: list[str], entity_text: str, url: str) -> None:
     url = escape(url) if url else entity_text
     if not url.startswith(("https://", "http://", "ftp://", "magnet://")):
@@ -18,6 +18,8 @@ from __future__ import annotations
 from typing import TYPE_CHECKING
 import sys
 
+from telethon.errors import ChatAboutNotModifiedError, ChatNotModifiedError, RPCError
+
 from mautrix.bridge import BaseMatrixHandler
 from mautrix.types import (
     Event,
@@ -41,6 +43,7 @@ from mautrix.types import (
 
 from . import commands as com, portal as po, puppet as pu, user as u
 from .commands.portal.util import get_initial_state, user_has_power_level, warn_missing_power
+from .portal_util import USER_RULE_TYPES
 from .types import TelegramID
 
 if TYPE_CHECKING:
@@ -155,17 +158,17 @@ class MatrixHandler(BaseMatrixHandler):
                 room_id, user.mxid, "You are not whitelisted on this Telegram bridge."
             )
             return
-        elif not await user.is_logged_in() and not portal.has_bot:
+        elif not await user.is_logged_in() and not portal.has_relay:
             await portal.main_intent.kick_user(
                 room_id,
                 user.mxid,
-                "This chat does not have a bot on the Telegram side for relaying messages sent by"
-                " unauthenticated Matrix users.",
+                "This chat does not have a bot or relay user on the Telegram side for relaying"
+                " messages sent by unauthenticated Matrix users.",
             )
             return
 
         self.log.debug(f"{user.mxid} joined {room_id}")
-        if await user.is_logged_in() or portal.has_bot:
+        if await user.is_logged_in() or portal.has_relay:
             await portal.join_matrix(user, event_id)
 
     async def handle_leave(self, room_id: RoomID, user_id: UserID, event_id: EventID) -> None:
@@ -182,14 +185,17 @@ class MatrixHandler(BaseMatrixHandler):
 
     async def handle_kick_ban(
         self,
-        ban: bool,
+        ban: bool | None,
         room_id: RoomID,
         user_id: UserID,
         sender: UserID,
         reason: str,
         event_id: EventID,
     ) -> None:
-        action = "banned" if ban else "kicked"
+        if ban is None:
+            action, noun = "unbanned", "unban"
+        else:
+            action, noun = ("banned", "ban") if ban else ("kicked", "kick")
         self.log.debug(f"{user_id} was {action} from {room_id} by {sender} for {reason}")
         portal = await po.Portal.get_by_mxid(room_id)
         if not portal or not portal.allow_bridging:
@@ -206,22 +212,33 @@ class MatrixHandler(BaseMatrixHandler):
             return
         await sender.ensure_started()
 
-        puppet = await pu.Puppet.get_by_mxid(user_id)
-        if puppet:
-            if ban:
-                await portal.ban_matrix(puppet, sender)
+        target = await pu.Puppet.get_by_mxid(user_id)
+        if not target:
+            target = await u.User.get_by_mxid(user_id, create=False)
+            if not target:
+                return
+            await target.ensure_started()
+        try:
+            if (
+                ban is not None
+                and isinstance(target, pu.Puppet)
+                and await portal.has_pending_join_request(sender, target.tgid)
+            ):
+                # Rejecting a knock declines the Telegram join request
+                await portal.handle_join_request(sender, target, approved=False)
+            elif ban is None:
+                await portal.unban_matrix(target, sender)
+            elif ban:
+                await portal.ban_matrix(target, sender)
             else:
-                await portal.kick_matrix(puppet, sender)
-            return
-
-        user = await u.User.get_by_mxid(user_id, create=False)
-        if not user:
-            return
-        await user.ensure_started()
-        if ban:
-            await portal.ban_matrix(user, sender)
-        else:
-            await portal.kick_matrix(user, sender)
+                await portal.kick_matrix(target, sender)
+        except RPCError as e:
+            self.log.warning(f"Failed to bridge {action} of {user_id} in {room_id}: {e}")
+            await portal.main_intent.send_notice(
+                room_id,
+                f"Failed to bridge the {noun} of {user_id} to Telegram: "
+                f"{po.humanize_rpc_error(e)}",
+            )
 
     async def handle_kick(
         self, room_id: RoomID, user_id: UserID, kicked_by: UserID, reason: str, event_id: EventID
@@ -231,8 +248,7 @@ class MatrixHandler(BaseMatrixHandler):
     async def handle_unban(
         self, room_id: RoomID, user_id: UserID, unbanned_by: UserID, reason: str, event_id: EventID
     ) -> None:
-        # TODO handle unbans properly instead of handling it as a kick
-        await self.handle_kick_ban(False, room_id, user_id, unbanned_by, reason, event_id)
+        await self.handle_kick_ban(None, room_id, user_id, unbanned_by, reason, event_id)
 
     async def handle_ban(
         self, room_id: RoomID, user_id: UserID, banned_by: UserID, reason: str, event_id: EventID
@@ -247,7 +263,7 @@ class MatrixHandler(BaseMatrixHandler):
 
     @staticmethod
     async def allow_bridging_message(user: u.User, portal: po.Portal) -> bool:
-        return await user.is_logged_in() or portal.has_bot
+        return await user.is_logged_in() or portal.has_relay
 
     @staticmethod
     async def handle_redaction(evt: RedactionEvent) -> None:
@@ -302,7 +318,17 @@ class MatrixHandler(BaseMatrixHandler):
             }[evt_type]
             if not isinstance(content, content_type):
                 return
-            await handler(sender, content[content_key], event_id)
+            try:
+                await handler(sender, content[content_key], event_id)
+            except (ChatNotModifiedError, ChatAboutNotModifiedError):
+                pass
+            except RPCError as e:
+                portal.log.warning(f"Failed to bridge {evt_type} change {event_id}: {e}")
+                field = "avatar" if content_key == "url" else content_key
+                error = po.humanize_rpc_error(e)
+                await portal.main_intent.send_notice(
+                    room_id, f"Failed to change the {field} on Telegram: {error}"
+                )
 
     @staticmethod
     async def handle_room_pin(
@@ -370,7 +396,10 @@ class MatrixHandler(BaseMatrixHandler):
         if not portal or not portal.allow_bridging:
             return
 
+        # Swap the state before awaiting anything, so that a newer typing event handled
+        # concurrently compares against this one rather than an outdated state.
         previously_typing = self._previously_typing.get(room_id, set())
+        self._previously_typing[room_id] = now_typing
 
         for user_id in set(previously_typing | now_typing):
             is_typing = user_id in now_typing
@@ -382,8 +411,6 @@ class MatrixHandler(BaseMatrixHandler):
             if user and await user.is_logged_in():
                 await portal.set_typing(user, is_typing)
 
-        self._previously_typing[room_id] = now_typing
-
     async def handle_ephemeral_event(
         self, evt: ReceiptEvent | PresenceEvent | TypingEvent
     ) -> None:
@@ -420,3 +447,5 @@ class MatrixHandler(BaseMatrixHandler):
             await self.handle_room_upgrade(
                 evt.room_id, evt.sender, evt.content.replacement_room, evt.event_id
             )
+        elif evt.type.t in USER_RULE_TYPES:
+            po.Portal.policy_lists.invalidate(evt.room_id)
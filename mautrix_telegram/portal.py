@@ -27,7 +27,6 @@ from typing import (
     Union,
     cast,
 )
-from collections import defaultdict
 from datetime import datetime
 from html import escape as escape_html
 from sqlite3 import IntegrityError
@@ -36,6 +35,7 @@ import asyncio
 import base64
 import itertools
 import random
+import re
 import time
 
 from asyncpg import UniqueViolationError
@@ -47,6 +47,7 @@ from telethon.errors import (
     EntitiesTooLongError,
     EntityBoundsInvalidError,
     EntityMentionUserInvalidError,
+    FloodWaitError,
     InputUserDeactivatedError,
     MessageEmptyError,
     MessageIdInvalidError,
@@ -67,27 +68,41 @@ from telethon.tl.functions.channels import (
     CreateChannelRequest,
     EditPhotoRequest,
     EditTitleRequest,
+    GetFullChannelRequest,
     InviteToChannelRequest,
     JoinChannelRequest,
+    ReadMessageContentsRequest as ReadChannelMessageContentsRequest,
     UpdateUsernameRequest,
     ViewSponsoredMessageRequest,
 )
+from telethon.tl.functions.folders import EditPeerFoldersRequest
 from telethon.tl.functions.messages import (
     AddChatUserRequest,
     CreateChatRequest,
     EditChatAboutRequest,
     EditChatPhotoRequest,
     EditChatTitleRequest,
+    EditExportedChatInviteRequest,
     ExportChatInviteRequest,
+    GetChatInviteImportersRequest,
+    GetFullChatRequest,
     GetMessageReactionsListRequest,
+    GetMessageReadParticipantsRequest,
     GetMessagesReactionsRequest,
+    GetMessagesViewsRequest,
     GetPeerDialogsRequest,
+    HideChatJoinRequestRequest,
     MigrateChatRequest,
+    ReadMessageContentsRequest,
+    ReportRequest,
+    SaveDefaultSendAsRequest,
     SendReactionRequest,
     SetTypingRequest,
+    StartBotRequest,
     UnpinAllMessagesRequest,
     UpdatePinnedMessageRequest,
 )
+from telethon.tl.functions.users import GetFullUserRequest
 from telethon.tl.patched import Message, MessageService
 from telethon.tl.types import (
     Channel,
@@ -105,16 +120,31 @@ from telethon.tl.types import (
     DocumentAttributeVideo,
     GeoPoint,
     InputChannel,
+    InputChatPhotoEmpty,
     InputChatUploadedPhoto,
     InputDialogPeer,
+    InputFolderPeer,
     InputMediaUploadedDocument,
     InputMediaUploadedPhoto,
+    InputMediaWebPage,
+    InputMessagesFilterPinned,
     InputPeerChannel,
     InputPeerChat,
     InputPeerPhotoFileLocation,
+    InputPeerSelf,
     InputPeerUser,
+    InputReportReasonChildAbuse,
+    InputReportReasonCopyright,
+    InputReportReasonFake,
+    InputReportReasonIllegalDrugs,
+    InputReportReasonOther,
+    InputReportReasonPersonalDetails,
+    InputReportReasonPornography,
+    InputReportReasonSpam,
+    InputReportReasonViolence,
     InputStickerSetEmpty,
     InputUser,
+    InputUserEmpty,
     MessageActionBoostApply,
     MessageActionChannelCreate,
     MessageActionChatAddUser,
@@ -130,11 +160,24 @@ from telethon.tl.types import (
     MessageActionGameScore,
     MessageActionGiftPremium,
     MessageActionGroupCall,
+    MessageActionPaymentSent,
+    MessageActionPaymentSentMe,
     MessageActionPhoneCall,
+    MessageActionSetChatTheme,
+    MessageActionSetChatWallPaper,
+    MessageActionSetMessagesTTL,
+    MessageActionTopicCreate,
+    MessageActionTopicEdit,
+    MessageEntityBotCommand,
+    MessageEntityMentionName,
     MessageMediaGame,
     MessageMediaGeo,
+    MessageMediaInvoice,
+    MessageMediaVenue,
+    MessageMediaWebPage,
     MessagePeerReaction,
     MessageReactions,
+    MessageReplyHeader,
     PeerChannel,
     PeerChat,
     PeerUser,
@@ -148,20 +191,36 @@ from telethon.tl.types import (
     ReactionCustomEmoji,
     ReactionEmoji,
     SendMessageCancelAction,
+    SendMessageChooseContactAction,
+    SendMessageChooseStickerAction,
+    SendMessageGamePlayAction,
+    SendMessageGeoLocationAction,
+    SendMessageRecordAudioAction,
+    SendMessageRecordRoundAction,
+    SendMessageRecordVideoAction,
     SendMessageTypingAction,
+    SendMessageUploadAudioAction,
+    SendMessageUploadDocumentAction,
+    SendMessageUploadPhotoAction,
+    SendMessageUploadRoundAction,
+    SendMessageUploadVideoAction,
     SponsoredMessage,
     TypeChannelParticipant,
     TypeChat,
     TypeChatParticipant,
     TypeInputChannel,
+    TypeInputMedia,
     TypeInputPeer,
     TypeMessage,
     TypeMessageAction,
+    TypeMessageEntity,
     TypePeer,
     TypeReaction,
+    TypeReportReason,
     TypeUser,
     TypeUserFull,
     TypeUserProfilePhoto,
+    TypeWallPaper,
     UpdateBotMessageReaction,
     UpdateChannelUserTyping,
     UpdateChatUserTyping,
@@ -174,6 +233,7 @@ from telethon.tl.types import (
     UserFull,
     UserProfilePhoto,
     UserProfilePhotoEmpty,
+    WallPaper,
 )
 from telethon.tl.types.messages import PeerDialogs
 from telethon.utils import encode_waveform, get_peer_id
@@ -192,6 +252,7 @@ from mautrix.types import (
     EventType,
     Format,
     ImageInfo,
+    InReplyTo,
     JoinRule,
     LocationMessageEventContent,
     MediaMessageEventContent,
@@ -225,6 +286,7 @@ from . import (
     abstract_user as au,
     formatter,
     matrix as m,
+    portal_bot as pb,
     portal_util as putil,
     puppet as p,
     user as u,
@@ -239,16 +301,24 @@ from .db import (
     Portal as DBPortal,
     Reaction as DBReaction,
     TelegramFile as DBTelegramFile,
+    TTLMedia,
 )
-from .tgclient import MautrixTelegramClient
+from .tgclient import MautrixTelegramClient, ReplyQuote
 from .types import TelegramID
-from .util import sane_mimetypes
+from .util import sane_mimetypes, tracing
 
 try:
     from mautrix.crypto.attachments import decrypt_attachment
 except ImportError:
     decrypt_attachment = None
 
+try:
+    # Paid reactions only exist in newer layers than the one the bridge currently uses
+    from telethon.tl.functions.messages import SendPaidReactionRequest
+    from telethon.tl.types import ReactionPaid
+except ImportError:
+    SendPaidReactionRequest = ReactionPaid = None
+
 if TYPE_CHECKING:
     from .__main__ import TelegramBridge
     from .bot import Bot
@@ -256,6 +326,14 @@ if TYPE_CHECKING:
 StateBridge = EventType.find("m.bridge", EventType.Class.STATE)
 StateHalfShotBridge = EventType.find("uk.half-shot.bridge", EventType.Class.STATE)
 DummyPortalCreated = EventType.find("fi.mau.dummy.portal_created", EventType.Class.MESSAGE)
+StateChatTheme = EventType.find("fi.mau.telegram.chat_theme", EventType.Class.STATE)
+StatePortalInfo = EventType.find("fi.mau.telegram.portal_info", EventType.Class.STATE)
+StateWallpaper = EventType.find("fi.mau.telegram.wallpaper", EventType.Class.STATE)
+StateJoinRequests = EventType.find("fi.mau.telegram.join_requests", EventType.Class.STATE)
+# State key is the topic ID, which is the ID of the message that created the topic
+StateForumTopic = EventType.find("fi.mau.telegram.forum_topic", EventType.Class.STATE)
+# State key is the Matrix event ID of the channel post
+StatePostStats = EventType.find("fi.mau.telegram.post_stats", EventType.Class.STATE)
 
 InviteList = Union[UserID, List[UserID]]
 UpdateTyping = Union[UpdateUserTyping, UpdateChatUserTyping, UpdateChannelUserTyping]
@@ -263,6 +341,104 @@ TypeChatPhoto = Union[ChatPhoto, ChatPhotoEmpty, Photo, PhotoEmpty]
 MediaHandler = Callable[["au.AbstractUser", IntentAPI, Message, RelatesTo], Awaitable[EventID]]
 
 REACTION_POLL_MIN_INTERVAL = 20
+REACTION_LIST_FETCH_INTERVAL = 1
+# Telegram only tells who read a message in groups up to this size
+READ_PARTICIPANTS_MAX_MEMBERS = 100
+# How long to wait before asking again who read a message, to catch people who read it later
+READ_PARTICIPANTS_REPOLL_DELAY = 5 * 60
+# Paid reactions are stored in the reaction table as sent by the channel itself,
+# with the total star count after this prefix.
+PAID_REACTION_PREFIX = "paid:"
+SETTINGS_EXPORT_VERSION = 1
+# The date Telegram uses for scheduled messages that are sent when the recipient comes online
+SEND_WHEN_ONLINE_TIMESTAMP = 0x7FFFFFFE
+# A blockquote at the start of a Matrix reply, which is bridged as a Telegram quote reply
+REPLY_QUOTE_REGEX = re.compile(r"^\s*<blockquote[^>]*>(.*?)</blockquote>\s*", re.DOTALL)
+# Forum topic ID of the General topic, which always exists and can't be renamed to something else
+GENERAL_TOPIC_ID = 1
+# How long Matrix messages are kept waiting while Telegram is having server issues
+MAX_OUTAGE_QUEUE_TIME = 15 * 60
+# How often chats ignored for being above the member limit are checked again
+IGNORED_RECHECK_INTERVAL = 24 * 60 * 60
+# Minimum time between fetching the full chat info (e.g. the chat theme) for room info updates
+FULL_INFO_REFRESH_INTERVAL = 60 * 60
+# How many thread roots to remember per discussion group for mirroring channel comments
+MAX_COMMENT_THREAD_CACHE = 100
+# How many pinned messages to fetch when creating a portal
+MAX_INITIAL_PINS = 50
+# Telegram chat actions that are bridged as Matrix typing notifications
+TYPING_ACTIONS = (
+    SendMessageTypingAction,
+    SendMessageRecordVideoAction,
+    SendMessageUploadVideoAction,
+    SendMessageRecordAudioAction,
+    SendMessageUploadAudioAction,
+    SendMessageUploadPhotoAction,
+    SendMessageUploadDocumentAction,
+    SendMessageRecordRoundAction,
+    SendMessageUploadRoundAction,
+    SendMessageGeoLocationAction,
+    SendMessageChooseContactAction,
+    SendMessageChooseStickerAction,
+    SendMessageGamePlayAction,
+)
+
+# Human-readable versions of errors Telegram returns, mostly for admin actions like changing
+# members or chat info
+RPC_ERROR_MESSAGES = {
+    "USER_PRIVACY_RESTRICTED": "The user's privacy settings don't allow adding them to groups",
+    "USER_NOT_MUTUAL_CONTACT": "The user can only be added by their mutual contacts",
+    "USER_CHANNELS_TOO_MUCH": "The user is already in too many groups and channels",
+    "USER_KICKED": "The user was removed from the chat and must be unbanned first",
+    "USER_BANNED_IN_CHANNEL": "You're not allowed to add users to groups right now",
+    "USER_ALREADY_PARTICIPANT": "The user is already in the chat",
+    "USERS_TOO_MUCH": "The chat has reached the maximum number of members",
+    "USER_ADMIN_INVALID": "You can't remove admins that you didn't promote",
+    "USER_BOT": "Bots can only be added as admins in channels",
+    "BOT_GROUPS_BLOCKED": "The bot can't be added to groups",
+    "CHAT_ADMIN_REQUIRED": "You must be an admin in the chat to do that",
+    "CHAT_WRITE_FORBIDDEN": "You're not allowed to write in the chat",
+    "INVITE_REQUEST_SENT": "A request to join the chat was sent to the admins",
+    "CHAT_TITLE_EMPTY": "The chat title can't be empty",
+    "CHAT_ABOUT_TOO_LONG": "The chat description is too long",
+    "PHOTO_CROP_SIZE_SMALL": "The avatar is too small",
+    "PHOTO_INVALID_DIMENSIONS": "The avatar has invalid dimensions",
+    "PHOTO_EXT_INVALID": "The avatar file type isn't supported",
+}
+
+
+def humanize_rpc_error(err: RPCError) -> str:
+    return RPC_ERROR_MESSAGES.get(err.message, err.message)
+
+
+# Matrix reports only have a free-form reason, so the Telegram reason is guessed from keywords.
+# Keywords only match whole words, and words that are commonly used in other meanings
+# (like "minor" or "personal" alone) are avoided.
+REPORT_REASON_KEYWORDS: list[tuple[tuple[str, ...], type[TypeReportReason]]] = [
+    (("child abuse", "child porn", "csam"), InputReportReasonChildAbuse),
+    (("porn", "pornography", "nsfw", "sexual", "nudity", "nude"), InputReportReasonPornography),
+    (("violence", "violent", "gore", "terrorism", "terrorist"), InputReportReasonViolence),
+    (("copyright", "piracy", "pirated", "dmca"), InputReportReasonCopyright),
+    (("drug", "drugs"), InputReportReasonIllegalDrugs),
+    (
+        ("doxx", "doxxing", "doxing", "private info", "personal info", "personal details"),
+        InputReportReasonPersonalDetails,
+    ),
+    (("fake", "scam", "scammer", "impersonation", "impersonating"), InputReportReasonFake),
+    (("spam", "spammer", "spamming"), InputReportReasonSpam),
+]
+REPORT_REASON_REGEXES = [
+    (re.compile(rf"(?<!\w)(?:{'|'.join(map(re.escape, keywords))})(?!\w)"), reason_type)
+    for keywords, reason_type in REPORT_REASON_KEYWORDS
+]
+
+
+def report_reason_from_text(reason: str | None) -> TypeReportReason:
+    reason = (reason or "").lower()
+    for regex, reason_type in REPORT_REASON_REGEXES:
+        if regex.search(reason):
+            return reason_type()
+    return InputReportReasonOther()
 
 
 class BridgingError(Exception):
@@ -287,6 +463,7 @@ class Portal(DBPortal, BasePortal):
     # Instance cache
     by_mxid: dict[RoomID, Portal] = {}
     by_tgid: dict[tuple[TelegramID, TelegramID], Portal] = {}
+    policy_lists: putil.PolicyListCache = putil.PolicyListCache()
 
     # Config cache
     filter_mode: str
@@ -312,9 +489,12 @@ class Portal(DBPortal, BasePortal):
     alias: RoomAlias | None
 
     dedup: putil.PortalDedup
+    album_batcher: putil.PortalAlbumBatcher
+    emote_pack: putil.PortalEmotePack
     send_lock: putil.PortalSendLock
     reaction_lock: putil.PortalReactionLock
     _pin_lock: asyncio.Lock
+    _outage_queue_lock: asyncio.Lock
 
     _main_intent: IntentAPI | None
     _room_create_lock: asyncio.Lock
@@ -323,11 +503,27 @@ class Portal(DBPortal, BasePortal):
     _sponsored_entity: User | Channel | None
     _sponsored_msg_ts: float
     _sponsored_msg_lock: asyncio.Lock
+    _reaction_list_lock: asyncio.Lock
+    _reaction_list_next_fetch: float
     _sponsored_evt_id: EventID | None
     _sponsored_seen: dict[UserID, bool]
     _new_messages_after_sponsored: bool
 
-    _prev_reaction_poll: dict[UserID, float]
+    _prev_reaction_poll: putil.ExpiringTimestamps[UserID]
+    _reaction_pushed_at: putil.ExpiringTimestamps[tuple[TelegramID, TelegramID]]
+    _participants_count: int | None
+    _prev_portal_info: dict[str, Any] | None
+    _power_levels_checked_at: float
+    _full_info_fetched_at: float
+    _pending_join_requests: set[TelegramID] | None
+    _read_participants_polled: putil.ExpiringTimestamps[TelegramID]
+    _post_stats: dict[EventID, dict[str, int]] | None
+    _comment_thread_posts: dict[TelegramID, TelegramID | None]
+    _post_stats_refreshed_at: float
+    _post_stats_lock: asyncio.Lock
+    _mention_keywords: dict[UserID, list[str]] | None
+    _ignored_update_logged: bool
+    _ignored_checked_at: float
 
     _msg_conv: putil.TelegramMessageConverter
 
@@ -353,6 +549,15 @@ class Portal(DBPortal, BasePortal):
         name_set: bool = False,
         avatar_set: bool = False,
         local_config: dict[str, Any] | None = None,
+        theme_emoticon: str | None = None,
+        relay_user_id: UserID | None = None,
+        ignored_reason: str | None = None,
+        bot_token: str | None = None,
+        ignored_member_limit: int | None = None,
+        ttl_period: int | None = None,
+        noforwards: bool = False,
+        linked_chat_id: TelegramID | None = None,
+        birthday_notice_year: int | None = None,
     ) -> None:
         super().__init__(
             tgid=tgid,
@@ -374,6 +579,15 @@ class Portal(DBPortal, BasePortal):
             photo_id=photo_id,
             name_set=name_set,
             avatar_set=avatar_set,
+            theme_emoticon=theme_emoticon,
+            relay_user_id=relay_user_id,
+            ignored_reason=ignored_reason,
+            bot_token=bot_token,
+            ignored_member_limit=ignored_member_limit,
+            ttl_period=ttl_period,
+            noforwards=noforwards,
+            linked_chat_id=linked_chat_id,
+            birthday_notice_year=birthday_notice_year,
             local_config=local_config or {},
         )
         BasePortal.__init__(self)
@@ -387,19 +601,37 @@ class Portal(DBPortal, BasePortal):
         self.backfill_method_lock = asyncio.Lock()
 
         self.dedup = putil.PortalDedup(self)
+        self.album_batcher = putil.PortalAlbumBatcher(self)
+        self.emote_pack = putil.PortalEmotePack(self)
         self.send_lock = putil.PortalSendLock()
         self.reaction_lock = putil.PortalReactionLock()
         self._pin_lock = asyncio.Lock()
+        self._outage_queue_lock = asyncio.Lock()
         self._room_create_lock = asyncio.Lock()
 
         self._sponsored_msg = None
         self._sponsored_msg_ts = 0
         self._sponsored_msg_lock = asyncio.Lock()
+        self._reaction_list_lock = asyncio.Lock()
+        self._reaction_list_next_fetch = 0
         self._sponsored_seen = {}
         self._new_messages_after_sponsored = True
-        self._bridging_blocked_at_runtime = False
-
-        self._prev_reaction_poll = defaultdict(lambda: 0.0)
+        self._ignored_update_logged = False
+        self._ignored_checked_at = 0
+        self._participants_count = None
+        self._prev_portal_info = None
+        self._power_levels_checked_at = 0
+        self._full_info_fetched_at = 0
+        self._mention_keywords = None
+        self._pending_join_requests = None
+
+        self._prev_reaction_poll = putil.ExpiringTimestamps(REACTION_POLL_MIN_INTERVAL)
+        self._reaction_pushed_at = putil.ExpiringTimestamps(REACTION_POLL_MIN_INTERVAL)
+        self._read_participants_polled = putil.ExpiringTimestamps(READ_PARTICIPANTS_REPOLL_DELAY)
+        self._post_stats = None
+        self._comment_thread_posts = {}
+        self._post_stats_refreshed_at = 0
+        self._post_stats_lock = asyncio.Lock()
 
         self._msg_conv = putil.TelegramMessageConverter(self)
 
@@ -415,6 +647,10 @@ class Portal(DBPortal, BasePortal):
             return str(self.tgid)
         return f"{self.tg_receiver}<->{self.tgid}"
 
+    @property
+    def is_saved_messages(self) -> bool:
+        return self.peer_type == "user" and self.tgid == self.tg_receiver
+
     @property
     def name(self) -> str:
         return self.title
@@ -455,15 +691,71 @@ class Portal(DBPortal, BasePortal):
             or (self.peer_type == "user" and self.tg_receiver == self.bot.tgid)
         )
 
+    @property
+    def relay_user(self) -> u.User | None:
+        if not self.relay_user_id:
+            return None
+        # Logged in users are always in the cache, so there's no need to check the database
+        user = u.User.by_mxid.get(self.relay_user_id)
+        return user if user and user.tgid and user.client else None
+
+    @property
+    def portal_bot(self) -> pb.PortalBot | None:
+        if not self.bot_token:
+            return None
+        bot = pb.PortalBot.by_token.get(self.bot_token)
+        return bot if bot and bot.tgid and bot.client else None
+
+    @property
+    def relay(self) -> au.AbstractUser | None:
+        """The account used to send messages of Matrix users who can't send them directly."""
+        if self.portal_bot:
+            return self.portal_bot
+        elif self.relay_user:
+            return self.relay_user
+        elif self.has_bot:
+            return self.bot
+        return None
+
+    @property
+    def has_relay(self) -> bool:
+        return self.relay is not None
+
+    async def set_relay_user(self, user: u.User | None) -> None:
+        self.relay_user_id = user.mxid if user else None
+        await self.save()
+
+    async def set_bot_token(self, token: str | None) -> None:
+        old_token, self.bot_token = self.bot_token, token
+        await self.save()
+        if old_token and old_token != token:
+            await pb.PortalBot.release(old_token)
+
     @property
     def main_intent(self) -> IntentAPI:
         if self._main_intent is None:
             raise RuntimeError("Portal must be postinit()ed before main_intent can be used")
         return self._main_intent
 
+    @property
+    def is_ignored(self) -> bool:
+        if not self.ignored_reason:
+            return False
+        # If the member limit was changed or the chat may have shrunk since it was ignored,
+        # let the next update through, so that room creation checks the member count again.
+        return (
+            self.ignored_member_limit == self.config["bridge.max_member_count"]
+            and time.monotonic() - self._ignored_checked_at < IGNORED_RECHECK_INTERVAL
+        )
+
+    def log_ignored_update(self) -> None:
+        if not self._ignored_update_logged:
+            self._ignored_update_logged = True
+            self.log.info(f"Dropping updates in ignored portal ({self.ignored_reason})")
+
     @property
     def allow_bridging(self) -> bool:
-        if self._bridging_blocked_at_runtime:
+        if self.is_ignored:
             return False
         elif self.peer_type == "user" and self.filter_users is not None:
             return self.filter_users
@@ -520,14 +812,20 @@ class Portal(DBPortal, BasePortal):
         else:
             await super().save()
 
+    async def unignore(self) -> None:
+        self.ignored_reason = None
+        self.ignored_member_limit = None
+        self._ignored_update_logged = False
+        await self.save()
+
     async def get_telegram_users_in_matrix_room(
-        self, source: u.User, pre_create: bool = False
+        self, source: u.User, pre_create: bool = False, extra_users: list[UserID] | None = None
     ) -> tuple[list[InputUser], list[UserID], list[u.User]]:
         user_tgids = {}
         users = []
         intent = self.az.intent if pre_create else self.main_intent
         user_mxids = await intent.get_room_members(self.mxid, (Membership.JOIN, Membership.INVITE))
-        for mxid in user_mxids:
+        for mxid in dict.fromkeys([*user_mxids, *(extra_users or [])]):
             if mxid == self.az.bot_mxid:
                 continue
             mx_user = await u.User.get_by_mxid(mxid, create=False)
@@ -599,11 +897,13 @@ class Portal(DBPortal, BasePortal):
         if await self._update_username(username):
             await self.save()
 
-    async def create_telegram_chat(self, source: u.User, supergroup: bool = False) -> None:
+    async def create_telegram_chat(
+        self, source: u.User, supergroup: bool = False, invite: list[UserID] | None = None
+    ) -> None:
         if not self.mxid:
             raise ValueError("Can't create Telegram chat for portal without Matrix room.")
         invites, errors, users = await self.get_telegram_users_in_matrix_room(
-            source, pre_create=True
+            source, pre_create=True, extra_users=invite
         )
         if len(errors) > 0:
             error_list = "\n".join(f"* [{mxid}](https://matrix.to/#/{mxid})" for mxid in errors)
@@ -631,23 +931,33 @@ class Portal(DBPortal, BasePortal):
                 "Not enough Telegram users to create a chat. "
                 "Invite more Telegram ghost users to the room."
             )
-        if self.peer_type == "chat":
-            response = await source.client(CreateChatRequest(title=self.title, users=invites))
-            entity = response.chats[0]
-        elif self.peer_type == "channel":
-            response = await source.client(
-                CreateChannelRequest(
-                    title=self.title, about=self.about or "", megagroup=supergroup
+        try:
+            if self.peer_type == "chat":
+                response = await source.client(CreateChatRequest(title=self.title, users=invites))
+                entity = response.chats[0]
+            elif self.peer_type == "channel":
+                response = await source.client(
+                    CreateChannelRequest(
+                        title=self.title, about=self.about or "", megagroup=supergroup
+                    )
                 )
-            )
-            entity = response.chats[0]
-            await source.client(
-                InviteToChannelRequest(
-                    channel=await source.client.get_input_entity(entity), users=invites
+                entity = response.chats[0]
+            else:
+                raise ValueError("Invalid peer type for Telegram chat creation")
+        except RPCError as e:
+            raise ValueError(f"Failed to create Telegram chat: {humanize_rpc_error(e)}") from e
+        if self.peer_type == "channel":
+            try:
+                await source.client(
+                    InviteToChannelRequest(
+                        channel=await source.client.get_input_entity(entity), users=invites
+                    )
+                )
+            except RPCError as e:
+                self.log.warning(f"Failed to invite users to new channel {entity.id}: {e}")
+                await self.az.intent.send_notice(
+                    self.mxid, f"Failed to invite users to the chat: {humanize_rpc_error(e)}"
                 )
-            )
-        else:
-            raise ValueError("Invalid peer type for Telegram chat creation")
 
         self.tgid = entity.id
         self.tg_receiver = self.tgid
@@ -667,15 +977,148 @@ class Portal(DBPortal, BasePortal):
         await self.update_bridge_info()
         for user in users:
             await user.register_portal(self)
+        for mxid in invite or []:
+            tgid = p.Puppet.get_id_from_mxid(mxid)
+            if tgid:
+                puppet = await p.Puppet.get_by_tgid(tgid)
+                try:
+                    await puppet.intent_for(self).ensure_joined(self.mxid)
+                except Exception:
+                    self.log.exception(f"Failed to ensure {mxid} is joined to portal")
         await self.main_intent.send_notice(self.mxid, f"Telegram chat created. ID: {self.tgid}")
 
+    async def update_join_requests(self, source: au.AbstractUser) -> list[TelegramID]:
+        """Fetch the pending join requests of the chat and mirror them into the room state."""
+        resp = await source.client(
+            GetChatInviteImportersRequest(
+                peer=await self.get_input_entity(source),
+                requested=True,
+                offset_date=None,
+                offset_user=InputUserEmpty(),
+                limit=100,
+            )
+        )
+        for user in resp.users:
+            puppet = await p.Puppet.get_by_tgid(TelegramID(user.id))
+            await puppet.update_info(source, user)
+        requests = [
+            {
+                "user_id": importer.user_id,
+                "mxid": p.Puppet.get_mxid_from_id(TelegramID(importer.user_id)),
+                "date": int(importer.date.timestamp() * 1000),
+                "about": importer.about,
+            }
+            for importer in resp.importers
+        ]
+        if self.mxid:
+            await self.main_intent.send_state_event(
+                self.mxid, StateJoinRequests, {"requests": requests}
+            )
+        self._pending_join_requests = {TelegramID(importer.user_id) for importer in resp.importers}
+        return list(self._pending_join_requests)
+
+    async def has_pending_join_request(self, source: au.AbstractUser, tgid: TelegramID) -> bool:
+        if self.peer_type == "user":
+            return False
+        elif self._pending_join_requests is None:
+            try:
+                await self.update_join_requests(source)
+            except RPCError as e:
+                # Usually means the user isn't an admin who can see join requests
+                self.log.debug(f"Failed to fetch join requests through {source.tgid}: {e}")
+                return False
+        return tgid in self._pending_join_requests
+
+    async def _load_join_requests_from_state(self) -> set[TelegramID]:
+        # The requests that were known before a restart are stored in the room state
+        try:
+            content = await self.main_intent.get_state_event(self.mxid, StateJoinRequests)
+            requests = content.serialize().get("requests", [])
+            return {TelegramID(req["user_id"]) for req in requests}
+        except (MatrixRequestError, KeyError, TypeError):
+            return set()
+
+    async def handle_telegram_join_requests(
+        self, source: au.AbstractUser, recent_requesters: list[int]
+    ) -> None:
+        previous = self._pending_join_requests
+        if previous is None:
+            previous = await self._load_join_requests_from_state()
+        try:
+            pending = await self.update_join_requests(source)
+        except RPCError as e:
+            self.log.warning(f"Failed to fetch pending join requests through {source.tgid}: {e}")
+            return
+        new = [
+            TelegramID(tgid)
+            for tgid in recent_requesters
+            if tgid in pending and tgid not in previous
+        ]
+        if not new:
+            return
+        users = []
+        for tgid in new:
+            puppet = await p.Puppet.get_by_tgid(tgid)
+            users.append(f"* [{puppet.displayname or tgid}](https://matrix.to/#/{puppet.mxid})")
+        cmd = self.config["bridge.command_prefix"]
+        message = (
+            "New requests to join this chat:\n\n"
+            + "\n".join(users)
+            + f"\n\nUse `{cmd} approve <user>` or `{cmd} deny <user>` to handle them."
+        )
+        await self.main_intent.send_notice(self.mxid, text=message, html=markdown.render(message))
+
+    async def handle_telegram_scheduled_message(
+        self, source: au.AbstractUser, evt: Message
+    ) -> None:
+        if not self.get_config("scheduled_message_notices"):
+            return
+        kind = putil.get_message_kind(evt)
+        if evt.date.timestamp() == SEND_WHEN_ONLINE_TIMESTAMP:
+            when = "when they come online"
+        else:
+            when = f"at {evt.date.strftime('%Y-%m-%d %H:%M UTC')}"
+        puppet = await p.Puppet.get_by_tgid(source.tgid)
+        name = puppet.displayname or source.tgid
+        message = f"[{name}](https://matrix.to/#/{puppet.mxid}) scheduled a {kind} message {when}"
+        if evt.message and not self.get_config("metadata_only"):
+            preview = evt.message if len(evt.message) <= 200 else f"{evt.message[:200]}…"
+            message += "\n\n" + "\n".join(f"> {line}" for line in preview.split("\n"))
+        content = TextMessageEventContent(
+            msgtype=MessageType.NOTICE, body=message, formatted_body=markdown.render(message)
+        )
+        content.format = Format.HTML
+        content["fi.mau.telegram.scheduled"] = {
+            "id": evt.id,
+            "type": kind,
+            "date": int(evt.date.timestamp()),
+        }
+        await self._send_message(self.main_intent, content)
+
+    async def handle_join_request(
+        self, source: u.User, puppet: p.Puppet, approved: bool
+    ) -> None:
+        await source.client(
+            HideChatJoinRequestRequest(
+                peer=await self.get_input_entity(source),
+                user_id=await source.client.get_input_entity(puppet.tgid),
+                approved=approved,
+            )
+        )
+        await self.update_join_requests(source)
+
     async def handle_matrix_invite(
         self, invited_by: u.User, puppet: p.Puppet | au.AbstractUser
     ) -> None:
         if isinstance(puppet, p.Puppet) and puppet.is_channel:
             raise ValueError("Can't invite channels to chats")
         try:
-            if self.peer_type == "chat":
+            if isinstance(puppet, p.Puppet) and await self.has_pending_join_request(
+                invited_by, puppet.tgid
+            ):
+                # Accepting a knock approves the Telegram join request instead of inviting
+                await self.handle_join_request(invited_by, puppet, approved=True)
+            elif self.peer_type == "chat":
                 await invited_by.client(
                     AddChatUserRequest(chat_id=self.tgid, user_id=puppet.tgid, fwd_limit=0)
                 )
@@ -687,7 +1130,7 @@ class Portal(DBPortal, BasePortal):
             elif not self.bot or self.tg_receiver != self.bot.tgid:
                 raise RejectMatrixInvite("You can't invite additional users to private chats.")
         except RPCError as e:
-            raise RejectMatrixInvite(e.message) from e
+            raise RejectMatrixInvite(humanize_rpc_error(e)) from e
 
     # endregion
     # region Telegram -> Matrix metadata
@@ -742,6 +1185,8 @@ class Portal(DBPortal, BasePortal):
             client = user.client
         if not self.is_direct:
             await self.update_info(user, entity, client=client)
+            full_chat = entity if isinstance(entity, (ChatFull, ChannelFull)) else None
+            await self.update_full_info(user, full_chat, client=client)
             if not users:
                 users = await self._get_users(client, entity)
             await self._sync_telegram_users(user, users, client=client)
@@ -752,6 +1197,7 @@ class Portal(DBPortal, BasePortal):
             await puppet.update_info(user, entity)
             await puppet.intent_for(self).join_room(self.mxid)
             await self.update_info_from_puppet(puppet, user, entity.photo)
+            await self.update_full_info(user, client=client)
 
             puppet = await p.Puppet.get_by_custom_mxid(user.mxid)
             if puppet:
@@ -762,6 +1208,7 @@ class Portal(DBPortal, BasePortal):
                 except Exception:
                     self.log.exception("Failed to ensure %s is joined to portal", user.mxid)
 
+        await self.update_portal_info()
         if self.sync_matrix_state:
             await self.main_intent.get_joined_members(self.mxid)
 
@@ -787,6 +1234,7 @@ class Portal(DBPortal, BasePortal):
         update_if_exists: bool = True,
         from_dialog_sync: bool = False,
         client: MautrixTelegramClient | None = None,
+        ignore_member_limit: bool = False,
     ) -> RoomID | None:
         if self.mxid:
             if update_if_exists:
@@ -805,7 +1253,12 @@ class Portal(DBPortal, BasePortal):
         async with self._room_create_lock:
             try:
                 return await self._create_matrix_room(
-                    user, entity, invites, client=client, from_dialog_sync=from_dialog_sync
+                    user,
+                    entity,
+                    invites,
+                    client=client,
+                    from_dialog_sync=from_dialog_sync,
+                    ignore_member_limit=ignore_member_limit,
                 )
             except Exception:
                 self.log.exception("Fatal error creating Matrix room")
@@ -840,6 +1293,30 @@ class Portal(DBPortal, BasePortal):
                 info["channel"]["external_url"] = f"https://t.me/{puppet.username}"
         return info
 
+    @property
+    def portal_info(self) -> dict[str, Any]:
+        return {
+            "peer_type": self.peer_type,
+            "id": self.tgid,
+            "username": self.username,
+            "megagroup": self.peer_type == "channel" and self.megagroup,
+            "broadcast": self.peer_type == "channel" and not self.megagroup,
+            "ttl_period": self.ttl_period,
+            "protected_content": self.noforwards,
+        }
+
+    async def update_portal_info(self) -> None:
+        if not self.mxid:
+            return
+        info = self.portal_info
+        if info == self._prev_portal_info:
+            return
+        try:
+            await self.main_intent.send_state_event(self.mxid, StatePortalInfo, info)
+            self._prev_portal_info = info
+        except Exception:
+            self.log.warning("Failed to update portal info", exc_info=True)
+
     async def update_bridge_info(self) -> None:
         if not self.mxid:
             self.log.debug("Not updating bridge info: no Matrix room created")
@@ -855,6 +1332,7 @@ class Portal(DBPortal, BasePortal):
             )
         except Exception:
             self.log.warning("Failed to update bridge info", exc_info=True)
+        await self.update_portal_info()
 
     async def _create_matrix_room(
         self,
@@ -863,6 +1341,7 @@ class Portal(DBPortal, BasePortal):
         invites: InviteList,
         from_dialog_sync: bool,
         client: MautrixTelegramClient | None = None,
+        ignore_member_limit: bool = False,
     ) -> RoomID | None:
         if self.mxid:
             return self.mxid
@@ -919,13 +1398,25 @@ class Portal(DBPortal, BasePortal):
                 raise RuntimeError("Tried to create portal for deactivated chat")
         elif isinstance(entity, Channel) and not entity.broadcast:
             participants_count = entity.participants_count
-        if participants_count is None and self.config["bridge.max_member_count"] > 0:
+        max_member_count = 0 if ignore_member_limit else self.config["bridge.max_member_count"]
+        if participants_count is None and max_member_count > 0:
             self.log.warning(f"Participant count not found in entity, fetching manually")
             participants_count = (await client.get_participants(entity, limit=0)).total
-        if participants_count and 0 < self.config["bridge.max_member_count"] < participants_count:
+        self._participants_count = participants_count
+        if participants_count and 0 < max_member_count < participants_count:
             self.log.warning(f"Not bridging chat, too many participants (%d)", participants_count)
-            self._bridging_blocked_at_runtime = True
+            # Persist the decision so future updates in the chat are dropped without refetching
+            # the chat info every time. The unignore command can be used to bridge it anyway.
+            self.ignored_reason = f"too many participants ({participants_count})"
+            self.ignored_member_limit = max_member_count
+            self._ignored_checked_at = time.monotonic()
+            await self.save()
             return None
+        elif self.ignored_reason:
+            self.log.info(f"Clearing ignored marker ({self.ignored_reason}), chat is now allowed")
+            self.ignored_reason = None
+            self.ignored_member_limit = None
+            self._ignored_update_logged = False
 
         self.log.debug("Preparing to create room")
 
@@ -936,10 +1427,12 @@ class Portal(DBPortal, BasePortal):
             if self.tgid == user.tgid:
                 self.title = "Telegram Saved Messages"
                 self.about = "Your Telegram cloud storage chat"
+            await self.update_full_info(user, client=client)
         else:
             puppet = None
             self._main_intent = self.az.intent
             await self.update_info(user, entity, client=client)
+            await self.update_full_info(user, client=client)
 
         preset = RoomCreatePreset.PRIVATE
         if self.peer_type == "channel" and entity.username:
@@ -1011,6 +1504,15 @@ class Portal(DBPortal, BasePortal):
             self.title = puppet.displayname
             self.avatar_url = puppet.avatar_url
             self.photo_id = puppet.photo_id
+        portal_info = self.portal_info
+        initial_state.append({"type": str(StatePortalInfo), "content": portal_info})
+        if self.theme_emoticon:
+            initial_state.append(
+                {
+                    "type": str(StateChatTheme),
+                    "content": {"emoticon": self.theme_emoticon},
+                }
+            )
         creation_content = {}
         if not self.config["bridge.federate_rooms"]:
             creation_content["m.federate"] = False
@@ -1043,6 +1545,7 @@ class Portal(DBPortal, BasePortal):
                 raise Exception(f"Failed to create room")
             self.name_set = bool(self.title) and self.set_dm_room_metadata
             self.avatar_set = bool(self.avatar_url) and self.set_dm_room_metadata
+            self._prev_portal_info = portal_info
 
             if not autojoin_invites and self.encrypted and self.matrix.e2ee and self.is_direct:
                 try:
@@ -1082,6 +1585,11 @@ class Portal(DBPortal, BasePortal):
                     self.log.exception("Error in initial backfill")
                 if self._enable_batch_sending:
                     await self.enqueue_backfill(user, priority=50)
+            if isinstance(user, u.User):
+                try:
+                    await self.sync_telegram_pins(user, client)
+                except Exception:
+                    self.log.exception("Error syncing pinned messages")
 
         return self.mxid
 
@@ -1113,6 +1621,27 @@ class Portal(DBPortal, BasePortal):
         levels = putil.get_base_power_levels(self, levels, dbr=dbr)
         await self.main_intent.set_power_levels(self.mxid, levels)
 
+    async def resync_power_levels(self, source: au.AbstractUser) -> bool:
+        """Recompute the power levels implied by the current Telegram rights and repair drift.
+
+        Returns:
+            ``True`` if the Matrix power levels differed and were updated.
+        """
+        if not self.mxid or self.peer_type == "user":
+            return False
+        self._power_levels_checked_at = time.monotonic()
+        entity = await self.get_entity(source)
+        levels = await self.main_intent.get_power_levels(self.mxid, ignore_cache=True)
+        original = levels.serialize()
+        levels = putil.get_base_power_levels(self, levels, entity=entity)
+        users = await self._get_users(source.client, entity)
+        await putil.participants_to_power_levels(self, users, levels)
+        if levels.serialize() == original:
+            return False
+        self.log.info(f"Power levels drifted from Telegram rights, resyncing (via {source.mxid})")
+        await self.main_intent.set_power_levels(self.mxid, levels)
+        return True
+
     async def _add_bot_chat(self, bot: User) -> None:
         if self.bot and bot.id == self.bot.tgid:
             await self.bot.add_chat(self.tgid, self.peer_type)
@@ -1142,7 +1671,10 @@ class Portal(DBPortal, BasePortal):
                 continue
 
             if self.mxid:
-                await puppet.intent_for(self).ensure_joined(self.mxid)
+                if self.config["bridge.rejoin_kicked_ghosts"]:
+                    await self._ensure_ghost_joined(puppet)
+                else:
+                    await puppet.intent_for(self).ensure_joined(self.mxid)
             else:
                 join_mxids.add(puppet.intent_for(self).mxid)
 
@@ -1214,7 +1746,10 @@ class Portal(DBPortal, BasePortal):
         self, user_id: TelegramID, source: au.AbstractUser | None = None
     ) -> None:
         puppet = await p.Puppet.get_by_tgid(user_id)
-        if source:
+        if puppet.is_deleted:
+            # Deleted accounts can't be fetched, so don't bother trying
+            await puppet.intent_for(self).ensure_joined(self.mxid)
+        elif source:
             try:
                 entity: User = await source.client.get_entity(PeerUser(user_id))
             except ValueError:
@@ -1270,6 +1805,37 @@ class Portal(DBPortal, BasePortal):
             except MForbidden as e:
                 self.log.warning(f"Failed to kick {user.mxid}: {e}")
 
+    async def archive_if_last_user(self, user: au.AbstractUser) -> bool:
+        """
+        Turn the room into a read-only archive if the given user was the last Matrix user who
+        could access the chat on Telegram. Returns whether the room was archived.
+        """
+        try:
+            authenticated = await self.get_authenticated_matrix_users()
+        except MatrixRequestError:
+            self.log.warning("Failed to get room members, not archiving room", exc_info=True)
+            return False
+        if any(mxid != user.mxid for mxid in authenticated):
+            return False
+        self.log.info(f"Last user {user.mxid} left the chat on Telegram, archiving room")
+        await user.unregister_portal(*self.tgid_full)
+        try:
+            levels = await self.main_intent.get_power_levels(self.mxid)
+            levels.events_default = levels.state_default = 100
+            levels.invite = levels.redact = 100
+            levels.events = {evt_type: 100 for evt_type in levels.events}
+            await self.main_intent.set_power_levels(self.mxid, levels)
+        except MatrixRequestError:
+            self.log.warning("Failed to lock power levels of archived room", exc_info=True)
+        await self.main_intent.send_notice(
+            self.mxid,
+            "You left this chat on Telegram. This room has been kept as a read-only archive "
+            "of the chat history, and new messages will no longer be bridged here.",
+        )
+        # Forget the room so that rejoining the chat creates a new portal
+        await self.delete()
+        return True
+
     async def update_info(
         self,
         user: au.AbstractUser,
@@ -1295,6 +1861,13 @@ class Portal(DBPortal, BasePortal):
             if hasattr(entity, "about"):
                 changed = self._update_about(entity.about) or changed
 
+            if hasattr(entity, "noforwards"):
+                changed = self.noforwards != bool(entity.noforwards) or changed
+                self.noforwards = bool(entity.noforwards)
+
+            if getattr(entity, "participants_count", None) is not None:
+                self._participants_count = entity.participants_count
+
             changed = await self._update_title(entity.title) or changed
 
             if isinstance(entity.photo, ChatPhoto):
@@ -1306,6 +1879,44 @@ class Portal(DBPortal, BasePortal):
             await self.save()
             await self.update_bridge_info()
 
+    async def update_full_info(
+        self,
+        user: au.AbstractUser,
+        full_chat: ChatFull | ChannelFull | UserFull | None = None,
+        client: MautrixTelegramClient | None = None,
+    ) -> None:
+        """
+        Update the info that is only included in the full chat info, like the chat theme and
+        the auto-delete timer.
+
+        If the full info isn't provided, it's only fetched if it wasn't already fetched recently,
+        as the chat info is updated much more often than the full info is likely to change.
+        """
+        if not client:
+            client = user.client
+        try:
+            if not full_chat:
+                if time.monotonic() - self._full_info_fetched_at < FULL_INFO_REFRESH_INTERVAL:
+                    return
+                if self.peer_type == "user":
+                    full_chat = (await client(GetFullUserRequest(self.peer))).full_user
+                elif self.peer_type == "channel":
+                    full_chat = (await client(GetFullChannelRequest(self.peer))).full_chat
+                else:
+                    full_chat = (await client(GetFullChatRequest(self.tgid))).full_chat
+            self._full_info_fetched_at = time.monotonic()
+            changed = await self._update_theme(full_chat.theme_emoticon)
+            ttl_period = full_chat.ttl_period or None
+            if self.ttl_period != ttl_period:
+                self.ttl_period = ttl_period
+                changed = True
+            if isinstance(full_chat, ChannelFull):
+                changed = await self.update_linked_chat(full_chat.linked_chat_id) or changed
+            if changed:
+                await self.save()
+        except Exception:
+            self.log.exception(f"Failed to update full info from source {user.tgid}")
+
     async def _update_username(self, username: str, save: bool = False) -> bool:
         if self.username == username:
             return False
@@ -1328,18 +1939,26 @@ class Portal(DBPortal, BasePortal):
         return True
 
     async def _try_set_state(
-        self, sender: p.Puppet | None, evt_type: EventType, content: StateEventContent
+        self,
+        sender: p.Puppet | None,
+        evt_type: EventType,
+        content: StateEventContent | dict[str, Any],
+        state_key: str = "",
     ) -> None:
         if sender:
             try:
                 intent = sender.intent_for(self)
                 if sender.is_real_user:
                     content[DOUBLE_PUPPET_SOURCE_KEY] = self.bridge.name
-                await intent.send_state_event(self.mxid, evt_type, content)
+                await intent.send_state_event(self.mxid, evt_type, content, state_key=state_key)
             except MForbidden:
-                await self.main_intent.send_state_event(self.mxid, evt_type, content)
+                await self.main_intent.send_state_event(
+                    self.mxid, evt_type, content, state_key=state_key
+                )
         else:
-            await self.main_intent.send_state_event(self.mxid, evt_type, content)
+            await self.main_intent.send_state_event(
+                self.mxid, evt_type, content, state_key=state_key
+            )
 
     async def _update_about(
         self, about: str, sender: p.Puppet | None = None, save: bool = False
@@ -1356,6 +1975,64 @@ class Portal(DBPortal, BasePortal):
             await self.save()
         return True
 
+    async def _update_theme(
+        self, emoticon: str | None, sender: p.Puppet | None = None, save: bool = False
+    ) -> bool:
+        emoticon = emoticon or None
+        if self.theme_emoticon == emoticon:
+            return False
+
+        self.theme_emoticon = emoticon
+        if self.mxid:
+            await self._try_set_state(sender, StateChatTheme, {"emoticon": emoticon})
+        if save:
+            await self.save()
+        return True
+
+    async def update_linked_chat(self, chat_id: int | None, save: bool = False) -> bool:
+        """Update the ID of the discussion group of a channel, or the channel of a group."""
+        chat_id = TelegramID(chat_id) if chat_id else None
+        if self.linked_chat_id == chat_id:
+            return False
+        self.linked_chat_id = chat_id
+        if save:
+            await self.save()
+        return True
+
+    async def _update_wallpaper(
+        self, source: au.AbstractUser, sender: p.Puppet | None, wallpaper: TypeWallPaper
+    ) -> None:
+        content: dict[str, Any] = {"id": str(wallpaper.id)}
+        settings = wallpaper.settings
+        if settings:
+            colors = [
+                settings.background_color,
+                settings.second_background_color,
+                settings.third_background_color,
+                settings.fourth_background_color,
+            ]
+            content["colors"] = [f"#{color:06x}" for color in colors if color is not None]
+            if settings.intensity is not None:
+                content["intensity"] = settings.intensity
+            if settings.emoticon:
+                content["emoticon"] = settings.emoticon
+        if isinstance(wallpaper, WallPaper):
+            content["slug"] = wallpaper.slug
+            content["pattern"] = wallpaper.pattern
+            file = await util.transfer_file_to_matrix(
+                source.client,
+                self.main_intent,
+                wallpaper.document,
+                async_upload=self.config["homeserver.async_media"],
+            )
+            if file:
+                content["url"] = file.mxc
+                content["info"] = {"mimetype": file.mime_type, "size": file.size}
+        try:
+            await self._try_set_state(sender, StateWallpaper, content)
+        except Exception:
+            self.log.warning("Failed to update wallpaper state", exc_info=True)
+
     async def _update_title(
         self, title: str, sender: p.Puppet | None = None, save: bool = False
     ) -> bool:
@@ -1492,22 +2169,23 @@ class Portal(DBPortal, BasePortal):
     async def _send_state_change_message(
         self, event: str, user: u.User, event_id: EventID, **kwargs: Any
     ) -> None:
-        if not self.has_bot:
+        relay = self.relay
+        if not relay:
             return
         elif (
             self.peer_type == "user"
             and not self.config["bridge.relaybot.private_chat.state_changes"]
         ):
             return
-        async with self.send_lock(self.bot.tgid):
+        async with self.send_lock(relay.tgid):
             message = await self._get_state_change_message(event, user, **kwargs)
             if not message:
                 return
-            message, entities = await formatter.matrix_to_telegram(self.bot.client, html=message)
-            response = await self.bot.client.send_message(
+            message, entities = await formatter.matrix_to_telegram(relay.client, html=message)
+            response = await relay.client.send_message(
                 self.peer, message, formatting_entities=entities
             )
-            space = self.tgid if self.peer_type == "channel" else self.bot.tgid
+            space = self.tgid if self.peer_type == "channel" else relay.tgid
             self.dedup.check(response, (event_id, space))
 
     async def name_change_matrix(
@@ -1531,6 +2209,27 @@ class Portal(DBPortal, BasePortal):
             SetTypingRequest(self.peer, action() if typing else SendMessageCancelAction())
         )
 
+    async def _send_upload_action(
+        self, client: MautrixTelegramClient, content: MediaMessageEventContent
+    ) -> None:
+        if content.msgtype == MessageType.IMAGE:
+            action = SendMessageUploadPhotoAction(progress=0)
+        elif content.msgtype == MessageType.VIDEO:
+            action = SendMessageUploadVideoAction(progress=0)
+        elif content.msgtype == MessageType.AUDIO:
+            action = SendMessageUploadAudioAction(progress=0)
+        else:
+            action = SendMessageUploadDocumentAction(progress=0)
+        # Telegram clients expire chat actions after about 6 seconds,
+        # so keep resending it until the upload is done.
+        while True:
+            try:
+                await client(SetTypingRequest(self.peer, action))
+            except RPCError as e:
+                self.log.debug(f"Failed to send upload action: {e}")
+                return
+            await asyncio.sleep(5)
+
     async def _get_sponsored_message(
         self, user: u.User
     ) -> tuple[SponsoredMessage | None, Channel | User | None]:
@@ -1544,7 +2243,11 @@ class Portal(DBPortal, BasePortal):
             user, await self.get_input_entity(user)
         )
         self._sponsored_msg_ts = time.monotonic()
-        if self._sponsored_msg is not None and self._sponsored_entity is None:
+        if (
+            self._sponsored_msg is not None
+            and self._sponsored_entity is None
+            and getattr(self._sponsored_msg, "from_id", None) is not None
+        ):
             self.log.warning(f"GetSponsoredMessages didn't return entity for {t_id}")
         return self._sponsored_msg, self._sponsored_entity
 
@@ -1553,13 +2256,16 @@ class Portal(DBPortal, BasePortal):
         if msg is None:
             self.log.trace("Didn't get a sponsored message")
             return
+        content = await putil.make_sponsored_message_content(user, msg, entity)
+        if content is None:
+            self.log.debug("Unsupported sponsored message type: %s", msg)
+            return
         if self.sponsored_event_id is not None:
             self.log.debug(
                 f"Redacting old sponsored {self.sponsored_event_id}"
                 " in preparation for sending new one"
             )
             await self.main_intent.redact(self.mxid, self.sponsored_event_id)
-        content = await putil.make_sponsored_message_content(user, msg, entity)
         self.log.trace("Sending sponsored message")
         self.sponsored_event_id = await self._send_message(self.main_intent, content)
         self.sponsored_event_ts = int(time.time())
@@ -1642,6 +2348,10 @@ class Portal(DBPortal, BasePortal):
         await user.client.send_read_acknowledge(
             self.peer, max_id=message.tgid, clear_mentions=True, clear_reactions=True
         )
+        try:
+            await self._mark_media_contents_read(user, space, message.tgid)
+        except Exception:
+            self.log.warning("Failed to mark disappearing media as opened", exc_info=True)
         if self.peer_type == "channel":
             if not self.megagroup:
                 background_task.create(
@@ -1650,6 +2360,26 @@ class Portal(DBPortal, BasePortal):
             else:
                 background_task.create(self._poll_telegram_reactions(user))
 
+    async def _mark_media_contents_read(
+        self, user: u.User, space: TelegramID, max_id: TelegramID
+    ) -> None:
+        # Disappearing media (including view-once voice messages) that hasn't been read yet.
+        # This runs before the read receipt starts the disappearing timers on Matrix.
+        unread = await DisappearingMessage.get_unscheduled_for_room(self.mxid)
+        msg_ids = set()
+        for dm in unread:
+            message = await DBMessage.get_by_mxid(dm.event_id, self.mxid, space)
+            if message and message.tgid <= max_id:
+                msg_ids.add(message.tgid)
+        if not msg_ids:
+            return
+        self.log.debug(f"Marking contents of {msg_ids} as opened by {user.mxid}")
+        if self.peer_type == "channel":
+            channel = await self.get_input_entity(user)
+            await user.client(ReadChannelMessageContentsRequest(channel=channel, id=list(msg_ids)))
+        else:
+            await user.client(ReadMessageContentsRequest(id=list(msg_ids)))
+
     async def _preproc_kick_ban(
         self, user: u.User | p.Puppet, source: u.User
     ) -> au.AbstractUser | None:
@@ -1664,20 +2394,62 @@ class Portal(DBPortal, BasePortal):
             # TODO kick message
             return None
         if await source.needs_relaybot(self):
-            if not self.has_bot:
-                return None
-            return self.bot
+            return self.relay
         return source
 
     async def kick_matrix(self, user: u.User | p.Puppet, source: u.User) -> None:
-        source = await self._preproc_kick_ban(user, source)
-        if source is not None:
-            await source.client.kick_participant(self.peer, user.peer)
+        tg_source = await self._preproc_kick_ban(user, source)
+        if tg_source is None:
+            await self._rejoin_kicked_ghost(user)
+            return
+        try:
+            await tg_source.client.kick_participant(self.peer, user.peer)
+        except RPCError:
+            await self._rejoin_kicked_ghost(user)
+            raise
 
     async def ban_matrix(self, user: u.User | p.Puppet, source: u.User):
-        source = await self._preproc_kick_ban(user, source)
-        if source is not None:
-            await source.client.edit_permissions(self.peer, user.peer, view_messages=False)
+        tg_source = await self._preproc_kick_ban(user, source)
+        if tg_source is None:
+            await self._rejoin_kicked_ghost(user)
+            return
+        try:
+            await tg_source.client.edit_permissions(self.peer, user.peer, view_messages=False)
+        except RPCError:
+            await self._rejoin_kicked_ghost(user)
+            raise
+
+    async def unban_matrix(self, user: u.User | p.Puppet, source: u.User) -> None:
+        # Normal groups don't have a ban list, kicked users can just be added back
+        if self.peer_type != "channel":
+            return
+        tg_source = await self._preproc_kick_ban(user, source)
+        if tg_source is None:
+            return
+        await tg_source.client.edit_permissions(self.peer, user.peer)
+
+    async def _rejoin_kicked_ghost(self, puppet: u.User | p.Puppet) -> None:
+        if (
+            not isinstance(puppet, p.Puppet)
+            or not self.config["bridge.rejoin_kicked_ghosts"]
+            or self.deleted
+            or not self.mxid
+        ):
+            return
+        self.log.debug(f"Kick of {puppet.tgid} wasn't bridged to Telegram, re-adding ghost")
+        await self._ensure_ghost_joined(puppet)
+
+    async def _ensure_ghost_joined(self, puppet: p.Puppet) -> None:
+        intent = puppet.intent_for(self)
+        try:
+            membership = await self.az.state_store.get_membership(self.mxid, intent.mxid)
+            if membership == Membership.BAN:
+                await self.main_intent.unban_user(
+                    self.mxid, intent.mxid, "User is still in the Telegram chat"
+                )
+            await intent.ensure_joined(self.mxid)
+        except Exception:
+            self.log.warning(f"Failed to re-add {intent.mxid} to the room", exc_info=True)
 
     async def leave_matrix(self, user: u.User, event_id: EventID) -> None:
         if await user.needs_relaybot(self):
@@ -1693,7 +2465,29 @@ class Portal(DBPortal, BasePortal):
             except KeyError:
                 pass
         elif self.config["bridge.bridge_matrix_leave"]:
+            if self.config["bridge.matrix_leave_archive_only"]:
+                await self.set_archived(user, True)
+                return
             await user.client.delete_dialog(self.peer)
+            await user.unregister_portal(*self.tgid_full)
+            try:
+                authenticated = await self.get_authenticated_matrix_users()
+            except MatrixRequestError:
+                self.log.warning(
+                    "Failed to get room members, not checking if portal should be cleaned up",
+                    exc_info=True,
+                )
+                return
+            if not authenticated:
+                self.log.info(f"Last authenticated user {user.mxid} left, cleaning up portal")
+                await self.cleanup_portal("Everyone who could use this portal left the chat")
+
+    async def set_archived(self, user: u.User, archived: bool) -> None:
+        folder_peer = InputFolderPeer(
+            peer=await self.get_input_entity(user), folder_id=1 if archived else 0
+        )
+        await user.client(EditPeerFoldersRequest(folder_peers=[folder_peer]))
+        await user.set_archive_tag(self, archived)
 
     async def join_matrix(self, user: u.User, event_id: EventID) -> None:
         if await user.needs_relaybot(self):
@@ -1778,6 +2572,129 @@ class Portal(DBPortal, BasePortal):
         elif content.msgtype == MessageType.EMOTE:
             await self._apply_emote_format(sender, content)
 
+    async def send_webhook_message(
+        self, user: u.User, text: str | None, html: str | None = None
+    ) -> list[TelegramID]:
+        message, entities = await formatter.matrix_to_telegram(
+            user.client, text=text or "", html=html, cut=False
+        )
+        responses = []
+        async with self.send_lock(user.tgid):
+            for chunk_text, chunk_entities in formatter.split_long_message(message, entities):
+                responses.append(
+                    await user.client.send_message(
+                        self.peer,
+                        chunk_text,
+                        formatting_entities=chunk_entities,
+                        link_preview=self.get_config("telegram_link_preview"),
+                    )
+                )
+        # The messages don't originate from Matrix, so mirror them like any other outgoing
+        # Telegram message. The dedup cache drops the echo if it's received as an update too.
+        sender = await p.Puppet.get_by_tgid(user.tgid)
+        for response in responses:
+            await self.handle_telegram_message(user, sender, response)
+        return [TelegramID(response.id) for response in responses]
+
+    async def _get_send_as(self, sender: u.User) -> TypeInputPeer | None:
+        if not self.megagroup:
+            return None
+        send_as = await sender.get_send_as(self)
+        if send_as is None:
+            return None
+        try:
+            return await sender.client.get_input_entity(send_as)
+        except ValueError:
+            self.log.warning(f"Couldn't find send-as peer {send_as} of {sender.mxid}, ignoring it")
+            return None
+
+    @staticmethod
+    def _get_link_preview_layout(
+        content: TextMessageEventContent,
+    ) -> tuple[InputMediaWebPage | None, bool]:
+        previews = content.get(putil.BEEPER_LINK_PREVIEWS_KEY) or []
+        preview = previews[0] if previews and isinstance(previews[0], dict) else {}
+        invert_media = bool(
+            content.get("fi.mau.telegram.invert_media")
+            or preview.get("fi.mau.telegram.invert_media")
+        )
+        force_large = bool(preview.get("fi.mau.telegram.force_large_media"))
+        force_small = bool(preview.get("fi.mau.telegram.force_small_media"))
+        url = preview.get("matched_url")
+        if not url or not (force_large or force_small):
+            return None, invert_media
+        web_page = InputMediaWebPage(
+            url=url, force_large_media=force_large, force_small_media=force_small, optional=True
+        )
+        return web_page, invert_media
+
+    async def _send_text(
+        self,
+        client: MautrixTelegramClient,
+        text: str,
+        entities: list[TypeMessageEntity] | None,
+        reply_to: TelegramID | None = None,
+        send_as: TypeInputPeer | None = None,
+        content: TextMessageEventContent | None = None,
+        quote: ReplyQuote | None = None,
+    ) -> Message:
+        lp = self.get_config("telegram_link_preview")
+        web_page, invert_media = None, False
+        if content:
+            web_page, invert_media = self._get_link_preview_layout(content)
+        if send_as or web_page or invert_media or quote:
+            return await client.send_text(
+                self.peer,
+                text,
+                entities,
+                reply_to=reply_to,
+                link_preview=lp,
+                send_as=send_as,
+                invert_media=invert_media,
+                web_page=web_page,
+                quote=quote,
+            )
+        return await client.send_message(
+            self.peer, text, reply_to=reply_to, formatting_entities=entities, link_preview=lp
+        )
+
+    async def _extract_reply_quote(
+        self, client: MautrixTelegramClient, content: TextMessageEventContent, reply_to: TelegramID
+    ) -> ReplyQuote | None:
+        """
+        If a reply starts with a blockquote of a part of the replied-to message, remove the
+        blockquote from the content and return it as a Telegram quote.
+        """
+        if content.format != Format.HTML or not content.formatted_body:
+            return None
+        match = REPLY_QUOTE_REGEX.match(content.formatted_body)
+        if not match:
+            return None
+        quote_text, quote_entities = await formatter.matrix_to_telegram(
+            client, html=match.group(1)
+        )
+        # Trailing whitespace doesn't affect entity offsets, unlike leading whitespace
+        quote_text = quote_text.rstrip()
+        if not quote_text.strip():
+            return None
+        try:
+            replied_msg = await client.get_messages(self.peer, ids=reply_to)
+        except RPCError as e:
+            self.log.debug(f"Failed to get {reply_to} to check reply quote: {e}")
+            return None
+        index = replied_msg.message.find(quote_text) if replied_msg and replied_msg.message else -1
+        if index < 0:
+            # Not a quote of the replied-to message, so leave it as a normal blockquote
+            return None
+        # Telegram counts offsets in UTF-16 code units
+        offset = len(replied_msg.message[:index].encode("utf-16-le")) // 2
+        content.formatted_body = content.formatted_body[match.end() :]
+        body_lines = content.body.split("\n")
+        while body_lines and body_lines[0].startswith(">"):
+            body_lines.pop(0)
+        content.body = "\n".join(body_lines).lstrip("\n")
+        return ReplyQuote(text=quote_text, entities=quote_entities, offset=offset)
+
     async def _handle_matrix_text(
         self,
         sender: u.User,
@@ -1788,10 +2705,18 @@ class Portal(DBPortal, BasePortal):
         content: TextMessageEventContent,
         reply_to: TelegramID | None,
     ) -> None:
+        quote = None
+        if reply_to and not content.get_edit():
+            quote = await self._extract_reply_quote(client, content, reply_to)
+        # Edits can't be split into multiple messages, so they're still cut off at the limit
         message, entities = await formatter.matrix_to_telegram(
-            client, text=content.body, html=content.formatted(Format.HTML)
+            client,
+            text=content.body,
+            html=content.formatted(Format.HTML),
+            cut=bool(content.get_edit()),
         )
-        sender_id = sender.tgid if logged_in else self.bot.tgid
+        sender_id = sender.tgid if logged_in else self.relay.tgid
+        send_as = await self._get_send_as(sender) if logged_in else None
         async with self.send_lock(sender_id):
             lp = self.get_config("telegram_link_preview")
             if content.get_edit():
@@ -1815,12 +2740,15 @@ class Portal(DBPortal, BasePortal):
                         msgtype=content.msgtype,
                     )
                     return
-            response = await client.send_message(
-                self.peer,
-                message,
+            chunks = formatter.split_long_message(message, entities)
+            response = await self._send_text(
+                client,
+                chunks[0][0],
+                chunks[0][1],
                 reply_to=reply_to,
-                formatting_entities=entities,
-                link_preview=lp,
+                send_as=send_as,
+                content=content,
+                quote=quote,
             )
             await self._mark_matrix_handled(
                 sender=sender,
@@ -1832,6 +2760,15 @@ class Portal(DBPortal, BasePortal):
                 response=response,
                 msgtype=content.msgtype,
             )
+            for chunk_text, chunk_entities in chunks[1:]:
+                response = await self._send_text(
+                    client, chunk_text, chunk_entities, send_as=send_as
+                )
+                # Only the first chunk is stored in the database, but the rest still need to be
+                # marked as sent by the bridge so they don't get bridged back to Matrix.
+                self.dedup.check(response, (event_id, space))
+            if len(chunks) > 1:
+                self.log.debug(f"Sent {event_id} as {len(chunks)} messages, as it was too long")
 
     async def _handle_matrix_file(
         self,
@@ -1845,7 +2782,7 @@ class Portal(DBPortal, BasePortal):
         file_name: str,
         caption: TextMessageEventContent = None,
     ) -> None:
-        sender_id = sender.tgid if logged_in else self.bot.tgid
+        sender_id = sender.tgid if logged_in else self.relay.tgid
         mime = content.info.mimetype
         if isinstance(content.info, (ImageInfo, VideoInfo)):
             w, h = content.info.width, content.info.height
@@ -1854,8 +2791,15 @@ class Portal(DBPortal, BasePortal):
         max_image_size = self.config["bridge.image_as_file_size"] * 1000**2
         max_image_pixels = self.config["bridge.image_as_file_pixels"]
 
+        media_class = util.get_media_class(content)
         attributes = []
-        if self.config["bridge.parallel_file_transfer"] and content.url:
+        # Streamed transfers can't be converted, so files that need conversion are always
+        # downloaded fully first.
+        if (
+            self.config["bridge.parallel_file_transfer"]
+            and content.url
+            and not util.get_conversion(media_class, mime)
+        ):
             file_handle, file_size = await util.parallel_transfer_to_telegram(
                 client, self.main_intent, content.url, sender_id
             )
@@ -1873,15 +2817,20 @@ class Portal(DBPortal, BasePortal):
             else:
                 file = await self.main_intent.download_media(content.url)
 
+            converted = await util.convert_for_telegram(file, mime, media_class)
+            # Some conversions (e.g. cropping round videos) don't change the mime type
+            if converted.data is not file:
+                self.log.debug(f"Converted {mime} in {event_id} to {converted.mime}")
+                mime, file = converted.mime, converted.data
+                w, h = converted.width or w, converted.height or h
+                ext = sane_mimetypes.guess_extension(mime) or ""
+                file_name = f"{file_name.rsplit('.', 1)[0]}{ext}"
+
             if content.msgtype == MessageType.STICKER:
                 if mime == "image/gif":
                     # Remove sticker description
                     file_name = "sticker.gif"
                 else:
-                    if mime not in ("video/webm", "application/x-tgsticker"):
-                        mime, file, w, h = util.convert_image(
-                            file, source_mime=mime, target_type="webp"
-                        )
                     attributes.append(
                         DocumentAttributeSticker(
                             alt=content.body, stickerset=InputStickerSetEmpty()
@@ -1896,11 +2845,18 @@ class Portal(DBPortal, BasePortal):
         attributes.append(DocumentAttributeFilename(file_name=file_name))
 
         if content.msgtype == MessageType.VIDEO:
+            round_message = media_class == "round"
+            if round_message and (not w or w != h):
+                # Telegram won't accept non-square round videos, so send it as a normal video if
+                # cropping it failed.
+                self.log.warning(f"Sending {event_id} as a normal video as it isn't square")
+                round_message = False
             attributes.append(
                 DocumentAttributeVideo(
                     duration=int(content.info.duration // 1000 if content.info.duration else 0),
                     w=w or 0,
                     h=h or 0,
+                    round_message=round_message,
                 )
             )
         elif content.msgtype == MessageType.AUDIO:
@@ -1933,11 +2889,52 @@ class Portal(DBPortal, BasePortal):
 
         capt, entities = (
             await formatter.matrix_to_telegram(
-                client, text=caption.body, html=caption.formatted(Format.HTML)
+                client, text=caption.body, html=caption.formatted(Format.HTML), cut=False
             )
             if caption
             else (None, None)
         )
+        extra_chunks = []
+        if capt:
+            # Bots always have the non-premium limit
+            max_caption_length = await sender.get_max_caption_length() if logged_in else 1024
+            (capt, entities), *extra_chunks = formatter.split_long_message(
+                capt, entities, first_max_length=max_caption_length
+            )
+            if content.get_edit():
+                # Edits can't send extra messages, so just cut off the rest
+                extra_chunks = []
+
+        send_as = await self._get_send_as(sender) if logged_in else None
+        if self._can_batch_into_album(content, media, extra_chunks):
+            try:
+                await self.album_batcher.send(
+                    sender_id,
+                    client,
+                    media,
+                    capt,
+                    entities,
+                    reply_to,
+                    on_sent=lambda resp: self._mark_matrix_handled(
+                        sender=sender,
+                        sender_tgid=sender_id,
+                        event_type=EventType.ROOM_MESSAGE,
+                        event_id=event_id,
+                        space=space,
+                        edit_index=0,
+                        response=resp,
+                        msgtype=content.msgtype,
+                    ),
+                    send_as=send_as,
+                )
+            except (
+                PhotoInvalidDimensionsError,
+                PhotoSaveFileInvalidError,
+                PhotoExtInvalidError,
+            ):
+                self.log.debug(f"Failed to send {event_id} as part of album, retrying alone")
+            else:
+                return
 
         async with self.send_lock(sender_id):
             if await self._matrix_document_edit(
@@ -1947,7 +2944,12 @@ class Portal(DBPortal, BasePortal):
             try:
                 try:
                     response = await client.send_media(
-                        self.peer, media, reply_to=reply_to, caption=capt, entities=entities
+                        self.peer,
+                        media,
+                        reply_to=reply_to,
+                        caption=capt,
+                        entities=entities,
+                        send_as=send_as,
                     )
                 except (
                     PhotoInvalidDimensionsError,
@@ -1958,7 +2960,12 @@ class Portal(DBPortal, BasePortal):
                         file=media.file, mime_type=mime, attributes=attributes
                     )
                     response = await client.send_media(
-                        self.peer, media, reply_to=reply_to, caption=capt, entities=entities
+                        self.peer,
+                        media,
+                        reply_to=reply_to,
+                        caption=capt,
+                        entities=entities,
+                        send_as=send_as,
                     )
             except Exception:
                 raise
@@ -1973,6 +2980,22 @@ class Portal(DBPortal, BasePortal):
                     response=response,
                     msgtype=content.msgtype,
                 )
+                for chunk_text, chunk_entities in extra_chunks:
+                    response = await self._send_text(
+                        client, chunk_text, chunk_entities, send_as=send_as
+                    )
+                    self.dedup.check(response, (event_id, space))
+
+    def _can_batch_into_album(
+        self, content: MediaMessageEventContent, media: TypeInputMedia, extra_chunks: list
+    ) -> bool:
+        if self.config["bridge.album_batch_window"] <= 0 or content.get_edit() or extra_chunks:
+            return False
+        elif isinstance(media, InputMediaUploadedPhoto):
+            return True
+        # Videos can be grouped with photos, but other documents can only form their own albums.
+        # Round videos can't be in albums at all.
+        return util.get_media_class(content) == "video"
 
     async def _matrix_document_edit(
         self,
@@ -2019,19 +3042,34 @@ class Portal(DBPortal, BasePortal):
         content: LocationMessageEventContent,
         reply_to: TelegramID,
     ) -> None:
-        sender_id = sender.tgid if logged_in else self.bot.tgid
+        sender_id = sender.tgid if logged_in else self.relay.tgid
         try:
             lat, long = content.geo_uri[len("geo:") :].split(";")[0].split(",")
             lat, long = float(lat), float(long)
         except (KeyError, ValueError):
             self.log.exception("Failed to parse location")
             return None
-        try:
-            caption = content["org.matrix.msc3488.location"]["description"]
-            entities = []
-        except KeyError:
-            caption, entities = await formatter.matrix_to_telegram(client, text=content.body)
-        media = MessageMediaGeo(geo=GeoPoint(lat=lat, long=long, access_hash=0))
+        geo = GeoPoint(lat=lat, long=long, access_hash=0)
+        venue = content.get("fi.mau.telegram.venue")
+        if isinstance(venue, dict) and venue.get("title"):
+            # Venues can't have captions, all the info is in the venue fields
+            caption, entities = None, []
+            media = MessageMediaVenue(
+                geo=geo,
+                title=venue["title"],
+                address=venue.get("address") or "",
+                provider=venue.get("provider") or "",
+                venue_id=venue.get("venue_id") or "",
+                venue_type=venue.get("venue_type") or "",
+            )
+        else:
+            try:
+                caption = content["org.matrix.msc3488.location"]["description"]
+                entities = []
+            except KeyError:
+                caption, entities = await formatter.matrix_to_telegram(client, text=content.body)
+            media = MessageMediaGeo(geo=geo)
+        send_as = await self._get_send_as(sender) if logged_in else None
 
         async with self.send_lock(sender_id):
             if await self._matrix_document_edit(
@@ -2040,7 +3078,12 @@ class Portal(DBPortal, BasePortal):
                 return
             try:
                 response = await client.send_media(
-                    self.peer, media, reply_to=reply_to, caption=caption, entities=entities
+                    self.peer,
+                    media,
+                    reply_to=reply_to,
+                    caption=caption,
+                    entities=entities,
+                    send_as=send_as,
                 )
             except Exception:
                 raise
@@ -2127,6 +3170,10 @@ class Portal(DBPortal, BasePortal):
             return "Message formatting entities are malformed"
         elif isinstance(err, EntityMentionUserInvalidError):
             return "You mentioned an invalid user"
+        elif isinstance(err, RPCError) and err.message == "TOPIC_CLOSED":
+            return "The topic is closed, only admins can send messages there"
+        elif isinstance(err, RPCError) and err.message == "TOPIC_DELETED":
+            return "The topic was deleted"
         return None
 
     async def _send_message_status(self, event_id: EventID, err: Exception | None) -> None:
@@ -2187,7 +3234,7 @@ class Portal(DBPortal, BasePortal):
         self, sender: u.User, content: MessageEventContent, event_id: EventID
     ) -> None:
         try:
-            await self._handle_matrix_message(sender, content, event_id)
+            await self._handle_matrix_message_with_backoff(sender, content, event_id)
         except RPCError as e:
             self.log.exception(f"RPCError while bridging {event_id}: {e}")
             await self._send_bridge_error(
@@ -2211,6 +3258,58 @@ class Portal(DBPortal, BasePortal):
                 message_type=content.msgtype,
             )
 
+    async def _handle_matrix_message_with_backoff(
+        self, sender: u.User, content: MessageEventContent, event_id: EventID
+    ) -> None:
+        dc_id = sender.client.session.dc_id if sender.client else 0
+        deadline = time.monotonic() + MAX_OUTAGE_QUEUE_TIME
+        if util.server_outages.remaining(dc_id) <= 0 and not self._outage_queue_lock.locked():
+            try:
+                await self._handle_matrix_message_traced(sender, content, event_id)
+            except util.SERVER_ERRORS as e:
+                if not self._should_retry_matrix_message(e, dc_id, deadline):
+                    raise
+                self.log.warning(f"Telegram server error while bridging {event_id} ({e})")
+            else:
+                util.server_outages.record_success(dc_id)
+                return
+        # If Telegram is having an outage, messages wait here instead of failing immediately.
+        # The lock wakes up waiters in FIFO order, so messages are retried one at a time in the
+        # order they arrived rather than racing for the send lock when the outage ends.
+        async with self._outage_queue_lock:
+            while True:
+                await util.server_outages.wait(dc_id)
+                try:
+                    await self._handle_matrix_message_traced(sender, content, event_id)
+                except util.SERVER_ERRORS as e:
+                    if not self._should_retry_matrix_message(e, dc_id, deadline):
+                        raise
+                    self.log.warning(
+                        f"Telegram server error while bridging {event_id} ({e}), "
+                        f"retrying in {util.server_outages.remaining(dc_id)} seconds"
+                    )
+                else:
+                    util.server_outages.record_success(dc_id)
+                    return
+
+    @staticmethod
+    def _should_retry_matrix_message(err: Exception, dc_id: int, deadline: float) -> bool:
+        backoff = util.server_outages.record_error(dc_id)
+        # Don't retry if the request that sends the message timed out, as it may have been
+        # delivered even though the request failed.
+        return (
+            not getattr(err, "maybe_sent", False)
+            and backoff > 0
+            and time.monotonic() + backoff <= deadline
+        )
+
+    async def _handle_matrix_message_traced(
+        self, sender: u.User, content: MessageEventContent, event_id: EventID
+    ) -> None:
+        msgtype = str(content.msgtype)
+        with tracing.span("matrix.handle_message", event_id=event_id, msgtype=msgtype):
+            await self._handle_matrix_message(sender, content, event_id)
+
     async def _find_source_msg(
         self, sender: u.User, content: MessageEventContent
     ) -> DBMessage | None:
@@ -2240,7 +3339,7 @@ class Portal(DBPortal, BasePortal):
             return
         dbm = await DBMessage.get_one_by_tgid(TelegramID(msg_id), TelegramID(space))
         if dbm and peer_type == "chat" and space != sender.tgid:
-            dbm = DBMessage.get_by_mxid(dbm.mxid, dbm.mx_room, sender.tgid)
+            dbm = await DBMessage.get_by_mxid(dbm.mxid, dbm.mx_room, sender.tgid)
         return dbm
 
     async def _handle_matrix_forward(
@@ -2254,12 +3353,11 @@ class Portal(DBPortal, BasePortal):
         source_portal = await Portal.get_by_mxid(msg.mx_room)
         if not source_portal:
             return False
+        send_as = await self._get_send_as(sender)
         async with self.send_lock(sender.tgid):
             try:
-                response = await sender.client.forward_messages(
-                    self.peer,
-                    messages=[msg.tgid],
-                    from_peer=source_portal.peer,
+                response = await sender.client.forward_as(
+                    self.peer, [msg.tgid], from_peer=source_portal.peer, send_as=send_as
                 )
             except Exception as e:
                 self.log.warning(
@@ -2288,14 +3386,16 @@ class Portal(DBPortal, BasePortal):
         elif not content.body:
             raise IgnoredMessageError("Message doesn't have a body")
 
-        logged_in = not await sender.needs_relaybot(self)
-        client = sender.client if logged_in else self.bot.client
+        # Channels with a portal bot only accept posts through the bot, even from logged in users
+        logged_in = not self.portal_bot and not await sender.needs_relaybot(self)
+        client = sender.client if logged_in else self.relay.client
         space = (
             self.tgid
             if self.peer_type == "channel"  # Channels have their own ID space
-            else (sender.tgid if logged_in else self.bot.tgid)
+            else (sender.tgid if logged_in else self.relay.tgid)
         )
-        source_msg = await self._find_source_msg(sender, content)
+        # Forwards are sent from the sender's own account, so they're skipped with portal bots
+        source_msg = None if self.portal_bot else await self._find_source_msg(sender, content)
         if source_msg and await self._handle_matrix_forward(
             sender, source_msg, event_id, space, content.msgtype
         ):
@@ -2355,17 +3455,24 @@ class Portal(DBPortal, BasePortal):
             if caption_content:
                 caption_content.msgtype = content.msgtype
                 await self._pre_process_matrix_message(sender, not logged_in, caption_content)
-            await self._handle_matrix_file(
-                sender,
-                logged_in,
-                event_id,
-                space,
-                client,
-                content,
-                reply_to,
-                file_name,
-                caption_content,
-            )
+            upload_action = None
+            if content.msgtype != MessageType.STICKER:
+                upload_action = asyncio.create_task(self._send_upload_action(client, content))
+            try:
+                await self._handle_matrix_file(
+                    sender,
+                    logged_in,
+                    event_id,
+                    space,
+                    client,
+                    content,
+                    reply_to,
+                    file_name,
+                    caption_content,
+                )
+            finally:
+                if upload_action:
+                    upload_action.cancel()
         else:
             self.log.debug(
                 f"Didn't handle Matrix event {event_id} due to unknown msgtype {content.msgtype}"
@@ -2373,6 +3480,24 @@ class Portal(DBPortal, BasePortal):
             self.log.trace("Unhandled Matrix event content: %s", content)
             raise IgnoredMessageError(f"Unhandled msgtype {content.msgtype}")
 
+    async def handle_matrix_report(
+        self, sender: u.User, event_id: EventID, reason: str | None = None
+    ) -> TypeReportReason:
+        tg_space = self.tgid if self.peer_type == "channel" else sender.tgid
+        msg = await DBMessage.get_by_mxid(event_id, self.mxid, tg_space)
+        if not msg:
+            raise ValueError("That message is not bridged to Telegram")
+        report_reason = report_reason_from_text(reason)
+        await sender.client(
+            ReportRequest(
+                peer=self.peer, id=[msg.tgid], reason=report_reason, message=reason or ""
+            )
+        )
+        self.log.debug(
+            f"{sender.mxid} reported {event_id} ({msg.tgid}) as {type(report_reason).__name__}"
+        )
+        return report_reason
+
     async def handle_matrix_unpin_all(self, sender: u.User, pin_event_id: EventID) -> None:
         await sender.client(UnpinAllMessagesRequest(peer=self.peer))
         await self._send_delivery_receipt(pin_event_id)
@@ -2449,7 +3574,7 @@ class Portal(DBPortal, BasePortal):
             )
 
     async def _handle_matrix_deletion(self, deleter: u.User, event_id: EventID) -> None:
-        real_deleter = deleter if not await deleter.needs_relaybot(self) else self.bot
+        real_deleter = deleter if not await deleter.needs_relaybot(self) else self.relay
         tg_space = self.tgid if self.peer_type == "channel" else real_deleter.tgid
         message = await DBMessage.get_by_mxid(event_id, self.mxid, tg_space)
         if not message:
@@ -2664,18 +3789,24 @@ class Portal(DBPortal, BasePortal):
         elif self.avatar_url == url:
             return
 
-        self.avatar_url = url
-        file = await self.main_intent.download_media(url)
-        mime = magic.mimetype(file)
-        ext = sane_mimetypes.guess_extension(mime)
-        uploaded = await sender.client.upload_file(file, file_name=f"avatar{ext}")
-        photo = InputChatUploadedPhoto(file=uploaded)
+        if url:
+            file = await self.main_intent.download_media(url)
+            mime = magic.mimetype(file)
+            ext = sane_mimetypes.guess_extension(mime)
+            uploaded = await sender.client.upload_file(file, file_name=f"avatar{ext}")
+            photo = InputChatUploadedPhoto(file=uploaded)
+        else:
+            photo = InputChatPhotoEmpty()
 
         if self.peer_type == "chat":
             response = await sender.client(EditChatPhotoRequest(chat_id=self.tgid, photo=photo))
         else:
             channel = await self.get_input_entity(sender)
             response = await sender.client(EditPhotoRequest(channel=channel, photo=photo))
+        self.avatar_url = url
+        if not url:
+            self.photo_id = ""
+            await self.save()
         self.dedup.register_outgoing_actions(response)
         for update in response.updates:
             is_photo_update = (
@@ -2741,9 +3872,28 @@ class Portal(DBPortal, BasePortal):
         if user.is_real_user:
             # Ignore typing notifications from double puppeted users to avoid echoing
             return
-        is_typing = isinstance(update.action, SendMessageTypingAction)
+        if isinstance(update.action, SendMessageCancelAction):
+            is_typing = False
+        elif isinstance(update.action, TYPING_ACTIONS):
+            is_typing = True
+        else:
+            # Emoji interactions, group call speaking and history imports aren't typing
+            return
         await user.default_mxid_intent.set_typing(self.mxid, timeout=5000 if is_typing else 0)
 
+    def _is_counter_only_edit(self, evt: Message) -> bool:
+        # Broadcast channels get an edit update whenever the view, forward, reply or reaction
+        # counters of a post change. Those don't set edit_date, so they can be dropped before
+        # the dedup hashing and database lookups. Link previews are loaded asynchronously and
+        # arrive the same way, so posts with web page media still go through the full path.
+        return (
+            self.peer_type == "channel"
+            and not self.megagroup
+            and isinstance(evt, Message)
+            and evt.edit_date is None
+            and not isinstance(evt.media, MessageMediaWebPage)
+        )
+
     async def handle_telegram_edit(
         self, source: au.AbstractUser, sender: p.Puppet | None, evt: Message
     ) -> None:
@@ -2754,10 +3904,23 @@ class Portal(DBPortal, BasePortal):
             self.log.debug("Ignoring game message edit event")
             return
 
-        if self.peer_type != "channel" and isinstance(evt, Message) and evt.reactions is not None:
+        if (
+            (self.peer_type != "channel" or self.megagroup)
+            and isinstance(evt, Message)
+            and evt.reactions is not None
+            # Min reactions don't say which reactions are ours. Other chats list the reaction
+            # senders, but DM reactions are split into ours and theirs using that info.
+            and not (evt.reactions.min and self.peer_type == "user")
+        ):
+            self.mark_reactions_pushed(source, TelegramID(evt.id))
             background_task.create(
                 self.try_handle_telegram_reactions(source, TelegramID(evt.id), evt.reactions)
             )
+        if self._is_counter_only_edit(evt):
+            self.log.trace("Ignoring edit of %d that only changes counters", evt.id)
+            return
+        elif sender and await self._is_banned_by_policy_list(source, sender, evt, is_edit=True):
+            return
         sender_id = sender.tgid if sender else self.tgid
 
         async with self.send_lock(sender_id, required=False):
@@ -2861,7 +4024,7 @@ class Portal(DBPortal, BasePortal):
         anchor_msg_id: int | None = None,
         extra_data: dict[str, Any] | None = None,
         type: BackfillType = BackfillType.HISTORICAL,
-    ) -> None:
+    ) -> Backfill:
         new_backfill = Backfill.new(
             user_mxid=source.mxid,
             priority=priority,
@@ -2882,6 +4045,7 @@ class Portal(DBPortal, BasePortal):
                 "Deleted backfill queue entries while inserting new item: %s", deleted_entries
             )
         source.wakeup_backfill_task.set()
+        return new_backfill
 
     async def forward_backfill(
         self,
@@ -2924,9 +4088,25 @@ class Portal(DBPortal, BasePortal):
         if not self.backfill_enable:
             return "Backfilling is disabled in the bridge config"
         async with self.backfill_method_lock:
-            return await self._locked_backfill(
-                source, client, req, forward, forward_limit, last_tgid
-            )
+            with tracing.span("portal.backfill", portal=self.tgid_log, forward=forward):
+                try:
+                    return await self._locked_backfill(
+                        source, client, req, forward, forward_limit, last_tgid
+                    )
+                except FloodWaitError as e:
+                    if not forward:
+                        raise
+                    flood_wait = e.seconds
+        # Nothing is sent to Matrix until all messages have been fetched, so it's safe to wait
+        # out the flood and try again once. The lock isn't held while waiting, so other backfills
+        # of this portal can continue in the meantime. The forward timeout still applies.
+        self.log.warning(f"Got flood wait of {flood_wait}s in forward backfill, retrying")
+        await asyncio.sleep(flood_wait)
+        async with self.backfill_method_lock:
+            with tracing.span("portal.backfill", portal=self.tgid_log, forward=forward):
+                return await self._locked_backfill(
+                    source, client, req, forward, forward_limit, last_tgid
+                )
 
     async def _locked_backfill(
         self,
@@ -2967,15 +4147,16 @@ class Portal(DBPortal, BasePortal):
             first_in_room = await DBMessage.find_first(self.mxid, tg_space)
             anchor_id = first_in_room.tgid if first_in_room else None
             anchor_source = "lowest in chat"
-            if req.anchor_msg_id and req.anchor_msg_id < anchor_id:
+            if req.anchor_msg_id and (anchor_id is None or req.anchor_msg_id < anchor_id):
                 anchor_source = "backfill queue anchor"
                 anchor_id = req.anchor_msg_id
             self.log.debug(
                 f"Backfilling up to {req.messages_per_batch} historical messages "
                 f"before {anchor_id} ({anchor_source}) through {source.mxid}"
             )
+        skip_media = bool(req and req.extra_data.get("skip_media"))
         event_count, message_count, lowest_id = await self._backfill_messages(
-            source, client, forward, anchor_id, limit
+            source, client, forward, anchor_id, limit, skip_media
         )
         await self.save()
         if forward:
@@ -2991,6 +4172,7 @@ class Portal(DBPortal, BasePortal):
                 messages_per_batch=req.messages_per_batch,
                 max_batches=-1 if req.max_batches < 0 else (req.max_batches - 1),
                 anchor_msg_id=lowest_id,
+                extra_data=req.extra_data,
             )
         else:
             self.log.debug("No more messages to backfill")
@@ -3001,6 +4183,7 @@ class Portal(DBPortal, BasePortal):
         source: u.User,
         client: MautrixTelegramClient,
         msg: Message,
+        skip_media: bool = False,
     ) -> tuple[putil.ConvertedMessage, IntentAPI]:
         if msg.from_id and isinstance(msg.from_id, (PeerUser, PeerChannel)):
             sender = await p.Puppet.get_by_peer(msg.from_id)
@@ -3033,6 +4216,7 @@ class Portal(DBPortal, BasePortal):
             msg,
             client=client,
             deterministic_reply_id=self.bridge.homeserver_software.is_hungry,
+            skip_media=skip_media,
         )
         return converted, intent
 
@@ -3069,6 +4253,7 @@ class Portal(DBPortal, BasePortal):
         forward: bool,
         anchor_id: int,
         limit: int,
+        skip_media: bool = False,
     ) -> tuple[int, int, TelegramID]:
         entity = await self.get_input_entity(source)
         events = []
@@ -3080,6 +4265,10 @@ class Portal(DBPortal, BasePortal):
         first_id_found = False
         first_id = anchor_id
         message_count = 0
+        # Index in the events list where the current album starts, used to put the album
+        # captions after all the album parts rather than in the middle of the album.
+        album_id = album_start = None
+        album_captions = 0
         minmax = {"min_id": anchor_id} if forward else {"max_id": anchor_id}
         if not forward and not anchor_id:
             anchor_id = 2**31 - 1
@@ -3089,7 +4278,9 @@ class Portal(DBPortal, BasePortal):
             5 * 60, lambda: self.log.warning("Iterating messages is taking long")
         )
         # Iterate messages newest to oldest and collect the results
-        async for msg in client.iter_messages(entity, limit=limit, **minmax):
+        async for msg in client.iter_messages(
+            entity, limit=limit, wait_time=self.config["bridge.backfill.request_delay"], **minmax
+        ):
             message_count += 1
             if message_count == 1:
                 self.log.debug(f"Backfill iter: got first message {msg.id}")
@@ -3106,9 +4297,13 @@ class Portal(DBPortal, BasePortal):
                 first_id = msg.id
                 first_id_found = True
 
-            converted, intent = await self._convert_batch_msg(source, client, msg)
+            converted, intent = await self._convert_batch_msg(source, client, msg, skip_media)
             if converted is None:
                 continue
+            if not msg.grouped_id or msg.grouped_id != album_id:
+                album_id = msg.grouped_id
+                album_start = len(events)
+                album_captions = 0
             d_event_id = None
             if self.bridge.homeserver_software.is_hungry:
                 d_event_id = self._msg_conv.deterministic_event_id(tg_space, msg.id)
@@ -3116,9 +4311,18 @@ class Portal(DBPortal, BasePortal):
             intents.append(intent)
             metas.append(msg)
             if converted.caption:
-                events.append(await self._wrap_batch_msg(intent, msg, converted, caption=True))
-                intents.append(intent)
-                metas.append(None)
+                # The list is in reverse chronological order, so inserting the caption at the
+                # start of the album makes it come after all the album parts. Any part of an
+                # album can have a caption, so older captions go after the newer ones.
+                if album_id:
+                    caption_idx = album_start + album_captions
+                    album_captions += 1
+                else:
+                    caption_idx = len(events)
+                caption_evt = await self._wrap_batch_msg(intent, msg, converted, caption=True)
+                events.insert(caption_idx, caption_evt)
+                intents.insert(caption_idx, intent)
+                metas.insert(caption_idx, None)
         delay_warn_handle.cancel()
         if len(events) == 0:
             self.log.debug(
@@ -3130,6 +4334,21 @@ class Portal(DBPortal, BasePortal):
             f"Got {len(events)} events to send out of {message_count} messages fetched "
             f"(first received ID: {first_id}, lowest: {lowest_id})"
         )
+        await self._send_backfill_events(source, events, intents, metas, forward)
+        # Pinned messages that were just backfilled can only be pinned on Matrix now
+        pinned_ids = [msg.id for msg in metas if msg is not None and msg.pinned]
+        if pinned_ids:
+            await self.receive_telegram_pin_ids(pinned_ids, source.tgid, remove=False)
+        return len(events), message_count, lowest_id
+
+    async def _send_backfill_events(
+        self,
+        source: u.User,
+        events: list[BatchSendEvent],
+        intents: list[IntentAPI],
+        metas: list[Message | None],
+        forward: bool,
+    ) -> None:
         if self._enable_batch_sending:
             resp = await self.main_intent.beeper_batch_send(
                 self.mxid,
@@ -3164,7 +4383,19 @@ class Portal(DBPortal, BasePortal):
                 if msg is not None
             ]
         )
-        return len(events), message_count, lowest_id
+
+    async def sync_telegram_pins(self, source: u.User, client: MautrixTelegramClient) -> None:
+        pinned = await client.get_messages(
+            self.peer, limit=MAX_INITIAL_PINS, filter=InputMessagesFilterPinned()
+        )
+        pinned = [msg for msg in pinned if isinstance(msg, Message)]
+        if not pinned:
+            return
+        # Pinned messages that haven't been bridged aren't sent here, as they would end up at the
+        # bottom of the timeline. The backward backfill pins them when it gets to them.
+        await self.receive_telegram_pin_ids(
+            [msg.id for msg in reversed(pinned)], source.tgid, remove=False
+        )
 
     def _split_dm_reaction_counts(self, counts: list[ReactionCount]) -> list[MessagePeerReaction]:
         reactions = []
@@ -3190,18 +4421,28 @@ class Portal(DBPortal, BasePortal):
                 )
         return reactions
 
+    def mark_reactions_pushed(self, source: au.AbstractUser, msg_id: TelegramID) -> None:
+        tg_space = self.tgid if self.peer_type == "channel" else source.tgid
+        self._reaction_pushed_at.mark((tg_space, msg_id))
+
     async def _poll_telegram_reactions(self, source: au.AbstractUser) -> None:
-        now = time.monotonic()
-        if self._prev_reaction_poll[source.mxid] + REACTION_POLL_MIN_INTERVAL > now:
+        if not self._prev_reaction_poll.mark_if_expired(source.mxid):
             self.log.trace(
                 f"Not polling reactions through {source.mxid}, "
                 f"last poll was less than {REACTION_POLL_MIN_INTERVAL} seconds ago"
             )
             return
-        self._prev_reaction_poll[source.mxid] = now
-        self.log.debug(f"Polling reactions for recent messages through {source.mxid}")
         messages = await DBMessage.find_recent(self.mxid, source.tgid)
-        message_ids = [message.tgid for message in messages]
+        # Messages whose reactions Telegram pushed recently are already up to date
+        message_ids = [
+            message.tgid
+            for message in messages
+            if not self._reaction_pushed_at.is_recent((message.tg_space, message.tgid))
+        ]
+        if not message_ids:
+            self.log.trace("Not polling reactions, all recent messages had pushed updates")
+            return
+        self.log.debug(f"Polling reactions for recent messages through {source.mxid}")
         updates = await source.client(GetMessagesReactionsRequest(peer=self.peer, id=message_ids))
         for user in updates.users:
             user: User
@@ -3234,7 +4475,22 @@ class Portal(DBPortal, BasePortal):
         dbm: DBMessage | None = None,
         timestamp: datetime | None = None,
     ) -> None:
-        total_count = sum(item.count for item in data.results)
+        if ReactionPaid is not None and self.peer_type == "channel":
+            paid_count = sum(
+                item.count for item in data.results if isinstance(item.reaction, ReactionPaid)
+            )
+            if paid_count > 0:
+                dbm = dbm or await DBMessage.get_one_by_tgid(msg_id, self.tgid)
+                if dbm is not None:
+                    async with self.reaction_lock(dbm.mxid):
+                        await self._handle_telegram_paid_reactions_locked(
+                            dbm, paid_count, timestamp
+                        )
+            total_count = sum(
+                item.count for item in data.results if not isinstance(item.reaction, ReactionPaid)
+            )
+        else:
+            total_count = sum(item.count for item in data.results)
         recent_reactions = data.recent_reactions or []
         if total_count > 0 and not recent_reactions and not data.can_see_list:
             # We don't know who reacted in a channel, so we can't bridge it properly either
@@ -3256,18 +4512,106 @@ class Portal(DBPortal, BasePortal):
             elif source.is_bot:
                 # Can't fetch exact reaction senders as a bot
                 return
-            else:
-                # TODO should calls to this be limited?
-                resp = await source.client(
-                    GetMessageReactionsListRequest(peer=self.peer, id=dbm.tgid, limit=100)
+            elif data.can_see_list:
+                recent_reactions = (
+                    await self._fetch_reaction_list(source, dbm.tgid, total_count)
+                    or recent_reactions
                 )
-                recent_reactions = resp.reactions
 
         async with self.reaction_lock(dbm.mxid):
             await self._handle_telegram_user_reactions_locked(
                 source, dbm, recent_reactions, total_count, timestamp=timestamp
             )
 
+    async def _handle_telegram_paid_reactions_locked(
+        self, msg: DBMessage, count: int, timestamp: datetime | None = None
+    ) -> None:
+        key = f"{PAID_REACTION_PREFIX}{count}"
+        existing = [
+            reaction
+            for reaction in await DBReaction.get_by_sender(msg.mxid, msg.mx_room, self.tgid)
+            if reaction.reaction.startswith(PAID_REACTION_PREFIX)
+        ]
+        if any(reaction.reaction == key for reaction in existing):
+            return
+        # Senders of paid reactions aren't known in channels, so the total is shown as a
+        # single reaction from the bridge that's replaced whenever the count changes.
+        self.log.debug(f"Bridging paid reaction count {count} to {msg.tgid}")
+        for reaction in existing:
+            await self.main_intent.redact(reaction.mx_room, reaction.mxid)
+            await reaction.delete()
+        mxid = await self.main_intent.react(
+            msg.mx_room, msg.mxid, f"\u2b50 {count}", timestamp=timestamp
+        )
+        await DBReaction(
+            mxid=mxid, mx_room=msg.mx_room, msg_mxid=msg.mxid, tg_sender=self.tgid, reaction=key
+        ).save()
+
+    async def send_paid_reaction(self, user: u.User, msg_id: TelegramID, count: int) -> None:
+        if SendPaidReactionRequest is None:
+            raise NotImplementedError("Paid reactions aren't supported by this bridge version")
+        # Telegram expects the random ID of paid reactions to start with the current timestamp
+        random_id = (int(time.time()) << 32) | random.getrandbits(32)
+        await user.client(
+            SendPaidReactionRequest(
+                peer=self.peer, msg_id=msg_id, count=count, random_id=random_id
+            )
+        )
+
+    async def start_bot(self, user: u.User, start_param: str | None = None) -> None:
+        """Send /start to the bot in this private chat, optionally with a deep link payload."""
+        if not start_param:
+            # Telegram apps send a normal message when there's no payload
+            command = [MessageEntityBotCommand(offset=0, length=len("/start"))]
+            await user.client.send_text(self.peer, "/start", entities=command)
+            return
+        bot = await user.client.get_input_entity(self.peer)
+        await user.client(StartBotRequest(bot=bot, peer=bot, start_param=start_param))
+
+    async def send_when_online(self, user: u.User, message: str) -> None:
+        message, entities = await formatter.matrix_to_telegram(user.client, text=message)
+        await user.client.send_text(
+            self.peer, message, entities=entities, schedule_date=SEND_WHEN_ONLINE_TIMESTAMP
+        )
+
+    async def _fetch_reaction_list(
+        self, source: au.AbstractUser, msg_id: TelegramID, total_count: int
+    ) -> list[MessagePeerReaction] | None:
+        if self._reaction_list_next_fetch - time.monotonic() > REACTION_LIST_FETCH_INTERVAL:
+            # Still waiting for a previous flood wait to expire
+            return None
+        reactions: list[MessagePeerReaction] = []
+        offset = None
+        # The lock makes concurrent fetches (e.g. during backfill) wait for each other,
+        # so that the calls are spaced out instead of hitting flood waits immediately.
+        async with self._reaction_list_lock:
+            while len(reactions) < total_count:
+                delay = self._reaction_list_next_fetch - time.monotonic()
+                if delay > 0:
+                    await asyncio.sleep(delay)
+                try:
+                    resp = await source.client(
+                        GetMessageReactionsListRequest(
+                            peer=self.peer, id=msg_id, limit=100, offset=offset
+                        )
+                    )
+                except FloodWaitError as e:
+                    self.log.warning(
+                        f"Got flood wait fetching reaction list of {msg_id}, "
+                        f"not fetching more reaction lists for {e.seconds} seconds"
+                    )
+                    self._reaction_list_next_fetch = time.monotonic() + e.seconds
+                    return None
+                self._reaction_list_next_fetch = time.monotonic() + REACTION_LIST_FETCH_INTERVAL
+                for user in resp.users:
+                    puppet = await p.Puppet.get_by_tgid(TelegramID(user.id))
+                    await puppet.update_info(source, user)
+                reactions += resp.reactions
+                if not resp.next_offset or not resp.reactions:
+                    break
+                offset = resp.next_offset
+        return reactions
+
     async def handle_telegram_bot_reactions(
         self, source: au.AbstractUser, update: UpdateBotMessageReaction
     ) -> None:
@@ -3369,6 +4713,8 @@ class Portal(DBPortal, BasePortal):
             sender_id = existing_reaction.tg_sender
             if only_user_id is not None and sender_id != only_user_id:
                 continue
+            elif existing_reaction.reaction.startswith(PAID_REACTION_PREFIX):
+                continue
             new_reactions = reactions.get(sender_id)
             if self._reactions_filter(new_reactions, existing_reaction):
                 if new_reactions is not None and len(new_reactions) == 0:
@@ -3383,6 +4729,14 @@ class Portal(DBPortal, BasePortal):
 
         new_reaction: TypeReaction
         for sender, new_reactions in reactions.items():
+            puppet: p.Puppet = await p.Puppet.get_by_tgid(sender)
+            rule = await self._get_policy_rule(puppet)
+            if rule:
+                self.log.debug(
+                    f"Ignoring reactions by {sender} to {msg.tgid}: "
+                    f"matches policy rule {rule.entity} in {rule.room_id}"
+                )
+                continue
             for new_wrapped_reaction in new_reactions:
                 new_reaction = new_wrapped_reaction.reaction
                 if isinstance(new_reaction, ReactionEmoji):
@@ -3399,7 +4753,6 @@ class Portal(DBPortal, BasePortal):
                     self.log.warning("Unknown reaction type %s", type(new_reaction))
                     continue
                 self.log.debug(f"Bridging reaction {emoji_id} by {sender} to {msg.tgid}")
-                puppet: p.Puppet = await p.Puppet.get_by_tgid(sender)
                 mxid = await puppet.intent_for(self).react(
                     msg.mx_room,
                     msg.mxid,
@@ -3442,6 +4795,164 @@ class Portal(DBPortal, BasePortal):
                     ),
                 )
 
+    async def _get_policy_rule(self, sender: p.Puppet) -> putil.PolicyRule | None:
+        list_rooms = self.get_config("policy_lists")
+        if not list_rooms:
+            return None
+        return await self.policy_lists.match(
+            self.az.intent, list_rooms, sender.default_mxid, sender.custom_mxid
+        )
+
+    async def _is_banned_by_policy_list(
+        self, source: au.AbstractUser, sender: p.Puppet, evt: Message, is_edit: bool = False
+    ) -> bool:
+        rule = await self._get_policy_rule(sender)
+        if not rule:
+            return False
+        self.log.info(
+            f"Ignoring {'edit of ' if is_edit else ''}message {evt.id} from {sender.tgid}: "
+            f"matches policy rule {rule.entity} in {rule.room_id} ({rule.reason or 'no reason'})"
+        )
+        # The original message was already reported, so edits aren't reported again
+        if self.get_config("policy_list_report") and not source.is_bot and not is_edit:
+            try:
+                await source.client(
+                    ReportRequest(
+                        peer=await self.get_input_entity(source),
+                        id=[evt.id],
+                        reason=InputReportReasonSpam(),
+                        message=rule.reason,
+                    )
+                )
+            except RPCError as e:
+                self.log.warning(f"Failed to report message {evt.id} from {sender.tgid}: {e}")
+        return True
+
+    async def _send_message(
+        self,
+        intent: IntentAPI,
+        content: MessageEventContent,
+        event_type: EventType = EventType.ROOM_MESSAGE,
+        **kwargs,
+    ) -> EventID:
+        with tracing.span("matrix.send_message", room_id=self.mxid, event_type=str(event_type)):
+            return await super()._send_message(intent, content, event_type=event_type, **kwargs)
+
+    async def poll_read_participants(
+        self, source: au.AbstractUser, msg_id: TelegramID, repoll: bool = True
+    ) -> None:
+        if self.peer_type == "channel" and not self.megagroup:
+            return
+        elif (
+            self._participants_count is None
+            or self._participants_count > READ_PARTICIPANTS_MAX_MEMBERS
+        ):
+            # The member count is only known after the chat info has been synced
+            return
+        elif not self._read_participants_polled.mark_if_expired(msg_id):
+            return
+        tg_space = self.tgid if self.peer_type == "channel" else source.tgid
+        message = await DBMessage.get_one_by_tgid(msg_id, tg_space, edit_index=-1)
+        if not message:
+            return
+        try:
+            participants = await source.client(
+                GetMessageReadParticipantsRequest(
+                    peer=await self.get_input_entity(source), msg_id=msg_id
+                )
+            )
+        except RPCError as e:
+            self.log.debug(f"Failed to get read participants of {msg_id}: {e}")
+            return
+        for participant in participants:
+            # Older layers return plain user IDs instead of ReadParticipantDate objects
+            user_id = TelegramID(getattr(participant, "user_id", participant))
+            if user_id == source.tgid:
+                continue
+            puppet = await p.Puppet.get_by_tgid(user_id)
+            await puppet.intent.mark_read(self.mxid, message.mxid)
+        if repoll:
+            background_task.create(self._repoll_read_participants(source, msg_id))
+
+    async def refresh_post_stats(
+        self, source: au.AbstractUser, limit: int, min_interval: float = 0
+    ) -> None:
+        """
+        Update the view, forward and comment counts of the most recent channel posts. The counts
+        of all refreshed posts are stored in a single state event, which is only sent when the
+        counts change. If the portal was already refreshed within ``min_interval`` seconds (e.g.
+        through another user), this does nothing.
+        """
+        if self.peer_type != "channel" or self.megagroup or not self.mxid:
+            return
+        async with self._post_stats_lock:
+            if time.monotonic() - self._post_stats_refreshed_at < min_interval:
+                return
+            messages = await DBMessage.find_last_n(self.mxid, self.tgid, limit)
+            if not messages:
+                return
+            res = await source.client(
+                GetMessagesViewsRequest(
+                    peer=self.peer, id=[msg.tgid for msg in messages], increment=False
+                )
+            )
+            self._post_stats_refreshed_at = time.monotonic()
+            if self._post_stats is None:
+                try:
+                    prev_content = await self.main_intent.get_state_event(
+                        self.mxid, StatePostStats
+                    )
+                    self._post_stats = prev_content.serialize().get("posts", {})
+                except MatrixRequestError:
+                    self._post_stats = {}
+            stats = {
+                msg.mxid: putil.get_post_stats(views) for msg, views in zip(messages, res.views)
+            }
+            if stats != self._post_stats:
+                self._post_stats = stats
+                await self._try_set_state(None, StatePostStats, {"posts": stats})
+
+    async def _repoll_read_participants(self, source: au.AbstractUser, msg_id: TelegramID) -> None:
+        await asyncio.sleep(READ_PARTICIPANTS_REPOLL_DELAY)
+        await self.poll_read_participants(source, msg_id, repoll=False)
+
+    async def debug_convert(self, source: au.AbstractUser, evt: Message) -> dict[str, Any] | None:
+        """Convert a Telegram message like it would be bridged, but return it instead of sending.
+
+        Media is still reuploaded to Matrix, as the converted content refers to it.
+        """
+        sender = await p.Puppet.get_by_peer(evt.from_id) if evt.from_id else None
+        intent = sender.intent_for(self) if sender else self.main_intent
+        is_bot = sender.is_bot if sender else False
+        converted = await self._msg_conv.convert(
+            source, intent, is_bot, self.is_channel, evt, deterministic_reply_id=True
+        )
+        if not converted:
+            return None
+        data = {
+            "sender": intent.mxid,
+            "type": str(converted.type),
+            "content": converted.content.serialize(),
+        }
+        if converted.caption:
+            data["caption"] = converted.caption.serialize()
+        if converted.disappear_seconds:
+            data["disappear_seconds"] = converted.disappear_seconds
+            data["disappear_start_immediately"] = converted.disappear_start_immediately
+        return data
+
+    @staticmethod
+    async def _schedule_ghost_refreshes(
+        source: au.AbstractUser, sender: p.Puppet | None, evt: Message
+    ) -> None:
+        if sender:
+            sender.schedule_info_refresh(source)
+        for entity in evt.entities or []:
+            if isinstance(entity, MessageEntityMentionName):
+                puppet = await p.Puppet.get_by_tgid(TelegramID(entity.user_id), create=False)
+                if puppet:
+                    puppet.schedule_info_refresh(source)
+
     async def _handle_telegram_message(
         self, source: au.AbstractUser, sender: p.Puppet | None, evt: Message
     ) -> None:
@@ -3454,6 +4965,11 @@ class Portal(DBPortal, BasePortal):
                 self.log.warning("Room doesn't exist even after creating, dropping %d", evt.id)
                 return
 
+        if sender and await self._is_banned_by_policy_list(source, sender, evt):
+            return
+
+        await self._schedule_ghost_refreshes(source, sender, evt)
+
         if (
             self.peer_type == "user"
             and sender
@@ -3546,6 +5062,8 @@ class Portal(DBPortal, BasePortal):
             caption_id = await self._send_message(intent, converted.caption, timestamp=evt.date)
 
         self._new_messages_after_sponsored = True
+        if self.config["bridge.custom_emoji_pack"] and evt.entities:
+            background_task.create(self.emote_pack.add(evt.message, evt.entities))
 
         another_event_hash, prev_id = self.dedup.update(
             evt, (event_id, tg_space), (temporary_identifier, tg_space)
@@ -3589,6 +5107,13 @@ class Portal(DBPortal, BasePortal):
                     source, dbm.tgid, evt.reactions, dbm=dbm, timestamp=evt.date
                 )
             )
+        if (
+            self.megagroup
+            and isinstance(evt, Message)
+            and isinstance(evt.reply_to, MessageReplyHeader)
+            and self.config["bridge.channel_comment_threads"]
+        ):
+            background_task.create(self._mirror_channel_comment(source, sender, evt))
         await self._send_delivery_receipt(event_id)
         if converted.disappear_seconds:
             if converted.disappear_start_immediately:
@@ -3598,6 +5123,103 @@ class Portal(DBPortal, BasePortal):
             await self._mark_disappearing(event_id, converted.disappear_seconds, expires_at)
             if caption_id:
                 await self._mark_disappearing(caption_id, converted.disappear_seconds, expires_at)
+            if converted.media_ttl and not expires_at:
+                for ttl_event_id in (event_id, caption_id):
+                    if ttl_event_id:
+                        await TTLMedia(
+                            mx_room=self.mxid,
+                            event_id=ttl_event_id,
+                            tgid=TelegramID(evt.id),
+                            tg_space=tg_space,
+                            ttl=converted.media_ttl,
+                        ).insert()
+
+    async def _mirror_channel_comment(
+        self, source: au.AbstractUser, sender: p.Puppet | None, evt: Message
+    ) -> None:
+        top_id = evt.reply_to.reply_to_top_id or evt.reply_to.reply_to_msg_id
+        channel_id = self.linked_chat_id
+        if not top_id or not channel_id:
+            return
+        channel_portal = await Portal.get_by_tgid(channel_id)
+        if not channel_portal or not channel_portal.mxid:
+            return
+        post_id = await self._get_comment_thread_post(source, TelegramID(top_id), channel_id)
+        if not post_id:
+            # Not a comment thread of a linked channel post
+            return
+        post = await DBMessage.get_one_by_tgid(post_id, channel_id)
+        if not post or post.mx_room != channel_portal.mxid:
+            return
+        # Commenters can't send messages in the channel room, so the comments are sent by the
+        # portal's main intent with the commenter shown as a per-message profile (MSC4144).
+        intent = channel_portal.main_intent
+        is_bot = sender.is_bot if sender else False
+        converted = await channel_portal._msg_conv.convert(
+            source, intent, is_bot, False, evt, no_reply_fallback=True
+        )
+        if not converted:
+            return
+        profile = None
+        if sender:
+            displayname = sender.displayname or str(sender.tgid)
+            profile = {"id": str(sender.tgid), "displayname": displayname}
+            if sender.avatar_url:
+                profile["avatar_url"] = sender.avatar_url
+        # Mirrored comments aren't stored in the message table, so replies to other comments
+        # are attached to the channel post rather than the specific comment.
+        for content in (converted.content, converted.caption):
+            if content:
+                content.relates_to = RelatesTo(
+                    rel_type=RelationType.THREAD,
+                    event_id=post.mxid,
+                    is_falling_back=True,
+                    in_reply_to=InReplyTo(event_id=post.mxid),
+                )
+                content["fi.mau.telegram.comment"] = {
+                    "room_id": self.mxid,
+                    "chat_id": self.tgid,
+                    "id": evt.id,
+                }
+                if profile:
+                    content["com.beeper.per_message_profile"] = profile
+        try:
+            await channel_portal._send_message(
+                intent, converted.content, timestamp=evt.date, event_type=converted.type
+            )
+            if converted.caption:
+                await channel_portal._send_message(intent, converted.caption, timestamp=evt.date)
+        except Exception:
+            self.log.warning(
+                f"Failed to mirror comment {evt.id} to thread of {post.mxid}", exc_info=True
+            )
+
+    async def _get_comment_thread_post(
+        self, source: au.AbstractUser, top_id: TelegramID, channel_id: TelegramID
+    ) -> TelegramID | None:
+        """Get the ID of the channel post that a discussion group thread is the comments of."""
+        try:
+            return self._comment_thread_posts[top_id]
+        except KeyError:
+            pass
+        try:
+            top_msg = await source.client.get_messages(self.peer, ids=top_id)
+        except Exception:
+            self.log.warning(f"Failed to fetch thread root {top_id}", exc_info=True)
+            return None
+        fwd_from = getattr(top_msg, "fwd_from", None)
+        post_id = None
+        if (
+            fwd_from
+            and isinstance(fwd_from.saved_from_peer, PeerChannel)
+            and fwd_from.saved_from_peer.channel_id == channel_id
+            and fwd_from.saved_from_msg_id
+        ):
+            post_id = TelegramID(fwd_from.saved_from_msg_id)
+        if len(self._comment_thread_posts) >= MAX_COMMENT_THREAD_CACHE:
+            del self._comment_thread_posts[next(iter(self._comment_thread_posts))]
+        self._comment_thread_posts[top_id] = post_id
+        return post_id
 
     async def _mark_disappearing(
         self, event_id: EventID, seconds: int, expires_at: int | None
@@ -3609,6 +5231,24 @@ class Portal(DBPortal, BasePortal):
         if expires_at:
             background_task.create(self._disappear_event(dm))
 
+    async def handle_telegram_media_opened(self, ttl_media: TTLMedia) -> None:
+        await ttl_media.delete()
+        dm = await DisappearingMessage.get(self.mxid, ttl_media.event_id)
+        if not dm:
+            return
+        # The media was opened on Telegram, so it expires there after its real TTL instead of
+        # the extended one that's used when the timer starts from a Matrix read receipt.
+        # View-once media is gone immediately on Telegram, but it keeps the short grace period.
+        expires_at = int((time.time() + min(ttl_media.ttl, dm.expiration_seconds)) * 1000)
+        if dm.expiration_ts and dm.expiration_ts <= expires_at:
+            return
+        self.log.debug(
+            f"Media in {dm.event_id} was opened on Telegram, redacting it at {expires_at}"
+        )
+        dm.expiration_ts = expires_at
+        await dm.update()
+        background_task.create(self._disappear_event(dm))
+
     async def _create_room_on_action(
         self, source: au.AbstractUser, action: TypeMessageAction
     ) -> bool:
@@ -3642,6 +5282,21 @@ class Portal(DBPortal, BasePortal):
                 TextMessageEventContent(msgtype=MessageType.EMOTE, body=f"started a {call_type}"),
             )
 
+    async def handle_telegram_secret_chat_request(
+        self, source: au.AbstractUser, sender: p.Puppet
+    ) -> None:
+        self.log.debug(f"{sender.tgid} requested a secret chat with {source.tgid}")
+        await self._send_message(
+            sender.intent_for(self),
+            TextMessageEventContent(
+                msgtype=MessageType.EMOTE,
+                body=(
+                    "started a secret chat. Secret chats can't be bridged, "
+                    "use an official Telegram app to read it."
+                ),
+            ),
+        )
+
     async def handle_telegram_action(
         self, source: au.AbstractUser, sender: p.Puppet | None, update: MessageService
     ) -> None:
@@ -3662,14 +5317,21 @@ class Portal(DBPortal, BasePortal):
             await self.update_bridge_info()
         elif isinstance(action, MessageActionChatAddUser):
             for user_id in action.users:
-                await self._add_telegram_user(TelegramID(user_id), source)
+                if not await self._should_skip_membership_action(TelegramID(user_id)):
+                    await self._add_telegram_user(TelegramID(user_id), source)
         elif isinstance(action, (MessageActionChatJoinedByLink, MessageActionChatJoinedByRequest)):
-            await self._add_telegram_user(sender.id, source)
+            if self._pending_join_requests:
+                self._pending_join_requests.discard(sender.id)
+            if not await self._should_skip_membership_action(sender.id):
+                await self._add_telegram_user(sender.id, source)
         elif isinstance(action, MessageActionChatDeleteUser):
-            await self.delete_telegram_user(TelegramID(action.user_id), sender)
+            if not await self._should_skip_membership_action(TelegramID(action.user_id)):
+                await self.delete_telegram_user(TelegramID(action.user_id), sender)
         elif isinstance(action, MessageActionChatMigrateTo):
             await self._migrate_and_save_telegram(TelegramID(action.channel_id))
-            await self._send_message(
+            await self._send_action_message(
+                source,
+                update,
                 sender.intent_for(self),
                 TextMessageEventContent(
                     msgtype=MessageType.EMOTE,
@@ -3689,12 +5351,16 @@ class Portal(DBPortal, BasePortal):
             body = f"{call_type} {end_reason}"
             if action.duration:
                 body += f" ({format_duration(action.duration)})"
-            await self._send_message(
+            await self._send_action_message(
+                source,
+                update,
                 sender.intent_for(self),
                 TextMessageEventContent(msgtype=MessageType.NOTICE, body=body),
             )
         elif isinstance(action, MessageActionGroupCall):
-            await self._send_message(
+            await self._send_action_message(
+                source,
+                update,
                 sender.intent_for(self),
                 TextMessageEventContent(
                     msgtype=MessageType.EMOTE,
@@ -3706,7 +5372,9 @@ class Portal(DBPortal, BasePortal):
                 ),
             )
         elif isinstance(action, MessageActionGiftPremium):
-            await self._send_message(
+            await self._send_action_message(
+                source,
+                update,
                 sender.intent_for(self),
                 TextMessageEventContent(
                     msgtype=MessageType.EMOTE,
@@ -3717,7 +5385,9 @@ class Portal(DBPortal, BasePortal):
                 ),
             )
         elif isinstance(action, MessageActionBoostApply):
-            await self._send_message(
+            await self._send_action_message(
+                source,
+                update,
                 sender.intent_for(self),
                 TextMessageEventContent(
                     msgtype=MessageType.EMOTE,
@@ -3728,14 +5398,168 @@ class Portal(DBPortal, BasePortal):
                     ),
                 ),
             )
+        elif isinstance(action, MessageActionSetChatTheme):
+            await self._update_theme(action.emoticon, sender=sender, save=True)
+            await self._send_action_message(
+                source,
+                update,
+                sender.intent_for(self),
+                TextMessageEventContent(
+                    msgtype=MessageType.EMOTE,
+                    body=(
+                        f"changed the chat theme to {action.emoticon}"
+                        if action.emoticon
+                        else "disabled the chat theme"
+                    ),
+                ),
+            )
+        elif isinstance(action, MessageActionSetChatWallPaper):
+            if action.same:
+                body = "set the same wallpaper as the other user"
+            elif action.for_both:
+                body = "changed the wallpaper for both users"
+            else:
+                body = "changed the wallpaper"
+            await self._send_action_message(
+                source,
+                update,
+                sender.intent_for(self),
+                TextMessageEventContent(msgtype=MessageType.EMOTE, body=body),
+            )
+            if self.config["bridge.bridge_wallpapers"]:
+                await self._update_wallpaper(source, sender, action.wallpaper)
+        elif isinstance(action, MessageActionSetMessagesTTL):
+            self.ttl_period = action.period or None
+            await self.save()
+            await self.update_portal_info()
         elif isinstance(action, MessageActionGameScore):
             # TODO handle game score
             pass
+        elif isinstance(action, (MessageActionPaymentSent, MessageActionPaymentSentMe)):
+            await self._handle_telegram_payment(source, sender, update)
         elif isinstance(action, MessageActionContactSignUp):
             await self.handle_telegram_joined(source, sender, update)
+        elif isinstance(action, (MessageActionTopicCreate, MessageActionTopicEdit)):
+            await self._handle_telegram_topic_action(source, sender, update)
         else:
             self.log.trace("Unhandled Telegram action in %s: %s", self.title, action)
 
+    async def _send_action_message(
+        self,
+        source: au.AbstractUser,
+        update: MessageService,
+        intent: IntentAPI,
+        content: TextMessageEventContent,
+    ) -> EventID:
+        content["fi.mau.telegram.source"] = self._msg_conv.get_source_metadata(source, update)
+        return await self._send_message(intent, content)
+
+    async def _handle_telegram_payment(
+        self, source: au.AbstractUser, sender: p.Puppet | None, update: MessageService
+    ) -> None:
+        action: MessageActionPaymentSent | MessageActionPaymentSentMe = update.action
+        amount = putil.format_amount(action.total_amount, action.currency)
+        payment = {
+            "currency": action.currency,
+            "total_amount": action.total_amount,
+            "recurring_init": bool(action.recurring_init),
+            "recurring_used": bool(action.recurring_used),
+        }
+        invoice_title = None
+        reply_to = update.reply_to
+        reply_to_id = reply_to.reply_to_msg_id if reply_to else None
+        # The invoice that was paid may be in another chat, e.g. when paying in a bot DM
+        # for an invoice that was sent in a group.
+        invoice_peer = (reply_to.reply_to_peer_id if reply_to else None) or self.peer
+        if reply_to_id:
+            # The service message replies to the invoice that was paid
+            try:
+                invoice_msg = await source.client.get_messages(invoice_peer, ids=reply_to_id)
+            except (ValueError, RPCError) as e:
+                self.log.debug(f"Failed to fetch paid invoice {reply_to_id}: {e}")
+                invoice_msg = None
+            if invoice_msg and isinstance(invoice_msg.media, MessageMediaInvoice):
+                invoice_title = payment["invoice_title"] = invoice_msg.media.title
+        body = f"paid {amount} for {invoice_title}" if invoice_title else f"paid {amount}"
+        content = TextMessageEventContent(msgtype=MessageType.EMOTE, body=body)
+        content["fi.mau.telegram.payment"] = payment
+        if reply_to_id and get_peer_id(invoice_peer) == get_peer_id(self.peer):
+            tg_space = self.tgid if self.peer_type == "channel" else source.tgid
+            msg = await DBMessage.get_one_by_tgid(TelegramID(reply_to_id), tg_space)
+            if msg and msg.mx_room == self.mxid:
+                content.set_reply(msg.mxid)
+        intent = sender.intent_for(self) if sender else self.main_intent
+        await self._send_action_message(source, update, intent, content)
+
+    async def _handle_telegram_topic_action(
+        self, source: au.AbstractUser, sender: p.Puppet | None, update: MessageService
+    ) -> None:
+        action: MessageActionTopicCreate | MessageActionTopicEdit = update.action
+        if isinstance(action, MessageActionTopicCreate):
+            topic_id = update.id
+            prev = {}
+        else:
+            reply_to = update.reply_to
+            if reply_to and getattr(reply_to, "forum_topic", False):
+                topic_id = reply_to.reply_to_top_id or reply_to.reply_to_msg_id
+            else:
+                topic_id = GENERAL_TOPIC_ID
+            try:
+                prev_content = await self.main_intent.get_state_event(
+                    self.mxid, StateForumTopic, str(topic_id)
+                )
+                prev = prev_content.serialize()
+            except MatrixRequestError:
+                prev = {}
+        topic = {**prev, "id": topic_id}
+        if action.title:
+            topic["title"] = action.title
+        if topic_id == GENERAL_TOPIC_ID:
+            name = "the General topic"
+        elif topic.get("title"):
+            name = f"the topic {topic['title']}"
+        else:
+            name = "a topic"
+
+        changes = []
+        if isinstance(action, MessageActionTopicCreate):
+            topic["closed"] = topic["hidden"] = False
+            changes.append(f"created {name}")
+        else:
+            if action.title and action.title != prev.get("title"):
+                old_name = f"the topic {prev['title']}" if prev.get("title") else "a topic"
+                changes.append(f"renamed {old_name} to {action.title}")
+            if action.closed is not None and action.closed != prev.get("closed", False):
+                topic["closed"] = action.closed
+                if action.closed:
+                    changes.append(f"closed {name} (only admins can post there now)")
+                else:
+                    changes.append(f"reopened {name}")
+            if action.hidden is not None and action.hidden != prev.get("hidden", False):
+                topic["hidden"] = action.hidden
+                changes.append(f"{'hid' if action.hidden else 'unhid'} {name}")
+        if not changes:
+            return
+        await self._try_set_state(sender, StateForumTopic, topic, state_key=str(topic_id))
+        await self._send_action_message(
+            source,
+            update,
+            sender.intent_for(self) if sender else self.main_intent,
+            TextMessageEventContent(msgtype=MessageType.EMOTE, body=", ".join(changes)),
+        )
+
+    async def _should_skip_membership_action(self, user_id: TelegramID) -> bool:
+        max_count = self.config["bridge.skip_membership_actions_member_count"]
+        if max_count < 0 or self._participants_count is None:
+            return False
+        elif self._participants_count < max_count:
+            return False
+        elif await u.User.get_by_tgid(user_id):
+            # Always bridge membership changes of bridge users, as they affect the Matrix user
+            return False
+        self.log.trace(f"Ignoring membership action of {user_id} in large chat")
+        return True
+
     async def handle_telegram_joined(
         self,
         source: au.AbstractUser,
@@ -3814,6 +5638,25 @@ class Portal(DBPortal, BasePortal):
             return local
         return self.config[f"bridge.{key}"]
 
+    async def get_mention_keywords(self) -> dict[UserID, list[str]]:
+        if self._mention_keywords is None:
+            self._mention_keywords = await self.load_mention_keywords()
+        return self._mention_keywords
+
+    async def add_mention_keyword(self, user_id: UserID, keyword: str) -> None:
+        keywords = await self.get_mention_keywords()
+        await self.insert_mention_keyword(user_id, keyword)
+        keywords.setdefault(user_id, []).append(keyword)
+
+    async def remove_mention_keyword(self, user_id: UserID, keyword: str) -> None:
+        keywords = await self.get_mention_keywords()
+        await self.delete_mention_keyword(user_id, keyword)
+        user_keywords = keywords.get(user_id, [])
+        if keyword in user_keywords:
+            user_keywords.remove(keyword)
+        if not user_keywords:
+            keywords.pop(user_id, None)
+
     async def can_user_perform(self, user: u.User, event: str) -> bool:
         if user.is_admin:
             return True
@@ -3861,7 +5704,7 @@ class Portal(DBPortal, BasePortal):
     ) -> str:
         if self.peer_type == "user":
             raise ValueError("You can't invite users to private chats.")
-        if self.username:
+        if self.username and not (uses or expire or request_needed or title):
             return f"https://t.me/{self.username}"
         link = await user.client(
             ExportChatInviteRequest(
@@ -3874,22 +5717,30 @@ class Portal(DBPortal, BasePortal):
         )
         return link.link
 
+    async def revoke_invite_link(self, user: u.User, link: str) -> None:
+        if self.peer_type == "user":
+            raise ValueError("Private chats don't have invite links.")
+        await user.client(
+            EditExportedChatInviteRequest(
+                peer=await self.get_input_entity(user), link=link, revoked=True
+            )
+        )
+
     # endregion
     # region Matrix room cleanup
 
     async def get_authenticated_matrix_users(self) -> list[UserID]:
-        try:
-            members = await self.main_intent.get_room_members(self.mxid)
-        except MatrixRequestError:
-            return []
+        # Errors are intentionally not caught here, as an empty list makes callers clean up
+        # the portal, which shouldn't happen just because the homeserver request failed.
+        members = await self.main_intent.get_room_members(self.mxid)
         authenticated: list[UserID] = []
-        has_bot = self.has_bot
+        has_relay = self.has_relay
         for member in members:
             if p.Puppet.get_id_from_mxid(member) or member == self.az.bot_mxid:
                 continue
             user = await u.User.get_and_start_by_mxid(member)
-            authenticated_through_bot = has_bot and user.relaybot_whitelisted
-            if authenticated_through_bot or await user.has_full_access(allow_bot=True):
+            authenticated_through_relay = has_relay and user.relaybot_whitelisted
+            if authenticated_through_relay or await user.has_full_access(allow_bot=True):
                 authenticated.append(user.mxid)
         return authenticated
 
@@ -3922,11 +5773,75 @@ class Portal(DBPortal, BasePortal):
         self.sponsored_event_id = None
         self.sponsored_event_ts = None
         self.sponsored_msg_random_id = None
+        try:
+            settings = await self.export_settings()
+            if len(settings) > 1:
+                # Keep the settings around so they're restored if the portal is recreated
+                await self.backup_settings(settings)
+        except Exception:
+            self.log.exception("Failed to back up portal settings before deleting")
         await super().delete()
         await DBMessage.delete_all(self.mxid)
         await DBReaction.delete_all(self.mxid)
         self.deleted = True
 
+    async def export_settings(self) -> dict[str, Any]:
+        """Get the per-portal settings in a portable format for :meth:`import_settings`."""
+        settings: dict[str, Any] = {"version": SETTINGS_EXPORT_VERSION}
+        if self.local_config:
+            settings["config"] = self.local_config
+        if self.relay_user_id:
+            settings["relay_user_id"] = self.relay_user_id
+        send_as = await self.get_send_as_settings()
+        if send_as:
+            settings["send_as"] = {str(user_id): peer_id for user_id, peer_id in send_as.items()}
+        return settings
+
+    async def import_settings(self, settings: dict[str, Any]) -> None:
+        if not isinstance(settings, dict):
+            raise ValueError("settings must be an object")
+        elif settings.get("version", SETTINGS_EXPORT_VERSION) > SETTINGS_EXPORT_VERSION:
+            raise ValueError("settings were exported by a newer version of the bridge")
+        config = settings.get("config", {})
+        if not isinstance(config, dict):
+            raise ValueError("config must be an object")
+        send_as = {
+            TelegramID(int(user_id)): int(peer_id)
+            for user_id, peer_id in settings.get("send_as", {}).items()
+        }
+        self.local_config = config
+        self.relay_user_id = settings.get("relay_user_id")
+        await self.save()
+        if "send_as" in settings:
+            # Users who don't have a send-as identity in the imported settings go back to
+            # sending as themselves
+            for user_id in (await self.get_send_as_settings()).keys() - send_as.keys():
+                user = await u.User.get_by_tgid(user_id)
+                if user:
+                    await self._reset_send_as(user)
+        for user_id, peer_id in send_as.items():
+            user = await u.User.get_by_tgid(user_id)
+            if user:
+                await user.set_send_as(self, peer_id)
+
+    async def _reset_send_as(self, user: u.User) -> None:
+        if await user.is_logged_in():
+            try:
+                await user.client(
+                    SaveDefaultSendAsRequest(
+                        peer=await self.get_input_entity(user), send_as=InputPeerSelf()
+                    )
+                )
+            except (ValueError, RPCError) as e:
+                self.log.warning(f"Failed to reset default send-as identity of {user.mxid}: {e}")
+        await user.set_send_as(self, None)
+
+    async def restore_settings_backup(self) -> None:
+        settings = await self.pop_settings_backup()
+        if settings:
+            self.log.debug("Restoring settings from before the portal was deleted")
+            await self.import_settings(settings)
+
     # endregion
     # region Class instance lookup
 
@@ -3961,6 +5876,10 @@ class Portal(DBPortal, BasePortal):
     def all(cls) -> AsyncGenerator[Portal, None]:
         return cls._yield_portals(super().all())
 
+    @classmethod
+    def all_with_bot_token(cls) -> AsyncGenerator[Portal, None]:
+        return cls._yield_portals(super().all_with_bot_token())
+
     @classmethod
     def find_private_chats_of(cls, tg_receiver: TelegramID) -> AsyncGenerator[Portal, None]:
         return cls._yield_portals(super().find_private_chats_of(tg_receiver))
@@ -4038,6 +5957,10 @@ class Portal(DBPortal, BasePortal):
             portal = cls(tgid, peer_type=peer_type, tg_receiver=tg_receiver)
             await portal.postinit()
             await portal.insert()
+            try:
+                await portal.restore_settings_backup()
+            except Exception:
+                portal.log.exception("Failed to restore settings backup")
             return portal
 
         return None
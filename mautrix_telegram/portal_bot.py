@@ -0,0 +1,139 @@
+# mautrix-telegram - A Matrix-Telegram puppeting bridge
+# Copyright (C) 2026 Tulir Asokan
+#
+# This program is free software: you can redistribute it and/or modify
+# it under the terms of the GNU Affero General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# This program is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU Affero General Public License for more details.
+#
+# You should have received a copy of the GNU Affero General Public License
+# along with this program.  If not, see <https://www.gnu.org/licenses/>.
+from __future__ import annotations
+
+import logging
+
+from telethon.errors import AuthKeyError, RPCError, UnauthorizedError
+from telethon.tl.functions.channels import GetChannelsRequest
+from telethon.tl.types import InputChannel, TypeUpdate
+
+from . import portal as po, puppet as pu
+from .abstract_user import AbstractUser
+from .types import TelegramID
+
+
+class PortalBot(AbstractUser):
+    """
+    A bot account that is only used for sending Matrix messages to a single portal.
+
+    This is meant for broadcast channels where the users can only post through a bot, so the bot
+    acts as the relay of that portal. Incoming updates are ignored, because the chat is already
+    bridged through the logged in users.
+    """
+
+    log: logging.Logger = logging.getLogger("mau.user.portal_bot")
+    by_token: dict[str, PortalBot] = {}
+
+    token: str
+    tg_username: str | None
+
+    def __init__(self, token: str) -> None:
+        super().__init__()
+        self.token = token
+        self.tgid = None
+        self.mxid = None
+        self.tg_username = None
+        self.is_bot = True
+        self.puppet_whitelisted = True
+        self.whitelisted = True
+        self.relaybot_whitelisted = True
+        self.log = self.log.getChild(self.bot_id)
+
+    @property
+    def bot_id(self) -> str:
+        return self.token.split(":", 1)[0]
+
+    @property
+    def name(self) -> str:
+        return f"portal-bot-{self.bot_id}"
+
+    @classmethod
+    async def get(cls, token: str) -> PortalBot:
+        try:
+            return cls.by_token[token]
+        except KeyError:
+            pass
+        bot = cls(token)
+        cls.by_token[token] = bot
+        try:
+            await bot.start()
+        except Exception:
+            del cls.by_token[token]
+            await bot.stop()
+            raise
+        return bot
+
+    @classmethod
+    async def release(cls, token: str) -> None:
+        bot = cls.by_token.get(token)
+        # Portals that aren't loaded in memory may use the bot too, so check the database
+        if bot and not await po.Portal.is_bot_token_used(token):
+            del cls.by_token[token]
+            await bot.stop()
+
+    async def start(self, delete_unless_authenticated: bool = False) -> PortalBot:
+        await super().start(delete_unless_authenticated)
+        if not await self.is_logged_in():
+            await self.client.sign_in(bot_token=self.token)
+        await self.post_login()
+        return self
+
+    async def post_login(self) -> None:
+        info = await self.client.get_me()
+        self.tgid = TelegramID(info.id)
+        self.tg_username = info.username
+        self.mxid = pu.Puppet.get_mxid_from_id(self.tgid)
+
+    async def prepare_portal(self, portal: po.Portal) -> None:
+        """Make sure the bot can send to the portal, which also caches the chat access hash."""
+        if portal.peer_type != "channel":
+            raise ValueError("Portal bots can only be used in channels")
+        # Bots can use a zero access hash for channels they're in
+        resp = await self.client(GetChannelsRequest([InputChannel(portal.tgid, 0)]))
+        if not resp.chats or resp.chats[0].id != portal.tgid or resp.chats[0].left:
+            raise ValueError("The bot is not a member of the channel")
+
+    async def on_signed_out(self, err: UnauthorizedError | AuthKeyError) -> None:
+        self.log.error("Portal bot got signed out, removing it from portals", exc_info=err)
+        self.by_token.pop(self.token, None)
+        # Portals that aren't loaded would otherwise keep retrying the token on startup
+        await po.Portal.clear_bot_token(self.token)
+        for portal in list(po.Portal.by_tgid.values()):
+            if portal.bot_token == self.token:
+                portal.bot_token = None
+        await self.stop()
+
+    async def update(self, update: TypeUpdate) -> bool:
+        # The chat is bridged through real users, so updates received by the bot are dropped
+        return True
+
+    async def register_portal(self, portal: po.Portal) -> None:
+        pass
+
+    async def unregister_portal(self, tgid: int, tg_receiver: int) -> None:
+        pass
+
+    @classmethod
+    async def start_all(cls) -> None:
+        async for portal in po.Portal.all_with_bot_token():
+            try:
+                bot = await cls.get(portal.bot_token)
+                await bot.prepare_portal(portal)
+            except (RPCError, ValueError) as e:
+                portal.log.warning(f"Failed to start portal bot: {e}")
+            except Exception:
+                portal.log.exception("Failed to start portal bot")
@@ -0,0 +1,100 @@
+# mautrix-telegram - A Matrix-Telegram puppeting bridge
+# Copyright (C) 2026 Tulir Asokan
+#
+# This program is free software: you can redistribute it and/or modify
+# it under the terms of the GNU Affero General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# This program is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU Affero General Public License for more details.
+#
+# You should have received a copy of the GNU Affero General Public License
+# along with this program.  If not, see <https://www.gnu.org/licenses/>.
+from __future__ import annotations
+
+from unittest.mock import AsyncMock, MagicMock, patch
+
+from telethon.tl.types import (
+    MessageActionPaymentSent,
+    MessageMediaInvoice,
+    MessageReplyHeader,
+    MessageService,
+    PeerUser,
+)
+import pytest
+
+from mautrix.types import MessageType, RoomID
+
+from .db import Message as DBMessage
+from .portal import Portal
+
+ROOM_ID = RoomID("!portal:example.com")
+
+
+def make_portal() -> MagicMock:
+    portal = MagicMock()
+    portal.mxid = ROOM_ID
+    portal.peer = PeerUser(user_id=100)
+    portal.peer_type = "user"
+    portal._send_action_message = AsyncMock()
+    return portal
+
+
+def make_source(invoice_title: str | None = None) -> MagicMock:
+    source = MagicMock()
+    source.tgid = 1
+    invoice_msg = None
+    if invoice_title:
+        invoice_msg = MagicMock()
+        invoice_msg.media = MagicMock(spec=MessageMediaInvoice)
+        invoice_msg.media.title = invoice_title
+    source.client.get_messages = AsyncMock(return_value=invoice_msg)
+    return source
+
+
+def make_update(reply_to_msg_id: int | None = None) -> MessageService:
+    return MessageService(
+        id=10,
+        peer_id=PeerUser(user_id=100),
+        date=None,
+        action=MessageActionPaymentSent(currency="EUR", total_amount=1250),
+        reply_to=MessageReplyHeader(reply_to_msg_id=reply_to_msg_id) if reply_to_msg_id else None,
+    )
+
+
+def sent_content(portal: MagicMock):
+    portal._send_action_message.assert_awaited_once()
+    return portal._send_action_message.await_args.args[3]
+
+
+@pytest.mark.asyncio
+async def test_payment_sent() -> None:
+    portal = make_portal()
+    await Portal._handle_telegram_payment(portal, make_source(), None, make_update())
+    content = sent_content(portal)
+    assert content.msgtype == MessageType.EMOTE
+    assert content.body == "paid 12.50 EUR"
+    assert content.get("fi.mau.telegram.payment") == {
+        "currency": "EUR",
+        "total_amount": 1250,
+        "recurring_init": False,
+        "recurring_used": False,
+    }
+    assert portal._send_action_message.await_args.args[2] == portal.main_intent
+
+
+@pytest.mark.asyncio
+async def test_payment_sent_for_invoice() -> None:
+    portal = make_portal()
+    source = make_source(invoice_title="Pizza")
+    invoice = MagicMock(mxid="$invoice", mx_room=ROOM_ID)
+    with patch.object(DBMessage, "get_one_by_tgid", AsyncMock(return_value=invoice)):
+        await Portal._handle_telegram_payment(portal, source, None, make_update(5))
+    source.client.get_messages.assert_awaited_once_with(portal.peer, ids=5)
+    content = sent_content(portal)
+    assert content.body == "paid 12.50 EUR for Pizza"
+    assert content.get("fi.mau.telegram.payment")["invoice_title"] == "Pizza"
+    assert content.get_reply_to() == "$invoice"
@@ -1,6 +1,18 @@
+from .album import PortalAlbumBatcher
 from .deduplication import PortalDedup
-from .message_convert import ConvertedMessage, TelegramMessageConverter
+from .emote_pack import PortalEmotePack
+from .expiring_state import ExpiringTimestamps
+from .message_convert import (
+    BEEPER_LINK_PREVIEWS_KEY,
+    ConvertedMessage,
+    TelegramMessageConverter,
+    format_amount,
+    get_inline_buttons,
+    get_message_kind,
+    get_post_stats,
+)
 from .participants import get_users
+from .policy_list import USER_RULE_TYPES, PolicyListCache, PolicyRule
 from .power_levels import get_base_power_levels, participants_to_power_levels
 from .send_lock import PortalReactionLock, PortalSendLock
 from .sponsored_message import get_sponsored_message, make_sponsored_message_content
@@ -0,0 +1,161 @@
+# mautrix-telegram - A Matrix-Telegram puppeting bridge
+# Copyright (C) 2026 Tulir Asokan
+#
+# This program is free software: you can redistribute it and/or modify
+# it under the terms of the GNU Affero General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# This program is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU Affero General Public License for more details.
+#
+# You should have received a copy of the GNU Affero General Public License
+# along with this program.  If not, see <https://www.gnu.org/licenses/>.
+from __future__ import annotations
+
+from typing import TYPE_CHECKING, Any, Awaitable, Callable
+import asyncio
+
+from telethon.tl.patched import Message
+from telethon.tl.types import TypeInputMedia, TypeInputPeer, TypeMessageEntity
+
+from mautrix.util import background_task
+
+from ..tgclient import MautrixTelegramClient
+from ..types import TelegramID
+
+if TYPE_CHECKING:
+    from ..portal import Portal
+
+# Telegram doesn't allow more than 10 items in a single album
+MAX_ALBUM_SIZE = 10
+
+
+class _PendingAlbum:
+    client: MautrixTelegramClient
+    reply_to: TelegramID | None
+    send_as: TypeInputPeer | None
+    items: list[tuple[TypeInputMedia, str | None, list[TypeMessageEntity] | None]]
+    futures: list[asyncio.Future]
+    handlers: list[Callable[[Message], Awaitable[None]]]
+    flush_task: asyncio.Task | None
+
+    def __init__(
+        self,
+        client: MautrixTelegramClient,
+        reply_to: TelegramID | None,
+        send_as: TypeInputPeer | None,
+    ) -> None:
+        self.client = client
+        self.reply_to = reply_to
+        self.send_as = send_as
+        self.items = []
+        self.futures = []
+        self.handlers = []
+        self.flush_task = None
+
+
+class PortalAlbumBatcher:
+    """Collects media sent by the same user within a short window and sends it as an album.
+
+    The ``on_sent`` callback of each item is called while the send lock is still held, so that
+    the message can be marked as handled before the echo from Telegram arrives.
+    """
+
+    portal: Portal
+    _pending: dict[TelegramID, _PendingAlbum]
+
+    def __init__(self, portal: Portal) -> None:
+        self.portal = portal
+        self._pending = {}
+
+    @property
+    def window(self) -> float:
+        return self.portal.config["bridge.album_batch_window"]
+
+    async def send(
+        self,
+        sender_id: TelegramID,
+        client: MautrixTelegramClient,
+        media: TypeInputMedia,
+        caption: str | None,
+        entities: list[TypeMessageEntity] | None,
+        reply_to: TelegramID | None,
+        on_sent: Callable[[Message], Awaitable[None]],
+        send_as: TypeInputPeer | None = None,
+    ) -> Message:
+        pending = self._pending.get(sender_id)
+        if pending and (
+            pending.client != client
+            or pending.send_as != send_as
+            or (reply_to and reply_to != pending.reply_to)
+        ):
+            self._flush_now(sender_id)
+            pending = None
+        if not pending:
+            pending = self._pending[sender_id] = _PendingAlbum(client, reply_to, send_as)
+        fut = asyncio.get_running_loop().create_future()
+        pending.items.append((media, caption, entities))
+        pending.futures.append(fut)
+        pending.handlers.append(on_sent)
+        if len(pending.items) >= MAX_ALBUM_SIZE:
+            self._flush_now(sender_id)
+        else:
+            if pending.flush_task:
+                pending.flush_task.cancel()
+            pending.flush_task = asyncio.create_task(self._flush_later(sender_id, pending))
+        return await fut
+
+    def _flush_now(self, sender_id: TelegramID) -> None:
+        pending = self._pending.pop(sender_id)
+        if pending.flush_task:
+            pending.flush_task.cancel()
+        background_task.create(self._flush(sender_id, pending))
+
+    async def _flush_later(self, sender_id: TelegramID, pending: _PendingAlbum) -> None:
+        await asyncio.sleep(self.window)
+        if self._pending.get(sender_id) is pending:
+            del self._pending[sender_id]
+        pending.flush_task = None
+        await self._flush(sender_id, pending)
+
+    async def _flush(self, sender_id: TelegramID, pending: _PendingAlbum) -> None:
+        try:
+            async with self.portal.send_lock(sender_id):
+                if len(pending.items) == 1:
+                    media, caption, entities = pending.items[0]
+                    responses: list[Any] = [
+                        await pending.client.send_media(
+                            self.portal.peer,
+                            media,
+                            caption=caption,
+                            entities=entities,
+                            reply_to=pending.reply_to,
+                            send_as=pending.send_as,
+                        )
+                    ]
+                else:
+                    responses = await pending.client.send_album(
+                        self.portal.peer,
+                        pending.items,
+                        reply_to=pending.reply_to,
+                        send_as=pending.send_as,
+                    )
+                    self.portal.log.debug(f"Sent {len(responses)} media messages as an album")
+                for fut, on_sent, response in zip(pending.futures, pending.handlers, responses):
+                    try:
+                        await on_sent(response)
+                    except Exception as e:
+                        if not fut.done():
+                            fut.set_exception(e)
+                    else:
+                        if not fut.done():
+                            fut.set_result(response)
+                if len(responses) < len(pending.futures):
+                    raise RuntimeError("Telegram returned fewer messages than sent")
+        except Exception as e:
+            for fut in pending.futures:
+                if not fut.done():
+                    fut.set_exception(e)
@@ -46,6 +46,11 @@ from mautrix.types import EventID
 from .. import portal as po
 from ..types import TelegramID
 
+try:
+    from telethon.tl.types import MessageMediaToDo
+except ImportError:
+    MessageMediaToDo = None
+
 DedupMXID = Tuple[EventID, TelegramID]
 TypeMessage = Union[Message, MessageService, UpdateShortMessage, UpdateShortChatMessage]
 
@@ -59,6 +64,12 @@ media_content_table = {
     MessageMediaDice: lambda media: [media.value, media.emoticon],
     MessageMediaUnsupported: lambda media: ["unsupported media"],
 }
+if MessageMediaToDo is not None:
+    # Checking items is bridged as an edit, so the completions must be part of the hash
+    media_content_table[MessageMediaToDo] = lambda media: [
+        [item.id for item in media.todo.list],
+        sorted(item.id for item in media.completions or []),
+    ]
 
 
 class PortalDedup:
@@ -0,0 +1,197 @@
+# mautrix-telegram - A Matrix-Telegram puppeting bridge
+# Copyright (C) 2026 Tulir Asokan
+#
+# This program is free software: you can redistribute it and/or modify
+# it under the terms of the GNU Affero General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# This program is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU Affero General Public License for more details.
+#
+# You should have received a copy of the GNU Affero General Public License
+# along with this program.  If not, see <https://www.gnu.org/licenses/>.
+from __future__ import annotations
+
+from typing import Any
+import asyncio
+import logging
+import re
+
+from telethon.helpers import add_surrogate, del_surrogate
+from telethon.tl.types import (
+    Document,
+    DocumentAttributeSticker,
+    MessageEntityCustomEmoji,
+    TypeMessageEntity,
+)
+from telethon.tl.types.messages import StickerSet
+
+from mautrix.appservice import IntentAPI
+from mautrix.errors import MForbidden, MNotFound
+from mautrix.types import EventType, RoomID
+
+from .. import abstract_user as au, portal as po, util
+from ..config import Config
+from ..db import TelegramFile as DBTelegramFile
+
+RoomEmotes = EventType.find("im.ponies.room_emotes", EventType.Class.STATE)
+EMOTE_PACK_STATE_KEY = "fi.mau.telegram.custom_emojis"
+
+sticker_short_name_regex = re.compile(r"[A-Za-z0-9_]+")
+
+
+class PortalEmotePack:
+    """
+    Maintains an ``im.ponies.room_emotes`` state event in a portal room containing the Telegram
+    custom emojis that have been bridged to it, so that clients can render and reuse them.
+    """
+
+    _portal: po.Portal
+    _images: dict[str, dict[str, Any]] | None
+    _lock: asyncio.Lock
+
+    def __init__(self, portal: po.Portal) -> None:
+        self._portal = portal
+        self._images = None
+        self._lock = asyncio.Lock()
+
+    @staticmethod
+    def shortcode(document_id: int) -> str:
+        return f"tg_{document_id}"
+
+    async def _load(self) -> dict[str, dict[str, Any]]:
+        if self._images is None:
+            try:
+                evt = await self._portal.main_intent.get_state_event(
+                    self._portal.mxid, RoomEmotes, EMOTE_PACK_STATE_KEY
+                )
+                self._images = dict(evt.serialize().get("images") or {})
+            except MNotFound:
+                self._images = {}
+        return self._images
+
+    async def add(self, text: str, entities: list[TypeMessageEntity] | None) -> None:
+        emojis = {
+            entity.document_id: entity
+            for entity in entities or []
+            if isinstance(entity, MessageEntityCustomEmoji)
+        }
+        if not emojis or not self._portal.mxid:
+            return
+        async with self._lock:
+            images = await self._load()
+            new_ids = [doc_id for doc_id in emojis if self.shortcode(doc_id) not in images]
+            if not new_ids:
+                return
+            # Emojis that have a unicode equivalent aren't reuploaded, so they won't be found here
+            files = await DBTelegramFile.get_many([str(doc_id) for doc_id in new_ids])
+            if not files:
+                return
+            surrogated_text = add_surrogate(text)
+            for file in files:
+                entity = emojis[int(file.id)]
+                alt = del_surrogate(
+                    surrogated_text[entity.offset : entity.offset + entity.length]
+                )
+                info = {
+                    "mimetype": file.mime_type,
+                    "size": file.size,
+                    "w": file.width,
+                    "h": file.height,
+                }
+                images[self.shortcode(entity.document_id)] = {
+                    "url": file.mxc,
+                    "body": alt,
+                    "info": {key: value for key, value in info.items() if value is not None},
+                    "usage": ["emoticon"],
+                }
+            content = {
+                "images": images,
+                "pack": {
+                    "display_name": "Telegram custom emojis",
+                    "usage": ["emoticon"],
+                },
+            }
+            try:
+                await self._portal.main_intent.send_state_event(
+                    self._portal.mxid, RoomEmotes, content, state_key=EMOTE_PACK_STATE_KEY
+                )
+            except MForbidden as e:
+                self._portal.log.warning(f"Failed to update custom emoji pack: {e}")
+            else:
+                self._portal.log.debug(f"Added {len(files)} custom emojis to the room emote pack")
+
+
+async def import_sticker_pack(
+    source: au.AbstractUser,
+    intent: IntentAPI,
+    room_id: RoomID,
+    sticker_set: StickerSet,
+    config: Config,
+    log: logging.Logger,
+) -> int:
+    """
+    Transfer the stickers in a Telegram sticker set to Matrix and publish them as a sticker pack
+    state event in the given room. Returns the number of stickers that were imported, which is
+    zero if none of them could be transferred (in which case no state event is sent).
+    """
+    tgs_convert = config["bridge.animated_sticker"]
+    transfer_sema = asyncio.Semaphore(5)
+
+    async def transfer(document: Document) -> tuple[str, dict] | None:
+        async with transfer_sema:
+            file = await util.transfer_file_to_matrix(
+                source.client,
+                intent,
+                document,
+                is_sticker=True,
+                tgs_convert=tgs_convert,
+                webm_convert=tgs_convert["target"] if tgs_convert["convert_from_webm"] else None,
+                filename=f"sticker-{document.id}",
+                # Sticker packs are stored in room state, so they can't be encrypted
+                encrypt=False,
+                async_upload=config["homeserver.async_media"],
+            )
+        if not file:
+            return None
+        alt = ""
+        for attr in document.attributes:
+            if isinstance(attr, DocumentAttributeSticker):
+                alt = attr.alt
+        info = {"mimetype": file.mime_type, "size": file.size, "w": file.width, "h": file.height}
+        return PortalEmotePack.shortcode(document.id), {
+            "url": file.mxc,
+            "body": alt,
+            "info": {key: value for key, value in info.items() if value is not None},
+        }
+
+    results = await asyncio.gather(
+        *[transfer(doc) for doc in sticker_set.documents], return_exceptions=True
+    )
+    images = {}
+    for doc, result in zip(sticker_set.documents, results):
+        if isinstance(result, Exception):
+            log.warning(f"Failed to transfer sticker {doc.id}", exc_info=result)
+        elif result:
+            images[result[0]] = result[1]
+    if not images:
+        return 0
+    content = {
+        "images": images,
+        "pack": {
+            "display_name": sticker_set.set.title,
+            "usage": ["sticker"],
+            "fi.mau.telegram.short_name": sticker_set.set.short_name,
+        },
+    }
+    await intent.send_state_event(
+        room_id,
+        RoomEmotes,
+        content,
+        # Use the canonical short name from Telegram rather than what the user typed
+        state_key=f"fi.mau.telegram.{sticker_set.set.short_name}",
+    )
+    return len(images)
@@ -0,0 +1,66 @@
+# mautrix-telegram - A Matrix-Telegram puppeting bridge
+# Copyright (C) 2026 Tulir Asokan
+#
+# This program is free software: you can redistribute it and/or modify
+# it under the terms of the GNU Affero General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# This program is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU Affero General Public License for more details.
+#
+# You should have received a copy of the GNU Affero General Public License
+# along with this program.  If not, see <https://www.gnu.org/licenses/>.
+from __future__ import annotations
+
+from typing import Generic, Hashable, TypeVar
+import time
+
+K = TypeVar("K", bound=Hashable)
+
+
+class ExpiringTimestamps(Generic[K]):
+    """
+    Remembers when something last happened for each key and forgets keys older than ``ttl``.
+
+    The check-and-set methods never await, so concurrent tasks can't both pass the same check.
+    """
+
+    ttl: float
+    max_size: int
+    _times: dict[K, float]
+
+    def __init__(self, ttl: float, max_size: int = 100) -> None:
+        self.ttl = ttl
+        self.max_size = max_size
+        self._times = {}
+
+    def _prune(self, now: float) -> None:
+        if len(self._times) <= self.max_size:
+            return
+        self._times = {key: ts for key, ts in self._times.items() if ts + self.ttl > now}
+
+    def mark(self, key: K) -> None:
+        now = time.monotonic()
+        self._times[key] = now
+        self._prune(now)
+
+    def is_recent(self, key: K, now: float | None = None) -> bool:
+        ts = self._times.get(key)
+        if now is None:
+            now = time.monotonic()
+        return ts is not None and ts + self.ttl > now
+
+    def mark_if_expired(self, key: K) -> bool:
+        """Mark the key and return ``True``, unless it was already marked within the TTL."""
+        now = time.monotonic()
+        if self.is_recent(key, now):
+            return False
+        self._times[key] = now
+        self._prune(now)
+        return True
+
+    def clear(self) -> None:
+        self._times = {}
@@ -16,14 +16,19 @@
 from __future__ import annotations
 
 from typing import Any, NamedTuple
+from collections import Counter
 import base64
 import codecs
+import copy
 import hashlib
 import html
 import mimetypes
+import re
 import unicodedata
 
 from attr import dataclass
+from telethon.errors import RPCError
+from telethon.tl.functions.stories import GetStoriesByIDRequest
 from telethon.tl.types import (
     Document,
     DocumentAttributeAnimated,
@@ -33,9 +38,15 @@ from telethon.tl.types import (
     DocumentAttributeSticker,
     DocumentAttributeVideo,
     Game,
+    InputPeerChannelFromMessage,
+    InputPeerUserFromMessage,
     InputPhotoFileLocation,
     InputStickerSetID,
     InputStickerSetShortName,
+    KeyboardButtonSimpleWebView,
+    KeyboardButtonUrl,
+    KeyboardButtonUrlAuth,
+    KeyboardButtonWebView,
     Message,
     MessageEntityPre,
     MessageMediaContact,
@@ -52,6 +63,8 @@ from telethon.tl.types import (
     MessageMediaVenue,
     MessageMediaWebPage,
     MessageReplyStoryHeader,
+    MessageService,
+    MessageViews,
     PeerChannel,
     PeerChat,
     PeerUser,
@@ -62,18 +75,29 @@ from telethon.tl.types import (
     PhotoSizeEmpty,
     PhotoSizeProgressive,
     Poll,
+    ReactionCustomEmoji,
+    ReactionEmoji,
+    ReplyInlineMarkup,
+    ReplyKeyboardMarkup,
+    StoryItem,
+    StoryItemDeleted,
     TypeDocumentAttribute,
+    TypeInputPeer,
+    TypeKeyboardButton,
+    TypePeer,
     TypePhotoSize,
+    TypeReaction,
     UpdateShortChatMessage,
     UpdateShortMessage,
     WebPage,
 )
-from telethon.utils import decode_waveform
+from telethon.utils import decode_waveform, get_peer_id
 
 from mautrix.appservice import IntentAPI
 from mautrix.types import (
     EventID,
     EventType,
+    FileInfo,
     Format,
     ImageInfo,
     LocationMessageEventContent,
@@ -85,18 +109,37 @@ from mautrix.types import (
 )
 from mautrix.util.logging import TraceLogger
 
-from .. import abstract_user as au, formatter, matrix as m, portal as po, puppet as pu, util
+from .. import (
+    abstract_user as au,
+    formatter,
+    matrix as m,
+    portal as po,
+    puppet as pu,
+    user as u,
+    util,
+)
 from ..config import Config
 from ..db import Message as DBMessage, TelegramFile as DBTelegramFile
 from ..tgclient import MautrixTelegramClient
 from ..types import TelegramID
-from ..util import sane_mimetypes
+from ..util import sane_mimetypes, tracing
 
 try:
     import phonenumbers
 except ImportError:
     phonenumbers = None
 
+try:
+    from mautrix.crypto.attachments import encrypt_attachment
+except ImportError:
+    encrypt_attachment = None
+
+try:
+    # Checklists only exist in newer layers than the one the bridge currently uses
+    from telethon.tl.types import MessageMediaToDo
+except ImportError:
+    MessageMediaToDo = None
+
 
 @dataclass
 class ConvertedMessage:
@@ -105,6 +148,8 @@ class ConvertedMessage:
     type: EventType = EventType.ROOM_MESSAGE
     disappear_seconds: int | None = None
     disappear_start_immediately: bool = False
+    # The TTL of self-destructing media, counted by Telegram from when the media is opened
+    media_ttl: int | None = None
 
 
 class DocAttrs(NamedTuple):
@@ -120,9 +165,12 @@ class DocAttrs(NamedTuple):
     is_voice: bool
     duration: int
     waveform: bytes
+    is_round: bool = False
 
 
 BEEPER_LINK_PREVIEWS_KEY = "com.beeper.linkpreviews"
+# The ttl_seconds value Telegram uses for view-once media
+VIEW_ONCE_TTL = 2147483647
 BEEPER_IMAGE_ENCRYPTION_KEY = "beeper:image:encryption"
 
 
@@ -139,6 +187,7 @@ class TelegramMessageConverter:
         self.config = portal.config
         self.command_prefix = self.config["bridge.command_prefix"]
         self.log = portal.log.getChild("msg_conv")
+        self._suppressed_counts: Counter[str] = Counter()
 
         self._media_converters = {
             MessageMediaPhoto: self._convert_photo,
@@ -154,6 +203,8 @@ class TelegramMessageConverter:
             MessageMediaStory: self._convert_story,
             MessageMediaInvoice: self._convert_invoice,
         }
+        if MessageMediaToDo is not None:
+            self._media_converters[MessageMediaToDo] = self._convert_checklist
         self._allowed_media = tuple(self._media_converters.keys())
 
     async def convert(
@@ -166,10 +217,45 @@ class TelegramMessageConverter:
         no_reply_fallback: bool = False,
         deterministic_reply_id: bool = False,
         client: MautrixTelegramClient | None = None,
+        skip_media: bool = False,
+    ) -> ConvertedMessage | None:
+        media_type = type(evt.media).__name__ if getattr(evt, "media", None) else None
+        with tracing.span("telegram.convert_message", msg_id=evt.id, media_type=media_type):
+            return await self._convert(
+                source,
+                intent,
+                is_bot,
+                is_channel,
+                evt,
+                no_reply_fallback,
+                deterministic_reply_id,
+                client,
+                skip_media,
+            )
+
+    async def _convert(
+        self,
+        source: au.AbstractUser,
+        intent: IntentAPI,
+        is_bot: bool,
+        is_channel: bool,
+        evt: Message,
+        no_reply_fallback: bool,
+        deterministic_reply_id: bool,
+        client: MautrixTelegramClient | None,
+        skip_media: bool,
     ) -> ConvertedMessage | None:
         if not client:
             client = source.client
-        if hasattr(evt, "media") and isinstance(evt.media, self._allowed_media):
+        metadata_only = self.portal.get_config("metadata_only")
+        if metadata_only:
+            if not evt.message and not getattr(evt, "media", None):
+                self.log.debug("Unhandled Telegram message %d", evt.id)
+                return
+            converted = self._convert_metadata_only(evt)
+        elif skip_media and get_message_kind(evt) != "text":
+            converted = await self._convert_without_media(source, intent, is_bot, evt, client)
+        elif hasattr(evt, "media") and isinstance(evt.media, self._allowed_media):
             if self._should_convert_full_document(evt.media, is_bot, is_channel):
                 convert_media = self._media_converters[type(evt.media)]
                 converted = await convert_media(
@@ -187,26 +273,126 @@ class TelegramMessageConverter:
                 converted.disappear_seconds = evt.ttl_period
                 converted.disappear_start_immediately = True
             converted.content.external_url = self._get_external_url(evt)
-            converted.content["fi.mau.telegram.source"] = {
-                "space": self.portal.tgid if self.portal.peer_type == "channel" else source.tgid,
-                "chat_id": self.portal.tgid,
-                "peer_type": self.portal.peer_type,
-                "id": evt.id,
-            }
+            converted.content["fi.mau.telegram.source"] = self.get_source_metadata(source, evt)
+            if getattr(evt, "grouped_id", None):
+                # Albums are sent as separate messages that share a grouped_id
+                converted.content["fi.mau.telegram.grouped_id"] = str(evt.grouped_id)
+            if getattr(evt, "views", None) is not None:
+                # Only channel posts have view counts
+                converted.content["fi.mau.telegram.post_stats"] = get_post_stats(evt)
+            if not metadata_only:
+                await self._add_keyword_mentions(evt, converted)
+                await self._add_discussion_link(evt, converted)
+                await self._add_web_app_buttons(evt, converted)
+                self._add_inline_keyboard(source, evt, converted)
+                await self._add_saved_peer_profile(evt, converted)
+                await self._add_via_bot(source, evt, converted)
             if converted.caption:
                 converted.caption["fi.mau.telegram.source"] = converted.content[
                     "fi.mau.telegram.source"
                 ]
+                if getattr(evt, "grouped_id", None):
+                    converted.caption["fi.mau.telegram.grouped_id"] = str(evt.grouped_id)
                 converted.caption.external_url = converted.content.external_url
                 if self.portal.get_config("caption_in_message"):
                     self._caption_to_message(converted)
+            if is_bot:
+                self._tag_bot_message(converted)
             await self._set_reply(
                 source,
                 evt,
                 converted.content,
                 no_fallback=no_reply_fallback,
                 deterministic_id=deterministic_reply_id,
+                client=client,
+                ids_only=metadata_only,
             )
+            if converted.caption and "fi.mau.telegram.contact" in converted.caption:
+                # The text summary of contacts sent as vCard files is the part people read,
+                # so it should be a reply too.
+                await self._set_reply(
+                    source,
+                    evt,
+                    converted.caption,
+                    no_fallback=no_reply_fallback,
+                    deterministic_id=deterministic_reply_id,
+                    client=client,
+                )
+        return converted
+
+    def get_source_metadata(
+        self, source: au.AbstractUser, evt: Message | MessageService
+    ) -> dict[str, Any]:
+        """
+        Get the ``fi.mau.telegram.source`` block that is included in bridged events, so that
+        Matrix events can be correlated with Telegram messages without access to the bridge DB.
+        """
+        meta: dict[str, Any] = {
+            "space": self.portal.tgid if self.portal.peer_type == "channel" else source.tgid,
+            "chat_id": self.portal.tgid,
+            "peer_type": self.portal.peer_type,
+            "id": evt.id,
+        }
+        from_id, peer_id = getattr(evt, "from_id", None), getattr(evt, "peer_id", None)
+        if from_id:
+            meta["sender_id"] = pu.Puppet.get_id_from_peer(from_id)
+        elif isinstance(peer_id, PeerUser):
+            # Messages in private chats don't have from_id
+            meta["sender_id"] = source.tgid if evt.out else peer_id.user_id
+        elif peer_id:
+            # Posts in broadcast channels are sent by the channel itself
+            meta["sender_id"] = pu.Puppet.get_id_from_peer(peer_id)
+        if getattr(evt, "date", None):
+            meta["date"] = int(evt.date.timestamp())
+        if getattr(evt, "edit_date", None):
+            meta["edit_date"] = int(evt.edit_date.timestamp())
+        if getattr(evt, "via_bot_id", None):
+            meta["via_bot_id"] = evt.via_bot_id
+        fwd_from = getattr(evt, "fwd_from", None)
+        if fwd_from:
+            origin: dict[str, Any] = {"date": int(fwd_from.date.timestamp())}
+            if fwd_from.from_id:
+                origin["peer_id"] = pu.Puppet.get_id_from_peer(fwd_from.from_id)
+                origin["peer_type"] = _peer_type_name(fwd_from.from_id)
+            # Forwards from hidden users only have a name, which counts as content
+            if fwd_from.from_name and not self.portal.get_config("metadata_only"):
+                origin["name"] = fwd_from.from_name
+            if fwd_from.channel_post:
+                origin["message_id"] = fwd_from.channel_post
+            meta["forward_origin"] = origin
+        return meta
+
+    def _convert_metadata_only(self, evt: Message) -> ConvertedMessage:
+        kind = get_message_kind(evt)
+        self._suppressed_counts[kind] += 1
+        self.log.getChild("audit").info(
+            f"Suppressed content of {kind} message {evt.id} in metadata-only portal "
+            f"(suppressed so far: {dict(self._suppressed_counts)})"
+        )
+        content = TextMessageEventContent(
+            msgtype=MessageType.NOTICE,
+            body=f"The content of this {kind} message is not bridged in this room",
+        )
+        content["fi.mau.telegram.metadata_only"] = {"type": kind}
+        return ConvertedMessage(content=content)
+
+    async def _convert_without_media(
+        self,
+        source: au.AbstractUser,
+        intent: IntentAPI,
+        is_bot: bool,
+        evt: Message,
+        client: MautrixTelegramClient,
+    ) -> ConvertedMessage:
+        kind = get_message_kind(evt)
+        if evt.message:
+            converted = await self._convert_text(source, intent, is_bot, evt, client)
+        else:
+            content = TextMessageEventContent(
+                msgtype=MessageType.NOTICE, body=f"The {kind} in this message was not bridged"
+            )
+            converted = ConvertedMessage(content=content)
+        converted.content["fi.mau.telegram.media_skipped"] = {"type": kind}
         return converted
 
     def _should_convert_full_document(self, media, is_bot: bool, is_channel: bool) -> bool:
@@ -269,6 +455,253 @@ class TelegramMessageConverter:
         b64hash = base64.urlsafe_b64encode(hashed).decode("utf-8").rstrip("=")
         return EventID(f"${b64hash}:telegram.org")
 
+    async def _add_saved_peer_profile(self, evt: Message, converted: ConvertedMessage) -> None:
+        saved_peer = getattr(evt, "saved_peer_id", None)
+        if (
+            not saved_peer
+            or not self.portal.is_saved_messages
+            or (isinstance(saved_peer, PeerUser) and saved_peer.user_id == self.portal.tgid)
+        ):
+            return
+        peer_id = pu.Puppet.get_id_from_peer(saved_peer)
+        if isinstance(saved_peer, PeerChat):
+            chat = await po.Portal.get_by_tgid(peer_id)
+            displayname = chat.title if chat else None
+            avatar_url = chat.avatar_url if chat else None
+        else:
+            puppet = await pu.Puppet.get_by_peer(saved_peer)
+            displayname, avatar_url = puppet.displayname, puppet.avatar_url
+        # Messages in saved messages keep the original chat, so show it like a per-message
+        # profile (MSC4144) instead of making every message look like it's from the user.
+        profile = {"id": str(peer_id), "displayname": displayname or str(peer_id)}
+        if avatar_url:
+            profile["avatar_url"] = avatar_url
+        converted.content["com.beeper.per_message_profile"] = profile
+        converted.content["fi.mau.telegram.saved_peer_id"] = peer_id
+        if converted.caption:
+            converted.caption["com.beeper.per_message_profile"] = profile
+            converted.caption["fi.mau.telegram.saved_peer_id"] = peer_id
+
+    async def _get_via_bot_username(
+        self, source: au.AbstractUser, evt: Message, bot_id: int
+    ) -> str | None:
+        # The bot is usually included in the entities of the update
+        bot = getattr(evt, "via_bot", None)
+        if bot:
+            return bot.username
+        puppet = await pu.Puppet.get_by_tgid(TelegramID(bot_id), create=False)
+        if puppet and puppet.username:
+            return puppet.username
+        try:
+            bot = await source.client.get_entity(PeerUser(bot_id))
+        except (ValueError, RPCError) as e:
+            self.log.debug(f"Failed to get inline bot {bot_id} of {evt.id}: {e}")
+            return None
+        return getattr(bot, "username", None)
+
+    async def _add_via_bot(
+        self, source: au.AbstractUser, evt: Message, converted: ConvertedMessage
+    ) -> None:
+        bot_id = getattr(evt, "via_bot_id", None)
+        if not bot_id:
+            return
+        username = await self._get_via_bot_username(source, evt, bot_id)
+        via_bot = {"id": bot_id, "username": username}
+        converted.content["fi.mau.telegram.via_bot"] = via_bot
+        if converted.caption:
+            converted.caption["fi.mau.telegram.via_bot"] = via_bot
+        if not username:
+            return
+        target = converted.caption or converted.content
+        if isinstance(target, TextMessageEventContent):
+            target.ensure_has_html()
+            target.body += f"\n\nvia @{username}"
+            target.formatted_body += (
+                f"<br/><br/>via <a href='https://t.me/{username}'>@{username}</a>"
+            )
+        sender_id = converted.content["fi.mau.telegram.source"].get("sender_id")
+        if not sender_id:
+            return
+        elif "com.beeper.per_message_profile" in converted.content:
+            # Saved messages already override the profile with the original chat
+            return
+        # Inline results are often just media (e.g. @gif), so there's no text to add the
+        # attribution to. Show it in the sender name like Telegram clients do instead.
+        sender = await pu.Puppet.get_by_tgid(TelegramID(sender_id), create=False)
+        sender_name = sender.displayname if sender and sender.displayname else str(sender_id)
+        profile = {"id": str(sender_id), "displayname": f"{sender_name} via @{username}"}
+        if sender and sender.avatar_url:
+            profile["avatar_url"] = sender.avatar_url
+        converted.content["com.beeper.per_message_profile"] = profile
+        if converted.caption:
+            converted.caption["com.beeper.per_message_profile"] = profile
+
+    async def _add_discussion_link(self, evt: Message, converted: ConvertedMessage) -> None:
+        fwd_from = getattr(evt, "fwd_from", None)
+        replies = getattr(evt, "replies", None)
+        if (
+            self.portal.megagroup
+            and fwd_from
+            and isinstance(fwd_from.saved_from_peer, PeerChannel)
+            and fwd_from.saved_from_msg_id
+        ):
+            # Channel post that was automatically forwarded to the linked discussion group
+            channel_id = TelegramID(fwd_from.saved_from_peer.channel_id)
+            await self.portal.update_linked_chat(channel_id, save=True)
+            msg = await DBMessage.get_one_by_tgid(
+                TelegramID(fwd_from.saved_from_msg_id), channel_id
+            )
+            if not msg:
+                return
+            key = "fi.mau.telegram.channel_post"
+            meta = {
+                "room_id": msg.mx_room,
+                "event_id": msg.mxid,
+                "chat_id": channel_id,
+                "id": fwd_from.saved_from_msg_id,
+            }
+            url = f"https://matrix.to/#/{msg.mx_room}/{msg.mxid}"
+            link_text = "View original post"
+        elif not self.portal.megagroup and replies and replies.comments and replies.channel_id:
+            # Channel post with comments enabled, link to the discussion group portal
+            await self.portal.update_linked_chat(replies.channel_id, save=True)
+            discussion = await po.Portal.get_by_tgid(TelegramID(replies.channel_id))
+            if not discussion or not discussion.mxid:
+                return
+            await discussion.update_linked_chat(self.portal.tgid, save=True)
+            key = "fi.mau.telegram.discussion"
+            meta = {
+                "room_id": discussion.mxid,
+                "chat_id": discussion.tgid,
+                "comments": replies.replies,
+            }
+            url = f"https://matrix.to/#/{discussion.mxid}"
+            link_text = "Discuss"
+        else:
+            return
+
+        converted.content[key] = meta
+        target = converted.caption or converted.content
+        if not isinstance(target, TextMessageEventContent):
+            return
+        target[key] = meta
+        target.ensure_has_html()
+        target.body += f"\n\n{link_text}: {url}"
+        target.formatted_body += f"<br/><br/><a href='{html.escape(url)}'>{link_text}</a>"
+
+    async def _add_web_app_buttons(self, evt: Message, converted: ConvertedMessage) -> None:
+        markup = getattr(evt, "reply_markup", None)
+        if not isinstance(markup, (ReplyInlineMarkup, ReplyKeyboardMarkup)):
+            return
+        buttons = [
+            button
+            for row in markup.rows
+            for button in row.buttons
+            if isinstance(button, (KeyboardButtonWebView, KeyboardButtonSimpleWebView))
+        ]
+        if not buttons:
+            return
+        web_apps = [{"text": button.text, "url": button.url} for button in buttons]
+        converted.content["fi.mau.telegram.web_apps"] = web_apps
+        target = converted.caption or converted.content
+        if not isinstance(target, TextMessageEventContent):
+            return
+        target["fi.mau.telegram.web_apps"] = web_apps
+        target.ensure_has_html()
+        # Web apps opened in a browser don't get the Telegram user data, so also link to the bot
+        # in Telegram, where the startapp parameter opens its web app directly.
+        bot_id = evt.via_bot_id or evt.sender_id
+        bot = await pu.Puppet.get_by_tgid(TelegramID(bot_id), create=False) if bot_id else None
+        tme_url = f"https://t.me/{bot.username}?startapp" if bot and bot.username else None
+        for web_app in web_apps:
+            text, url = web_app["text"], web_app["url"]
+            target.body += f"\n\n\U0001F310 {text}: {url}"
+            target.formatted_body += (
+                f"<br/><br/>\U0001F310 <a href='{html.escape(url)}'>{html.escape(text)}</a>"
+            )
+            if tme_url:
+                target.body += f" (open in Telegram: {tme_url})"
+                target.formatted_body += f" (<a href='{tme_url}'>open in Telegram</a>)"
+
+    def _add_inline_keyboard(
+        self, source: au.AbstractUser, evt: Message, converted: ConvertedMessage
+    ) -> None:
+        buttons = get_inline_buttons(evt)
+        if not buttons:
+            return
+        click_id = self._encode_msgid(source, evt)
+        keyboard = []
+        for index, button in enumerate(buttons, start=1):
+            button_meta = {
+                "index": index,
+                "text": button.text,
+                "type": type(button).__name__.removeprefix("KeyboardButton").lower(),
+            }
+            if isinstance(button, (KeyboardButtonUrl, KeyboardButtonUrlAuth)):
+                button_meta["url"] = button.url
+            keyboard.append(button_meta)
+        meta = {"click_id": click_id, "buttons": keyboard}
+        converted.content["fi.mau.telegram.inline_keyboard"] = meta
+        target = converted.caption or converted.content
+        if not isinstance(target, TextMessageEventContent):
+            return
+        target["fi.mau.telegram.inline_keyboard"] = meta
+        target.ensure_has_html()
+        body_lines, html_items = [], []
+        for button in keyboard:
+            text = button["text"]
+            if "url" in button:
+                url = button["url"]
+                body_lines.append(f"{button['index']}. {text}: {url}")
+                html_items.append(f"<li><a href='{html.escape(url)}'>{html.escape(text)}</a></li>")
+            else:
+                body_lines.append(f"{button['index']}. {text}")
+                html_items.append(f"<li>{html.escape(text)}</li>")
+        command = f"{self.command_prefix} click {click_id} <number>"
+        target.body += "\n\n" + "\n".join(body_lines) + f"\n\nClick buttons with {command}"
+        target.formatted_body += (
+            f"<ol>{''.join(html_items)}</ol>"
+            f"Click buttons with <code>{html.escape(command)}</code>"
+        )
+
+    def _tag_bot_message(self, converted: ConvertedMessage) -> None:
+        silent = self.portal.get_config("silent_bot_messages")
+        for content in (converted.content, converted.caption):
+            if not content:
+                continue
+            content["fi.mau.telegram.bot_message"] = True
+            if silent:
+                # Notices are excluded from notifications by the default push rules, and an
+                # empty m.mentions disables the legacy display name and keyword mention rules.
+                if content.msgtype == MessageType.TEXT:
+                    content.msgtype = MessageType.NOTICE
+                content["m.mentions"] = {}
+
+    async def _add_keyword_mentions(self, evt: Message, converted: ConvertedMessage) -> None:
+        keywords = await self.portal.get_mention_keywords()
+        if not keywords:
+            return
+        target = converted.caption or converted.content
+        if not target.msgtype or not target.msgtype.is_text or not target.body:
+            return
+        mentioned = []
+        for user_id, user_keywords in keywords.items():
+            pattern = "|".join(re.escape(keyword) for keyword in user_keywords)
+            # Lookarounds instead of \b, so that keywords starting or ending with non-word
+            # characters (e.g. c++ or #tag) also match.
+            regex = rf"(?<!\w)(?:{pattern})(?!\w)"
+            if not pattern or not re.search(regex, target.body, re.IGNORECASE):
+                continue
+            user = await u.User.get_by_mxid(user_id, create=False)
+            if user and user.tgid and user.tgid == getattr(evt, "sender_id", None):
+                # Don't notify users about their own messages
+                continue
+            mentioned.append(user_id)
+        if mentioned:
+            mentions = target.get("m.mentions", {})
+            mentions["user_ids"] = [*mentions.get("user_ids", []), *mentioned]
+            target["m.mentions"] = mentions
+
     async def _set_reply(
         self,
         source: au.AbstractUser,
@@ -276,13 +709,27 @@ class TelegramMessageConverter:
         content: MessageEventContent,
         no_fallback: bool = False,
         deterministic_id: bool = False,
+        client: MautrixTelegramClient | None = None,
+        ids_only: bool = False,
     ) -> None:
+        """
+        Add the reply metadata of a Telegram message to the Matrix event. If ``ids_only`` is set,
+        quotes, story previews and reply fallbacks are left out and only the IDs are included.
+        """
         if not evt.reply_to:
             return
         elif isinstance(evt.reply_to, MessageReplyStoryHeader):
+            if ids_only:
+                content["fi.mau.telegram.story_reply"] = {
+                    "peer_id": pu.Puppet.get_id_from_peer(evt.reply_to.peer),
+                    "id": evt.reply_to.story_id,
+                }
+                return
+            # Stories aren't bridged as messages, so there's nothing in the room to reply to
+            await self._set_story_reply(evt.reply_to, content, client or source.client, evt)
             return
 
-        if evt.reply_to.quote and content.msgtype.is_text:
+        if evt.reply_to.quote and content.msgtype and content.msgtype.is_text and not ids_only:
             content.ensure_has_html()
             quote_html = await formatter.telegram_text_to_matrix_html(
                 source, evt.reply_to.quote_text, evt.reply_to.quote_entities
@@ -291,6 +738,12 @@ class TelegramMessageConverter:
                 f"<blockquote data-telegram-partial-reply>{quote_html}</blockquote>"
                 f"{content.formatted_body}"
             )
+            # The quote isn't added to the plaintext body, because clients strip lines starting
+            # with > as the legacy reply fallback.
+            content["fi.mau.telegram.quote"] = {
+                "text": evt.reply_to.quote_text,
+                "offset": evt.reply_to.quote_offset,
+            }
 
         space = (
             evt.peer_id.channel_id
@@ -316,7 +769,7 @@ class TelegramMessageConverter:
 
         reply_to_id = TelegramID(evt.reply_to.reply_to_msg_id)
         msg = await DBMessage.get_one_by_tgid(reply_to_id, space)
-        no_fallback = no_fallback or self.config["bridge.disable_reply_fallbacks"]
+        no_fallback = no_fallback or ids_only or self.config["bridge.disable_reply_fallbacks"]
         if not msg:
             # TODO try to find room ID when generating deterministic ID for cross-room reply
             if deterministic_id:
@@ -346,6 +799,87 @@ class TelegramMessageConverter:
         if msg.mx_room != self.portal.mxid:
             content.relates_to.in_reply_to["room_id"] = msg.mx_room
 
+    async def _set_story_reply(
+        self,
+        header: MessageReplyStoryHeader,
+        content: MessageEventContent,
+        client: MautrixTelegramClient,
+        evt: Message | None = None,
+    ) -> None:
+        story = await self._get_story(client, header.peer, header.story_id, evt)
+        story_meta = {"peer_id": pu.Puppet.get_id_from_peer(header.peer), "id": header.story_id}
+        if story:
+            self._add_story_reactions(story, story_meta)
+        sender = await pu.Puppet.get_by_peer(header.peer)
+        sender_name = sender.plain_displayname if sender and sender.displayname else "someone"
+        url = None
+        if sender and sender.username:
+            url = story_meta["url"] = f"https://t.me/{sender.username}/s/{header.story_id}"
+        if not story:
+            story_meta["expired"] = True
+        content["fi.mau.telegram.story_reply"] = story_meta
+        if not content.msgtype or not content.msgtype.is_text:
+            return
+
+        text = f"In reply to a story from {sender_name}"
+        if not story:
+            text += " (the story has expired or is unavailable)"
+        content.ensure_has_html()
+        quote_html = html.escape(text)
+        if url:
+            quote_html = f"<a href='{html.escape(url)}'>{quote_html}</a>"
+        # Like quote replies, the attribution is only added to the formatted body, because
+        # clients strip lines starting with > from the plaintext body as a reply fallback.
+        content.formatted_body = (
+            f"<blockquote data-telegram-story-reply>{quote_html}</blockquote>"
+            f"{content.formatted_body}"
+        )
+        if url and story:
+            try:
+                preview = await self._story_to_beeper_link_preview(client, story, url)
+            except Exception:
+                self.log.exception(f"Failed to transfer preview of story {header.story_id}")
+                preview = None
+            if preview:
+                content[BEEPER_LINK_PREVIEWS_KEY] = [
+                    *content.get(BEEPER_LINK_PREVIEWS_KEY, []),
+                    preview,
+                ]
+
+    async def _story_to_beeper_link_preview(
+        self, client: MautrixTelegramClient, story: StoryItem, url: str
+    ) -> dict[str, Any] | None:
+        if isinstance(story.media, MessageMediaPhoto):
+            loc, size = self.get_largest_photo_size(story.media.photo)
+        elif isinstance(story.media, MessageMediaDocument):
+            loc, size = self.get_largest_photo_size(story.media.document)
+        else:
+            return None
+        if not isinstance(size, (PhotoSize, PhotoCachedSize, PhotoSizeProgressive)):
+            return None
+        file = await util.transfer_file_to_matrix(
+            client,
+            self.portal.main_intent,
+            loc,
+            encrypt=self.portal.encrypted,
+            async_upload=self.config["homeserver.async_media"],
+        )
+        if not file:
+            return None
+        preview: dict[str, Any] = {
+            "matched_url": url,
+            "og:url": url,
+            "og:title": "Telegram story",
+            "og:description": story.caption or "",
+            "og:image:height": size.h,
+            "og:image:width": size.w,
+        }
+        if file.decryption_info:
+            preview[BEEPER_IMAGE_ENCRYPTION_KEY] = file.decryption_info.serialize()
+        else:
+            preview["og:image"] = file.mxc
+        return preview
+
     @staticmethod
     def _photo_size_key(photo: TypePhotoSize) -> int:
         if isinstance(photo, PhotoSize):
@@ -430,9 +964,14 @@ class TelegramMessageConverter:
             and isinstance(evt.media, MessageMediaWebPage)
             and isinstance(evt.media.webpage, WebPage)
         ):
-            content[BEEPER_LINK_PREVIEWS_KEY] = [
-                await self._webpage_to_beeper_link_preview(source, intent, evt.media.webpage)
-            ]
+            preview = await self._webpage_to_beeper_link_preview(source, intent, evt.media.webpage)
+            # Layout hints, so clients can render the preview like Telegram does
+            for flag in ("force_large_media", "force_small_media"):
+                if getattr(evt.media, flag, False):
+                    preview[f"fi.mau.telegram.{flag}"] = True
+            if getattr(evt, "invert_media", False):
+                preview["fi.mau.telegram.invert_media"] = True
+            content[BEEPER_LINK_PREVIEWS_KEY] = [preview]
 
         return ConvertedMessage(content=content)
 
@@ -494,15 +1033,16 @@ class TelegramMessageConverter:
             content=content,
             caption=caption_content,
             disappear_seconds=self._adjust_ttl(media.ttl_seconds),
+            media_ttl=media.ttl_seconds,
         )
 
     @staticmethod
-    def _adjust_ttl(ttl: int | None) -> int | None:
+    def _adjust_ttl(ttl: int | None, duration: int | None = None) -> int | None:
         if not ttl:
             return None
-        elif ttl == 2147483647:
-            # View-once media, set low TTL
-            return 15
+        elif ttl == VIEW_ONCE_TTL:
+            # View-once media, set low TTL, but leave enough time to listen to voice messages
+            return 15 + (duration or 0)
         else:
             # Increase media TTL because it's supposed to be counted from opening the media,
             # but we can only count it from read receipt.
@@ -591,6 +1131,7 @@ class TelegramMessageConverter:
             content=content,
             caption=caption_content,
             disappear_seconds=self._adjust_ttl(evt.media.ttl_seconds),
+            media_ttl=evt.media.ttl_seconds,
         )
 
     async def _convert_document(
@@ -623,6 +1164,9 @@ class TelegramMessageConverter:
             thumb_size = None
         parallel_id = source.tgid if self.config["bridge.parallel_file_transfer"] else None
         tgs_convert = self.config["bridge.animated_sticker"]
+        round_convert = self.config["bridge.round_video"]
+        if round_convert["target"] == "disable":
+            round_convert = None
         file = await util.transfer_file_to_matrix(
             client,
             intent,
@@ -631,6 +1175,7 @@ class TelegramMessageConverter:
             is_sticker=attrs.is_sticker,
             tgs_convert=tgs_convert,
             webm_convert=tgs_convert["target"] if tgs_convert["convert_from_webm"] else None,
+            round_convert=round_convert if attrs.is_round else None,
             filename=attrs.name,
             parallel_id=parallel_id,
             encrypt=self.portal.encrypted,
@@ -666,6 +1211,10 @@ class TelegramMessageConverter:
             info["fi.mau.autoplay"] = True
             info["fi.mau.hide_controls"] = True
             info["fi.mau.no_audio"] = True
+        if attrs.is_round:
+            info["fi.mau.telegram.round_message"] = True
+            # Hint for clients that the video should be rendered as a circle
+            info["fi.mau.shape"] = "circle"
         if evt.media.spoiler:
             info["fi.mau.telegram.spoiler"] = True
         if not name:
@@ -683,12 +1232,16 @@ class TelegramMessageConverter:
         )
         if event_type == EventType.STICKER:
             content.msgtype = None
+        if attrs.is_round:
+            content["fi.mau.telegram.round_message"] = True
         if attrs.is_audio:
             content["org.matrix.msc1767.audio"] = {"duration": attrs.duration * 1000}
             if attrs.waveform:
                 content["org.matrix.msc1767.audio"]["waveform"] = [x << 5 for x in attrs.waveform]
             if attrs.is_voice:
                 content["org.matrix.msc3245.voice"] = {}
+        if evt.media.ttl_seconds == VIEW_ONCE_TTL:
+            content["fi.mau.telegram.view_once"] = True
         if file.decryption_info:
             content.file = file.decryption_info
         else:
@@ -702,7 +1255,8 @@ class TelegramMessageConverter:
             type=event_type,
             content=content,
             caption=caption_content,
-            disappear_seconds=self._adjust_ttl(evt.media.ttl_seconds),
+            disappear_seconds=self._adjust_ttl(evt.media.ttl_seconds, attrs.duration),
+            media_ttl=evt.media.ttl_seconds,
         )
 
     @staticmethod
@@ -716,10 +1270,14 @@ class TelegramMessageConverter:
         body = f"{round(abs(lat), 4)}° {lat_char}, {round(abs(long), 4)}° {long_char}"
         url = f"https://maps.google.com/?q={geo}"
 
+        venue: MessageMediaVenue | None = None
         if isinstance(evt.media, MessageMediaGeoLive):
             note = "Live Location (see your Telegram client for live updates)"
         elif isinstance(evt.media, MessageMediaVenue):
-            note = evt.media.title
+            venue = evt.media
+            note = venue.title
+            if venue.address:
+                body = f"{venue.address} ({body})"
         else:
             note = "Location"
 
@@ -729,11 +1287,20 @@ class TelegramMessageConverter:
             body=f"{note}: {body}\n{url}",
         )
         content["format"] = str(Format.HTML)
-        content["formatted_body"] = f"{note}: <a href='{url}'>{body}</a>"
+        content["formatted_body"] = f"{html.escape(note)}: <a href='{url}'>{html.escape(body)}</a>"
         content["org.matrix.msc3488.location"] = {
             "uri": content.geo_uri,
             "description": note,
         }
+        if venue:
+            content["org.matrix.msc3488.asset"] = {"type": "m.pin"}
+            content["fi.mau.telegram.venue"] = {
+                "title": venue.title,
+                "address": venue.address,
+                "provider": venue.provider,
+                "venue_id": venue.venue_id,
+                "venue_type": venue.venue_type,
+            }
         return ConvertedMessage(content=content)
 
     @staticmethod
@@ -804,7 +1371,12 @@ class TelegramMessageConverter:
         return ConvertedMessage(content=content)
 
     async def _convert_game(
-        self, source: au.AbstractUser, evt: Message, client: MautrixTelegramClient, **_
+        self,
+        source: au.AbstractUser,
+        intent: IntentAPI,
+        evt: Message,
+        client: MautrixTelegramClient,
+        **_,
     ) -> ConvertedMessage:
         game: Game = evt.media.game
         play_id = self._encode_msgid(source, evt)
@@ -819,12 +1391,48 @@ class TelegramMessageConverter:
         )
         content.msgtype = MessageType.NOTICE
         content["fi.mau.telegram.game"] = play_id
+        bot_username = await self._get_bot_username(evt)
+        tme_url = self._get_external_url(evt)
+        if not tme_url and bot_username:
+            tme_url = f"https://t.me/{bot_username}?game={game.short_name}"
+        content["fi.mau.telegram.game_info"] = {
+            "title": game.title,
+            "description": game.description,
+            "short_name": game.short_name,
+            "bot_username": bot_username,
+            "telegram_url": tme_url,
+        }
+        if tme_url:
+            self._append_link(content, "Open in Telegram", tme_url)
+
+        media = None
+        if isinstance(game.document, Document):
+            media = MessageMediaDocument(document=game.document)
+        elif isinstance(game.photo, Photo):
+            media = MessageMediaPhoto(photo=game.photo)
+        if not media:
+            return ConvertedMessage(content=content)
+        game_evt = copy.copy(evt)
+        game_evt.media = media
+        game_evt.message = ""
+        game_evt.entities = []
+        if isinstance(media, MessageMediaPhoto):
+            converted = await self._convert_photo(source, intent, game_evt, client)
+        else:
+            converted = await self._convert_document(source, intent, game_evt, client)
+        if not converted or converted.type != EventType.ROOM_MESSAGE:
+            return ConvertedMessage(content=content)
+        converted.content["fi.mau.telegram.game"] = play_id
+        converted.caption = content
+        return converted
 
-        return ConvertedMessage(content=content)
-
-    @staticmethod
     async def _convert_contact(
-        source: au.AbstractUser, evt: Message, client: MautrixTelegramClient, **_
+        self,
+        source: au.AbstractUser,
+        intent: IntentAPI,
+        evt: Message,
+        client: MautrixTelegramClient,
+        **_,
     ) -> ConvertedMessage:
         contact: MessageMediaContact = evt.media
         name = " ".join(x for x in [contact.first_name, contact.last_name] if x)
@@ -862,34 +1470,341 @@ class TelegramMessageConverter:
                 f"<a href='https://matrix.to/#/{puppet.mxid}'>{html.escape(name)}</a>: "
                 f"{html.escape(formatted_phone)}"
             )
+        if self.config["bridge.contact_vcard_file"]:
+            vcard_content = await self._upload_vcard(intent, contact, name)
+            # The text summary is kept as a caption, so clients that can't import vCards
+            # still show who the contact is.
+            return ConvertedMessage(content=vcard_content, caption=content)
         return ConvertedMessage(content=content)
 
+    async def _upload_vcard(
+        self, intent: IntentAPI, contact: MessageMediaContact, name: str
+    ) -> MediaMessageEventContent:
+        vcard = contact.vcard
+        if not vcard:
+            tel = f"TEL;TYPE=CELL:+{contact.phone_number}\r\n" if contact.phone_number else ""
+            vcard = (
+                "BEGIN:VCARD\r\n"
+                "VERSION:3.0\r\n"
+                f"N:{_escape_vcard(contact.last_name)};{_escape_vcard(contact.first_name)};;;\r\n"
+                f"FN:{_escape_vcard(name)}\r\n"
+                f"{tel}"
+                "END:VCARD\r\n"
+            )
+        data = vcard.encode("utf-8")
+        file_name = f"{name or contact.phone_number}.vcf"
+        content = MediaMessageEventContent(
+            msgtype=MessageType.FILE,
+            body=file_name,
+            info=FileInfo(mimetype="text/vcard", size=len(data)),
+        )
+        content["fi.mau.telegram.contact"] = {
+            "user_id": contact.user_id,
+            "phone_number": contact.phone_number,
+        }
+        async_upload = self.config["homeserver.async_media"]
+        if self.portal.encrypted and encrypt_attachment:
+            data, content.file = encrypt_attachment(data)
+            content.file.url = await intent.upload_media(
+                data, "application/octet-stream", async_upload=async_upload
+            )
+        else:
+            content.url = await intent.upload_media(
+                data, "text/vcard", file_name, async_upload=async_upload
+            )
+        return content
+
+    @staticmethod
+    async def _get_story_peer(
+        client: MautrixTelegramClient, peer: TypePeer, evt: Message | None
+    ) -> TypeInputPeer:
+        try:
+            return await client.get_input_entity(peer)
+        except ValueError:
+            # Stories forwarded from chats we haven't seen don't have a cached access hash,
+            # but the peer can still be referenced through the message that contains it.
+            if not isinstance(evt, Message):
+                raise
+            chat_peer = await client.get_input_entity(evt.peer_id)
+            if isinstance(peer, PeerUser):
+                return InputPeerUserFromMessage(
+                    peer=chat_peer, msg_id=evt.id, user_id=peer.user_id
+                )
+            elif isinstance(peer, PeerChannel):
+                return InputPeerChannelFromMessage(
+                    peer=chat_peer, msg_id=evt.id, channel_id=peer.channel_id
+                )
+            raise
+
+    async def _get_story(
+        self,
+        client: MautrixTelegramClient,
+        peer: TypePeer,
+        story_id: int,
+        evt: Message | None = None,
+    ) -> StoryItem | None:
+        try:
+            input_peer = await self._get_story_peer(client, peer, evt)
+            resp = await client(GetStoriesByIDRequest(peer=input_peer, id=[story_id]))
+        except (ValueError, RPCError) as e:
+            self.log.warning(f"Failed to fetch story {story_id} from {peer}: {e}")
+            return None
+        return next(
+            (story for story in resp.stories if isinstance(story, StoryItem)),
+            None,
+        )
+
     @staticmethod
+    def _add_story_reactions(story: StoryItem, story_meta: dict[str, Any]) -> None:
+        def reaction_key(reaction: TypeReaction) -> str | None:
+            if isinstance(reaction, ReactionEmoji):
+                return reaction.emoticon
+            elif isinstance(reaction, ReactionCustomEmoji):
+                return str(reaction.document_id)
+            return None
+
+        if story.views and story.views.reactions:
+            story_meta["reactions"] = {
+                key: count.count
+                for count in story.views.reactions
+                if (key := reaction_key(count.reaction))
+            }
+        if story.sent_reaction:
+            story_meta["sent_reaction"] = reaction_key(story.sent_reaction)
+
     async def _convert_story(
-        source: au.AbstractUser, evt: Message, client: MautrixTelegramClient, **_
-    ) -> ConvertedMessage:
-        content = await formatter.telegram_to_matrix(
-            evt, source, client, override_text="Stories are not yet supported"
+        self,
+        source: au.AbstractUser,
+        intent: IntentAPI,
+        evt: Message,
+        client: MautrixTelegramClient,
+    ) -> ConvertedMessage | None:
+        media: MessageMediaStory = evt.media
+        if isinstance(media.story, StoryItem):
+            story = media.story
+        elif isinstance(media.story, StoryItemDeleted):
+            story = None
+        else:
+            story = await self._get_story(client, media.peer, media.id, evt)
+        story_meta = {"peer_id": pu.Puppet.get_id_from_peer(media.peer), "id": media.id}
+        if story:
+            self._add_story_reactions(story, story_meta)
+        sender = await pu.Puppet.get_by_peer(media.peer)
+        sender_name = sender.plain_displayname if sender and sender.displayname else "someone"
+        if media.via_mention:
+            header = "Mentioned you in a story"
+            story_meta["via_mention"] = True
+        elif getattr(evt, "fwd_from", None):
+            header = f"Forwarded a story from {sender_name}"
+        else:
+            header = f"Shared a story from {sender_name}"
+        if sender and sender.username:
+            story_meta["url"] = f"https://t.me/{sender.username}/s/{media.id}"
+
+        if not story or not isinstance(story.media, (MessageMediaPhoto, MessageMediaDocument)):
+            text = f"{header} (the story has expired or is unavailable)"
+            content = await formatter.telegram_to_matrix(evt, source, client, override_text=text)
+            content.msgtype = MessageType.NOTICE
+            content["fi.mau.telegram.story"] = story_meta
+            return ConvertedMessage(content=content)
+
+        story_evt = copy.copy(evt)
+        story_evt.media = story.media
+        story_evt.message = story.caption or ""
+        story_evt.entities = story.entities
+        story_evt.fwd_from = None
+        if isinstance(story.media, MessageMediaPhoto):
+            converted = await self._convert_photo(source, intent, story_evt, client)
+        else:
+            converted = await self._convert_document(source, intent, story_evt, client)
+        if not converted:
+            return None
+        converted.content["fi.mau.telegram.story"] = story_meta
+        if converted.caption:
+            converted.caption.body = f"{header}: {converted.caption.body}"
+            if converted.caption.formatted_body:
+                converted.caption.formatted_body = (
+                    f"{html.escape(header)}: {converted.caption.formatted_body}"
+                )
+        else:
+            converted.caption = TextMessageEventContent(msgtype=MessageType.NOTICE, body=header)
+        return converted
+
+    @staticmethod
+    async def _convert_checklist(evt: Message, **_) -> ConvertedMessage:
+        todo = evt.media.todo
+        completions = {
+            item.id: getattr(item, "completed_by", None) for item in evt.media.completions or []
+        }
+        title = todo.title.text
+        text_items = []
+        html_items = []
+        items = []
+        for item in todo.list:
+            done = item.id in completions
+            checkbox = "\u2611\ufe0f" if done else "\u2610"
+            text_items.append(f"{checkbox} {item.title.text}")
+            html_items.append(f"<li>{checkbox} {html.escape(item.title.text)}</li>")
+            completed_by = completions.get(item.id)
+            if completed_by is not None and not isinstance(completed_by, int):
+                completed_by = get_peer_id(completed_by)
+            items.append(
+                {
+                    "id": item.id,
+                    "title": item.title.text,
+                    "completed": done,
+                    "completed_by": completed_by,
+                }
+            )
+        text_list = "\n".join(text_items)
+        html_list = "".join(html_items)
+        content = TextMessageEventContent(
+            msgtype=MessageType.TEXT,
+            format=Format.HTML,
+            body=f"Checklist: {title}\n{text_list}",
+            formatted_body=f"<strong>{html.escape(title)}</strong><ul>{html_list}</ul>",
         )
-        content.msgtype = MessageType.NOTICE
-        content["fi.mau.telegram.unsupported"] = True
+        content["fi.mau.telegram.checklist"] = {
+            "title": title,
+            "items": items,
+            "others_can_append": bool(todo.others_can_append),
+            "others_can_complete": bool(todo.others_can_complete),
+        }
         return ConvertedMessage(content=content)
 
-    @staticmethod
     async def _convert_invoice(
-        source: au.AbstractUser, evt: Message, client: MautrixTelegramClient, **_
+        self, source: au.AbstractUser, evt: Message, client: MautrixTelegramClient, **_
     ) -> ConvertedMessage:
-        content = await formatter.telegram_to_matrix(
-            evt, source, client, override_text="Invoices are not yet supported"
-        )
+        invoice: MessageMediaInvoice = evt.media
+        amount = format_amount(invoice.total_amount, invoice.currency)
+        text = f"Invoice: {invoice.title} ({amount})"
+        if invoice.description:
+            text += f"\n{invoice.description}"
+        content = await formatter.telegram_to_matrix(evt, source, client, override_text=text)
         content.msgtype = MessageType.NOTICE
-        content["fi.mau.telegram.unsupported"] = True
+        bot_username = await self._get_bot_username(evt)
+        tme_url = self._get_external_url(evt)
+        if not tme_url and bot_username:
+            tme_url = f"https://t.me/{bot_username}"
+            if invoice.start_param:
+                tme_url += f"?start={invoice.start_param}"
+        content["fi.mau.telegram.invoice"] = {
+            "title": invoice.title,
+            "description": invoice.description,
+            "currency": invoice.currency,
+            "total_amount": invoice.total_amount,
+            "start_param": invoice.start_param,
+            "receipt_msg_id": invoice.receipt_msg_id,
+            "test": bool(invoice.test),
+            "bot_username": bot_username,
+            "telegram_url": tme_url,
+        }
+        if tme_url:
+            self._append_link(content, "Pay in Telegram", tme_url)
         return ConvertedMessage(content=content)
 
+    async def _get_bot_username(self, evt: Message) -> str | None:
+        bot_id = getattr(evt, "via_bot_id", None)
+        if not bot_id and isinstance(evt.from_id, PeerUser):
+            bot_id = evt.from_id.user_id
+        elif not bot_id and isinstance(evt.peer_id, PeerUser):
+            # Private chats don't have from_id, but the bot must be the other side
+            bot_id = evt.peer_id.user_id
+        if not bot_id:
+            return None
+        puppet = await pu.Puppet.get_by_tgid(TelegramID(bot_id), create=False)
+        return puppet.username if puppet and puppet.is_bot else None
+
+    @staticmethod
+    def _append_link(content: TextMessageEventContent, text: str, url: str) -> None:
+        content.ensure_has_html()
+        content.body += f"\n\n{text}: {url}"
+        content.formatted_body += f"<br/><br/><a href='{html.escape(url)}'>{text}</a>"
+
+
+# Currencies whose amounts aren't in hundredths of the main unit, see
+# https://core.telegram.org/bots/payments/currencies.json
+_ZERO_DECIMAL_CURRENCIES = frozenset(
+    "BIF CLP DJF GNF ISK JPY KMF KRW PYG RWF UGX VND VUV XAF XOF XPF XTR".split()
+)
+_THREE_DECIMAL_CURRENCIES = frozenset("BHD IQD JOD KWD LYD OMR TND".split())
+
+
+def format_amount(amount: int, currency: str) -> str:
+    """Format an amount in the smallest units of a currency, like Telegram payments use."""
+    if currency in _ZERO_DECIMAL_CURRENCIES:
+        return f"{amount} {currency}"
+    exponent = 3 if currency in _THREE_DECIMAL_CURRENCIES else 2
+    return f"{amount / 10 ** exponent:.{exponent}f} {currency}"
+
+
+def get_post_stats(stats: Message | MessageViews) -> dict[str, int]:
+    """Get the view, forward and comment counts of a channel post."""
+    replies = stats.replies.replies if stats.replies else 0
+    return {"views": stats.views or 0, "forwards": stats.forwards or 0, "replies": replies}
+
+
+def get_inline_buttons(evt: Message) -> list[TypeKeyboardButton]:
+    """
+    Get the inline keyboard buttons of a message in the order they're numbered in the bridged
+    message. Web app buttons are left out, as they're bridged separately.
+    """
+    markup = getattr(evt, "reply_markup", None)
+    if not isinstance(markup, ReplyInlineMarkup):
+        return []
+    return [
+        button
+        for row in markup.rows
+        for button in row.buttons
+        if not isinstance(button, (KeyboardButtonWebView, KeyboardButtonSimpleWebView))
+    ]
+
+
+def _escape_vcard(value: str | None) -> str:
+    """Escape a vCard text value as described in RFC 6350 section 3.4."""
+    if not value:
+        return ""
+    for char in ("\\", ",", ";"):
+        value = value.replace(char, f"\\{char}")
+    return value.replace("\r\n", "\\n").replace("\n", "\\n").replace("\r", "\\n")
+
+
+def _peer_type_name(peer: TypePeer) -> str:
+    if isinstance(peer, PeerChannel):
+        return "channel"
+    elif isinstance(peer, PeerChat):
+        return "chat"
+    return "user"
+
+
+def get_message_kind(evt: Message) -> str:
+    media = getattr(evt, "media", None)
+    if not media or isinstance(media, MessageMediaWebPage):
+        return "text"
+    elif isinstance(media, MessageMediaPhoto):
+        return "photo"
+    elif not isinstance(media, MessageMediaDocument) or not media.document:
+        return type(media).__name__.removeprefix("MessageMedia").lower() or "unknown"
+    attrs = _parse_document_attributes(media.document.attributes)
+    if attrs.is_sticker:
+        return "sticker"
+    elif attrs.is_gif:
+        return "gif"
+    elif attrs.is_round:
+        return "round video"
+    elif attrs.is_voice:
+        return "voice"
+    elif attrs.is_audio:
+        return "audio"
+    elif media.document.mime_type.startswith("video/"):
+        return "video"
+    return "file"
+
 
 def _parse_document_attributes(attributes: list[TypeDocumentAttribute]) -> DocAttrs:
     name, mime_type, is_sticker, sticker_alt, width, height = None, None, False, None, 0, 0
     is_gif, is_audio, is_voice, duration, waveform = False, False, False, 0, bytes()
+    is_round = False
     sticker_pack_ref = None
     for attr in attributes:
         if isinstance(attr, DocumentAttributeFilename):
@@ -909,6 +1824,7 @@ def _parse_document_attributes(attributes: list[TypeDocumentAttribute]) -> DocAt
             is_gif = True
         elif isinstance(attr, DocumentAttributeVideo):
             width, height = attr.w, attr.h
+            is_round = attr.round_message or False
         elif isinstance(attr, DocumentAttributeImageSize):
             width, height = attr.w, attr.h
         elif isinstance(attr, DocumentAttributeAudio):
@@ -930,6 +1846,7 @@ def _parse_document_attributes(attributes: list[TypeDocumentAttribute]) -> DocAt
         is_voice=is_voice,
         duration=duration,
         waveform=waveform,
+        is_round=is_round,
     )
 
 
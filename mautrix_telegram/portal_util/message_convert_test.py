@@ -0,0 +1,81 @@
+# mautrix-telegram - A Matrix-Telegram puppeting bridge
+# Copyright (C) 2026 Tulir Asokan
+#
+# This program is free software: you can redistribute it and/or modify
+# it under the terms of the GNU Affero General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# This program is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU Affero General Public License for more details.
+#
+# You should have received a copy of the GNU Affero General Public License
+# along with this program.  If not, see <https://www.gnu.org/licenses/>.
+from __future__ import annotations
+
+from unittest.mock import AsyncMock, MagicMock, patch
+
+from telethon.tl.types import Message, PeerUser
+import pytest
+
+from mautrix.types import MediaMessageEventContent, MessageType, TextMessageEventContent
+
+from .. import user as u
+from .message_convert import ConvertedMessage, TelegramMessageConverter, format_amount
+
+
+def make_converter(keywords: dict[str, list[str]]) -> TelegramMessageConverter:
+    converter = TelegramMessageConverter.__new__(TelegramMessageConverter)
+    converter.portal = MagicMock()
+    converter.portal.get_mention_keywords = AsyncMock(return_value=keywords)
+    return converter
+
+
+def make_message() -> Message:
+    return Message(id=1, peer_id=PeerUser(user_id=1), date=None, message="")
+
+
+@pytest.mark.asyncio
+async def test_keyword_mentions_text() -> None:
+    converter = make_converter({"@alice:example.com": ["deploy"]})
+    content = TextMessageEventContent(msgtype=MessageType.TEXT, body="Time to deploy!")
+    with patch.object(u.User, "get_by_mxid", AsyncMock(return_value=None)):
+        await converter._add_keyword_mentions(make_message(), ConvertedMessage(content=content))
+    assert content.get("m.mentions")["user_ids"] == ["@alice:example.com"]
+
+
+@pytest.mark.asyncio
+async def test_keyword_mentions_no_match() -> None:
+    converter = make_converter({"@alice:example.com": ["deploy"]})
+    content = TextMessageEventContent(msgtype=MessageType.TEXT, body="redeployment")
+    with patch.object(u.User, "get_by_mxid", AsyncMock(return_value=None)):
+        await converter._add_keyword_mentions(make_message(), ConvertedMessage(content=content))
+    assert not content.get("m.mentions")
+
+
+@pytest.mark.asyncio
+async def test_keyword_mentions_sticker() -> None:
+    converter = make_converter({"@alice:example.com": ["sticker"]})
+    # Stickers are sent as m.sticker events, which have no msgtype
+    content = MediaMessageEventContent(msgtype=MessageType.IMAGE, body="sticker.webp")
+    content.msgtype = None
+    await converter._add_keyword_mentions(make_message(), ConvertedMessage(content=content))
+    assert not content.get("m.mentions")
+
+
+@pytest.mark.parametrize(
+    "amount,currency,expected",
+    [
+        (1250, "EUR", "12.50 EUR"),
+        (5, "USD", "0.05 USD"),
+        (0, "GBP", "0.00 GBP"),
+        (1234, "KWD", "1.234 KWD"),
+        (500, "JPY", "500 JPY"),
+        (50, "XTR", "50 XTR"),
+        (199, "ABC", "1.99 ABC"),
+    ],
+)
+def test_format_amount(amount: int, currency: str, expected: str) -> None:
+    assert format_amount(amount, currency) == expected
@@ -0,0 +1,98 @@
+# mautrix-telegram - A Matrix-Telegram puppeting bridge
+# Copyright (C) 2026 Tulir Asokan
+#
+# This program is free software: you can redistribute it and/or modify
+# it under the terms of the GNU Affero General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# This program is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU Affero General Public License for more details.
+#
+# You should have received a copy of the GNU Affero General Public License
+# along with this program.  If not, see <https://www.gnu.org/licenses/>.
+from __future__ import annotations
+
+from fnmatch import fnmatchcase
+import logging
+import time
+
+from attr import dataclass
+
+from mautrix.appservice import IntentAPI
+from mautrix.errors import MatrixRequestError
+from mautrix.types import RoomID, UserID
+
+# The stable MSC2313 type and the older ones that are still used by some policy lists
+USER_RULE_TYPES = ("m.policy.rule.user", "m.room.rule.user", "org.matrix.mjolnir.rule.user")
+BAN_RECOMMENDATIONS = ("m.ban", "org.matrix.mjolnir.ban")
+
+
+@dataclass
+class PolicyRule:
+    room_id: RoomID
+    entity: str
+    reason: str
+
+    def matches(self, user_id: UserID) -> bool:
+        return fnmatchcase(user_id, self.entity)
+
+
+class PolicyListCache:
+    """
+    Caches the user ban rules of MSC2313 moderation policy list rooms.
+
+    Lists are refetched after ``ttl`` seconds, or earlier if a rule change is seen in the room.
+    """
+
+    log: logging.Logger = logging.getLogger("mau.policy_list")
+    ttl: float
+    _rules: dict[RoomID, tuple[float, list[PolicyRule]]]
+
+    def __init__(self, ttl: float = 5 * 60) -> None:
+        self.ttl = ttl
+        self._rules = {}
+
+    def invalidate(self, room_id: RoomID) -> None:
+        # Only mark the rules as stale, so that they're still used if refetching fails
+        try:
+            _, rules = self._rules[room_id]
+        except KeyError:
+            return
+        self._rules[room_id] = (float("-inf"), rules)
+
+    async def get_rules(self, intent: IntentAPI, room_id: RoomID) -> list[PolicyRule]:
+        try:
+            fetched_at, rules = self._rules[room_id]
+            if fetched_at + self.ttl > time.monotonic():
+                return rules
+        except KeyError:
+            pass
+        try:
+            state = await intent.get_state(room_id)
+        except MatrixRequestError as e:
+            self.log.warning(f"Failed to fetch policy list {room_id}: {e}")
+            # Keep using the previous rules rather than letting spam through
+            return self._rules.get(room_id, (0, []))[1]
+        rules = []
+        for evt in state:
+            if evt.type.t not in USER_RULE_TYPES:
+                continue
+            content = evt.content.serialize()
+            entity = content.get("entity")
+            if not entity or content.get("recommendation") not in BAN_RECOMMENDATIONS:
+                continue
+            rules.append(PolicyRule(room_id, entity, content.get("reason") or ""))
+        self._rules[room_id] = (time.monotonic(), rules)
+        return rules
+
+    async def match(
+        self, intent: IntentAPI, room_ids: list[RoomID], *user_ids: UserID
+    ) -> PolicyRule | None:
+        for room_id in room_ids:
+            for rule in await self.get_rules(intent, room_id):
+                if any(rule.matches(user_id) for user_id in user_ids if user_id):
+                    return rule
+        return None
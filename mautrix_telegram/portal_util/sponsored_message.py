@@ -37,7 +37,11 @@ async def get_sponsored_message(
         return None, None, None
     assert isinstance(resp, SponsoredMessages)
     msg = resp.messages[0]
-    if isinstance(msg.from_id, PeerUser):
+    from_id = getattr(msg, "from_id", None)
+    if from_id is None:
+        # Newer layers include the sponsor info in the message itself
+        return msg, None, None
+    elif isinstance(msg.from_id, PeerUser):
         entities = resp.users
         target_id = msg.from_id.user_id
     else:
@@ -51,14 +55,26 @@ async def get_sponsored_message(
 
 
 async def make_sponsored_message_content(
-    source: u.User, msg: SponsoredMessage, entity: Channel | User
+    source: u.User, msg: SponsoredMessage, entity: Channel | User | None
 ) -> TextMessageEventContent | None:
     content = await telegram_to_matrix(msg, source, require_html=True)
-    content.external_url = f"https://t.me/{entity.username}"
     content.msgtype = MessageType.NOTICE
     sponsored_meta = {
         "random_id": base64.b64encode(msg.random_id).decode("utf-8"),
     }
+    if getattr(msg, "url", None):
+        content.external_url = msg.url
+        sponsored_meta["url"] = msg.url
+        sponsor_name = msg.title or "unknown sponsor"
+        sponsor_name_html = f"<strong>{html.escape(sponsor_name)}</strong>"
+        action = msg.button_text or "Open"
+        return _add_sponsor_info(content, sponsored_meta, sponsor_name, sponsor_name_html, action)
+
+    username = getattr(entity, "username", None)
+    if not username:
+        # The old format links to the sponsor by username, so there's nothing to link to
+        return None
+    content.external_url = f"https://t.me/{username}"
     if isinstance(msg.from_id, PeerChannel):
         sponsored_meta["channel_id"] = msg.from_id.channel_id
         if getattr(msg, "channel_post", None) is not None:
@@ -85,6 +101,16 @@ async def make_sponsored_message_content(
     else:
         sponsor_name = sponsor_name_html = "unknown entity"
 
+    return _add_sponsor_info(content, sponsored_meta, sponsor_name, sponsor_name_html, action)
+
+
+def _add_sponsor_info(
+    content: TextMessageEventContent,
+    sponsored_meta: dict,
+    sponsor_name: str,
+    sponsor_name_html: str,
+    action: str,
+) -> TextMessageEventContent:
     content["fi.mau.telegram.sponsored"] = sponsored_meta
     content.formatted_body += (
         f"<br/><br/>Sponsored message from {sponsor_name_html} "
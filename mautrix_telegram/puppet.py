@@ -17,9 +17,12 @@ from __future__ import annotations
 
 from typing import TYPE_CHECKING, AsyncGenerator, AsyncIterable, Awaitable, cast
 from difflib import SequenceMatcher
+import asyncio
+import time
 import unicodedata
 
 from telethon import utils
+from telethon.tl.functions.users import GetUsersRequest
 from telethon.tl.types import (
     Channel,
     ChatPhoto,
@@ -42,6 +45,7 @@ from yarl import URL
 from mautrix.appservice import IntentAPI
 from mautrix.bridge import BasePuppet, async_getter_lock
 from mautrix.types import ContentURI, RoomID, SyncToken, UserID
+from mautrix.util import background_task
 from mautrix.util.simple_template import SimpleTemplate
 
 from . import abstract_user as au, portal as p, util
@@ -53,6 +57,10 @@ from .types import TelegramID
 if TYPE_CHECKING:
     from .__main__ import TelegramBridge
 
+# Maximum number of ghosts waiting for a stale info refresh. Ghosts seen while the queue is full
+# are simply refreshed the next time they're seen.
+MAX_REFRESH_QUEUE_SIZE = 1000
+
 
 class Puppet(DBPuppet, BasePuppet):
     bridge: TelegramBridge
@@ -64,6 +72,10 @@ class Puppet(DBPuppet, BasePuppet):
     by_tgid: dict[TelegramID, Puppet] = {}
     by_custom_mxid: dict[UserID, Puppet] = {}
 
+    _refresh_queue: asyncio.Queue[tuple[Puppet, au.AbstractUser]]
+    _refresh_queued: set[TelegramID] = set()
+    _refresh_task: asyncio.Task | None = None
+
     def __init__(
         self,
         id: TelegramID,
@@ -87,6 +99,8 @@ class Puppet(DBPuppet, BasePuppet):
         access_token: str | None = None,
         next_batch: SyncToken | None = None,
         base_url: str | None = None,
+        is_deleted: bool = False,
+        info_refreshed_at: int = 0,
     ) -> None:
         super().__init__(
             id=id,
@@ -110,6 +124,8 @@ class Puppet(DBPuppet, BasePuppet):
             access_token=access_token,
             next_batch=next_batch,
             base_url=base_url,
+            is_deleted=is_deleted,
+            info_refreshed_at=info_refreshed_at,
         )
 
         self.default_mxid = self.get_mxid_from_id(self.id)
@@ -155,6 +171,50 @@ class Puppet(DBPuppet, BasePuppet):
             return self.default_mxid_intent
         return self.intent
 
+    async def migrate_ghost(self, old_mxid: UserID, portal: p.Portal) -> None:
+        """
+        Move the membership and power level of a ghost user with an old MXID (i.e. one created
+        with a previous username template) in the given portal room to the current ghost user.
+        If moving the power level fails, the new ghost is removed from the room again.
+        """
+        room_id = portal.mxid
+        old_intent = self.az.intent.user(old_mxid)
+        # In private chats, the old ghost is the room creator and the only one with power
+        admin_intent = old_intent if portal.is_direct else portal.main_intent
+        invited = False
+        if self.default_mxid not in await old_intent.get_joined_members(room_id):
+            await admin_intent.invite_user(room_id, self.default_mxid)
+            invited = True
+        try:
+            if invited:
+                await self.default_mxid_intent.ensure_joined(room_id)
+            levels = await admin_intent.get_power_levels(room_id)
+            old_level = levels.users.pop(old_mxid, None)
+            if old_level is not None:
+                levels.users[self.default_mxid] = old_level
+                await admin_intent.set_power_levels(room_id, levels)
+        except Exception:
+            if invited:
+                try:
+                    await admin_intent.kick_user(
+                        room_id, self.default_mxid, "Ghost migration failed"
+                    )
+                except Exception:
+                    self.log.warning(
+                        f"Failed to undo migration of {old_mxid} in {room_id}", exc_info=True
+                    )
+            raise
+        await old_intent.leave_room(room_id)
+
+    async def copy_profile_to_ghost(self) -> None:
+        if self.displayname:
+            await self.default_mxid_intent.set_displayname(self.displayname)
+            self.name_set = True
+        if self.avatar_url:
+            await self.default_mxid_intent.set_avatar_url(self.avatar_url)
+            self.avatar_set = True
+        await self.save()
+
     @classmethod
     def init_cls(cls, bridge: "TelegramBridge") -> AsyncIterable[Awaitable[None]]:
         cls.bridge = bridge
@@ -185,6 +245,7 @@ class Puppet(DBPuppet, BasePuppet):
             for server, secret in cls.config["bridge.login_shared_secret_map"].items()
         }
         cls.login_device_name = "Telegram Bridge"
+        cls._refresh_queue = asyncio.Queue(maxsize=MAX_REFRESH_QUEUE_SIZE)
 
         return (puppet.try_start() async for puppet in cls.all_with_custom_mxid())
 
@@ -242,7 +303,7 @@ class Puppet(DBPuppet, BasePuppet):
             quality -= 1
 
         if isinstance(info, User) and info.deleted:
-            name = f"Deleted account {info.id}"
+            name = "Deleted Account"
             quality = 99
         elif not name:
             name = str(info.id)
@@ -275,6 +336,16 @@ class Puppet(DBPuppet, BasePuppet):
         if is_premium is not None:
             self.is_premium = is_premium
 
+        if isinstance(info, User) and info.deleted:
+            if not self.is_deleted:
+                await self._clear_deleted_info(source)
+                changed = True
+        elif self.is_deleted:
+            self.log.debug("User is no longer marked as deleted")
+            self.is_deleted = False
+            self.displayname_quality = 0
+            changed = True
+
         if self.username != info.username and (info.username or not info.min):
             self.log.debug(f"Updating username {self.username} -> {info.username}")
             self.username = info.username
@@ -284,7 +355,7 @@ class Puppet(DBPuppet, BasePuppet):
             self.phone = info.phone
             changed = True
 
-        if not self.disable_updates:
+        if not self.disable_updates and not self.is_deleted:
             try:
                 changed = await self._update_contact_info(force=changed) or changed
 
@@ -301,10 +372,81 @@ class Puppet(DBPuppet, BasePuppet):
             except Exception:
                 self.log.exception(f"Failed to update info from source {source.tgid}")
 
+        if isinstance(info, User) and not info.min:
+            now = int(time.time())
+            # Only persist the timestamp occasionally to avoid a write for every update
+            if now - self.info_refreshed_at > 24 * 60 * 60:
+                changed = True
+            self.info_refreshed_at = now
+
         if changed:
             await self.update_portals_meta()
             await self.save()
 
+    def schedule_info_refresh(self, source: au.AbstractUser) -> None:
+        """Queue a background refresh of the ghost's info if it hasn't been updated recently."""
+        max_age = self.config["bridge.stale_ghost_refresh.max_age"] * 24 * 60 * 60
+        if (
+            not max_age
+            or self.is_channel
+            or self.is_deleted
+            or self.disable_updates
+            or self.tgid in self._refresh_queued
+            or time.time() - self.info_refreshed_at < max_age
+        ):
+            return
+        try:
+            self._refresh_queue.put_nowait((self, source))
+        except asyncio.QueueFull:
+            return
+        self._refresh_queued.add(self.tgid)
+        if not self._refresh_task or self._refresh_task.done():
+            Puppet._refresh_task = background_task.create(self._refresh_loop())
+
+    @classmethod
+    async def _refresh_loop(cls) -> None:
+        interval = cls.config["bridge.stale_ghost_refresh.interval"]
+        while True:
+            puppet, source = await cls._refresh_queue.get()
+            try:
+                if not await source.is_logged_in():
+                    continue
+                input_user = await source.client.get_input_entity(PeerUser(puppet.tgid))
+                users = await source.client(GetUsersRequest(id=[input_user]))
+                if users and isinstance(users[0], User):
+                    puppet.log.debug(f"Refreshing stale ghost info through {source.tgid}")
+                    await puppet.update_info(source, users[0])
+            except Exception as e:
+                puppet.log.warning(f"Failed to refresh stale ghost info: {e}")
+            finally:
+                cls._refresh_queued.discard(puppet.tgid)
+            await asyncio.sleep(interval)
+
+    async def _clear_deleted_info(self, source: au.AbstractUser) -> None:
+        self.log.info(f"User deleted their account (src: {source.tgid}), clearing ghost info")
+        self.is_deleted = True
+        self.username = None
+        self.phone = None
+        self.photo_id = ""
+        self.avatar_url = None
+        self.displayname = self.displayname_template.format_full("Deleted Account")
+        self.displayname_source = source.tgid
+        self.displayname_contact = False
+        self.displayname_quality = 99
+        try:
+            await self.default_mxid_intent.set_displayname(self.displayname)
+            self.name_set = True
+        except Exception as e:
+            self.log.warning(f"Failed to set displayname: {e}")
+            self.name_set = False
+        try:
+            await self.default_mxid_intent.set_avatar_url("")
+            self.avatar_set = True
+        except Exception as e:
+            self.log.warning(f"Failed to remove avatar: {e}")
+            self.avatar_set = False
+        await self._update_contact_info(force=True)
+
     async def _update_contact_info(self, force: bool = False) -> bool:
         if not self.bridge.homeserver_software.is_hungry:
             return False
@@ -346,7 +488,7 @@ class Puppet(DBPuppet, BasePuppet):
         info: User | Channel | UpdateUserName,
         client_override: MautrixTelegramClient | None = None,
     ) -> bool:
-        if self.disable_updates:
+        if self.disable_updates or self.is_deleted:
             return False
         if (
             self.displayname
@@ -418,7 +560,7 @@ class Puppet(DBPuppet, BasePuppet):
         entity: User | None = None,
         client_override: MautrixTelegramClient | None = None,
     ) -> bool:
-        if self.disable_updates:
+        if self.disable_updates or self.is_deleted:
             return False
         if (
             isinstance(photo, UserProfilePhoto)
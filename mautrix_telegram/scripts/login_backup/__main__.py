@@ -0,0 +1,105 @@
+# mautrix-telegram - A Matrix-Telegram puppeting bridge
+# Copyright (C) 2026 Tulir Asokan
+#
+# This program is free software: you can redistribute it and/or modify
+# it under the terms of the GNU Affero General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# This program is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU Affero General Public License for more details.
+#
+# You should have received a copy of the GNU Affero General Public License
+# along with this program.  If not, see <https://www.gnu.org/licenses/>.
+from pathlib import Path
+import argparse
+import asyncio
+import getpass
+import os
+import sys
+
+from mautrix.util.async_db import Database
+from mautrix.util.config import yaml
+
+from mautrix_telegram import db
+from mautrix_telegram.util.login_backup import (
+    LoginBackupError,
+    decrypt_backup,
+    encrypt_backup,
+    export_logins,
+    import_logins,
+)
+
+parser = argparse.ArgumentParser(
+    description="Export or import mautrix-telegram logins for disaster recovery",
+    epilog="The bridge should be stopped while importing.",
+)
+parser.add_argument(
+    "-c",
+    "--config",
+    type=str,
+    default="config.yaml",
+    metavar="<path>",
+    help="the bridge config file, used to find the database",
+)
+parser.add_argument(
+    "--keep-rooms",
+    action="store_true",
+    help="keep management room and space IDs when importing (only if the homeserver survived)",
+)
+parser.add_argument("action", choices=("export", "import"), help="what to do")
+parser.add_argument("file", type=str, metavar="<backup file>", help="the encrypted backup file")
+args = parser.parse_args()
+
+
+def get_passphrase(confirm: bool) -> str:
+    passphrase = os.environ.get("MAUTRIX_TELEGRAM_BACKUP_PASSPHRASE")
+    if passphrase:
+        return passphrase
+    passphrase = getpass.getpass("Backup passphrase: ")
+    if confirm and getpass.getpass("Repeat passphrase: ") != passphrase:
+        print("Passphrases don't match")
+        sys.exit(1)
+    elif not passphrase:
+        print("The passphrase can't be empty")
+        sys.exit(1)
+    return passphrase
+
+
+async def main() -> None:
+    with open(args.config) as f:
+        config = yaml.load(f)
+    database = Database.create(
+        config["appservice"]["database"],
+        upgrade_table=db.upgrade_table,
+        db_args=config["appservice"].get("database_opts"),
+    )
+    # Starting the database also creates the tables when importing into a fresh database
+    await database.start()
+    db.init(database)
+    try:
+        if args.action == "export":
+            data = await export_logins()
+            encrypted = encrypt_backup(data, get_passphrase(confirm=True))
+            # Create the file as private from the start instead of restricting it afterwards
+            fd = os.open(args.file, os.O_CREAT | os.O_WRONLY | os.O_TRUNC, 0o600)
+            with os.fdopen(fd, "wb") as file:
+                # The mode only applies to new files, so also fix the mode of existing files
+                os.fchmod(fd, 0o600)
+                file.write(encrypted)
+            print(f"Exported {len(data['logins'])} logins to {args.file}")
+        else:
+            data = decrypt_backup(Path(args.file).read_bytes(), get_passphrase(confirm=False))
+            imported = await import_logins(data, keep_rooms=args.keep_rooms)
+            skipped = len(data["logins"]) - len(imported)
+            print(f"Imported {len(imported)} logins, skipped {skipped} that already had one")
+    except LoginBackupError as e:
+        print(e)
+        sys.exit(1)
+    finally:
+        await database.stop()
+
+
+asyncio.run(main())
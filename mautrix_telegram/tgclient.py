@@ -13,28 +13,65 @@
 #
 # You should have received a copy of the GNU Affero General Public License
 # along with this program.  If not, see <https://www.gnu.org/licenses/>.
-from typing import List, Optional, Union
+from typing import List, NamedTuple, Optional, Tuple, Union
 
 from telethon import TelegramClient, utils
+from telethon.errors import TimedOutError
 from telethon.sessions.abstract import Session
-from telethon.tl.functions.messages import SendMediaRequest
+from telethon.tl.functions.messages import (
+    ForwardMessagesRequest,
+    SendMediaRequest,
+    SendMessageRequest,
+    SendMultiMediaRequest,
+    UploadMediaRequest,
+)
 from telethon.tl.patched import Message
 from telethon.tl.types import (
     InputMediaUploadedDocument,
     InputMediaUploadedPhoto,
+    InputMediaWebPage,
     InputReplyToMessage,
+    InputSingleMedia,
     TypeDocumentAttribute,
     TypeInputMedia,
     TypeInputPeer,
     TypeMessageEntity,
     TypeMessageMedia,
     TypePeer,
+    UpdateShortSentMessage,
+)
+
+# Requests that create new messages. If one of these times out, Telegram may have still
+# delivered the message, so the request must not be blindly retried. Other errors, like
+# internal server errors, mean the message definitely wasn't sent.
+SEND_REQUESTS = (
+    ForwardMessagesRequest,
+    SendMediaRequest,
+    SendMessageRequest,
+    SendMultiMediaRequest,
 )
 
 
+class ReplyQuote(NamedTuple):
+    """A part of the replied-to message that a reply quotes."""
+
+    text: str
+    entities: List[TypeMessageEntity]
+    offset: int
+
+
 class MautrixTelegramClient(TelegramClient):
     session: Session
 
+    async def __call__(self, request, *args, **kwargs):
+        try:
+            return await super().__call__(request, *args, **kwargs)
+        except TimedOutError as e:
+            if isinstance(request, SEND_REQUESTS):
+                # Mark the error so that callers know the message may have been sent anyway
+                e.maybe_sent = True
+            raise
+
     async def upload_file_direct(
         self,
         file: bytes,
@@ -64,6 +101,7 @@ class MautrixTelegramClient(TelegramClient):
         caption: str = None,
         entities: List[TypeMessageEntity] = None,
         reply_to: int = None,
+        send_as: Optional[TypeInputPeer] = None,
     ) -> Optional[Message]:
         entity = await self.get_input_entity(entity)
         reply_to = utils.get_message_id(reply_to)
@@ -73,5 +111,125 @@ class MautrixTelegramClient(TelegramClient):
             message=caption or "",
             entities=entities or [],
             reply_to=InputReplyToMessage(reply_to_msg_id=reply_to) if reply_to else None,
+            send_as=send_as,
         )
         return self._get_response_message(request, await self(request), entity)
+
+    async def send_text(
+        self,
+        entity: Union[TypeInputPeer, TypePeer],
+        message: str,
+        entities: List[TypeMessageEntity] = None,
+        reply_to: int = None,
+        link_preview: bool = True,
+        send_as: Optional[TypeInputPeer] = None,
+        invert_media: bool = False,
+        web_page: Optional[InputMediaWebPage] = None,
+        quote: Optional[ReplyQuote] = None,
+        schedule_date: Optional[int] = None,
+    ) -> Optional[Message]:
+        """
+        Like :meth:`send_message`, but allows choosing the identity to send as, the layout of
+        the link preview and quoting a part of the replied-to message. If ``web_page`` is set,
+        the preview is sent as media. If ``schedule_date`` is set, the message is scheduled
+        instead of being sent immediately.
+        """
+        entity = await self.get_input_entity(entity)
+        reply_to = utils.get_message_id(reply_to)
+        if reply_to and quote:
+            reply_to = InputReplyToMessage(
+                reply_to_msg_id=reply_to,
+                quote_text=quote.text,
+                quote_entities=quote.entities or None,
+                quote_offset=quote.offset,
+            )
+        elif reply_to:
+            reply_to = InputReplyToMessage(reply_to_msg_id=reply_to)
+        else:
+            reply_to = None
+        if web_page and link_preview:
+            request = SendMediaRequest(
+                entity,
+                web_page,
+                message,
+                entities=entities or [],
+                reply_to=reply_to,
+                send_as=send_as,
+                invert_media=invert_media,
+                schedule_date=schedule_date,
+            )
+        else:
+            request = SendMessageRequest(
+                entity,
+                message,
+                entities=entities or [],
+                no_webpage=not link_preview,
+                reply_to=reply_to,
+                send_as=send_as,
+                invert_media=invert_media,
+                schedule_date=schedule_date,
+            )
+        result = await self(request)
+        if isinstance(result, UpdateShortSentMessage):
+            msg = Message(
+                id=result.id,
+                peer_id=await self._get_peer(entity),
+                message=message,
+                date=result.date,
+                out=result.out,
+                media=result.media,
+                entities=result.entities,
+                reply_markup=None,
+                ttl_period=result.ttl_period,
+            )
+            msg._finish_init(self, {}, entity)
+            return msg
+        return self._get_response_message(request, result, entity)
+
+    async def send_album(
+        self,
+        entity: Union[TypeInputPeer, TypePeer],
+        media: List[Tuple[TypeInputMedia, Optional[str], Optional[List[TypeMessageEntity]]]],
+        reply_to: int = None,
+        send_as: Optional[TypeInputPeer] = None,
+    ) -> List[Optional[Message]]:
+        entity = await self.get_input_entity(entity)
+        reply_to = utils.get_message_id(reply_to)
+        multi_media = []
+        for item, caption, entities in media:
+            if isinstance(item, (InputMediaUploadedPhoto, InputMediaUploadedDocument)):
+                # Albums can only contain media that has already been uploaded to the chat
+                uploaded = await self(UploadMediaRequest(entity, media=item))
+                item = utils.get_input_media(uploaded)
+            multi_media.append(
+                InputSingleMedia(
+                    media=item,
+                    message=caption or "",
+                    entities=entities or [],
+                )
+            )
+        request = SendMultiMediaRequest(
+            entity,
+            multi_media=multi_media,
+            reply_to=InputReplyToMessage(reply_to_msg_id=reply_to) if reply_to else None,
+            send_as=send_as,
+        )
+        random_ids = [single.random_id for single in multi_media]
+        return self._get_response_message(random_ids, await self(request), entity)
+
+    async def forward_as(
+        self,
+        entity: Union[TypeInputPeer, TypePeer],
+        message_ids: List[int],
+        from_peer: Union[TypeInputPeer, TypePeer],
+        send_as: Optional[TypeInputPeer] = None,
+    ) -> List[Optional[Message]]:
+        """Like :meth:`forward_messages`, but allows choosing the identity to send as."""
+        entity = await self.get_input_entity(entity)
+        request = ForwardMessagesRequest(
+            from_peer=await self.get_input_entity(from_peer),
+            id=message_ids,
+            to_peer=entity,
+            send_as=send_as,
+        )
+        return self._get_response_message(request.random_id, await self(request), entity)
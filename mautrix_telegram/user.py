@@ -16,14 +16,16 @@
 from __future__ import annotations
 
 from typing import TYPE_CHECKING, Any, AsyncGenerator, AsyncIterable, Awaitable, NamedTuple, cast
-from datetime import datetime
+from datetime import datetime, timezone
 import asyncio
 import time
 
+from telethon.crypto import AuthKey
 from telethon.errors import (
     AuthKeyDuplicatedError,
     AuthKeyError,
     AuthKeyNotFound,
+    FloodWaitError,
     RPCError,
     TakeoutInitDelayError,
     UnauthorizedError,
@@ -32,7 +34,10 @@ from telethon.tl.custom import Dialog
 from telethon.tl.functions.account import UpdateStatusRequest
 from telethon.tl.functions.contacts import GetContactsRequest, SearchRequest
 from telethon.tl.functions.help import GetAppConfigRequest
-from telethon.tl.functions.messages import GetAvailableReactionsRequest
+from telethon.tl.functions.messages import (
+    GetAvailableReactionsRequest,
+    GetSavedReactionTagsRequest,
+)
 from telethon.tl.functions.updates import GetStateRequest
 from telethon.tl.functions.users import GetUsersRequest
 from telethon.tl.types import (
@@ -45,27 +50,39 @@ from telethon.tl.types import (
     MessageService,
     NotifyPeer,
     PeerUser,
+    ReactionCustomEmoji,
+    ReactionEmoji,
     TypeUpdate,
     UpdateFolderPeers,
     UpdateNewChannelMessage,
     UpdateNewMessage,
     UpdateNotifySettings,
     UpdatePinnedDialogs,
+    UpdateSavedReactionTags,
     UpdateShortChatMessage,
     UpdateShortMessage,
     User as TLUser,
 )
 from telethon.tl.types.contacts import ContactsNotModified
 from telethon.tl.types.help import AppConfig
-from telethon.tl.types.messages import AvailableReactions
+from telethon.tl.types.messages import AvailableReactions, SavedReactionTags
 
 from mautrix.appservice import DOUBLE_PUPPET_SOURCE_KEY
 from mautrix.bridge import BaseUser, async_getter_lock
 from mautrix.client import Client
 from mautrix.errors import MatrixRequestError, MNotFound
-from mautrix.types import PushActionType, PushRuleKind, PushRuleScope, RoomID, RoomTagInfo, UserID
+from mautrix.types import (
+    EventType,
+    PushActionType,
+    PushRuleKind,
+    PushRuleScope,
+    RoomID,
+    RoomTagInfo,
+    UserID,
+)
 from mautrix.util import background_task
 from mautrix.util.bridge_state import BridgeState, BridgeStateEvent
+from mautrix.util.format_duration import format_duration
 from mautrix.util.opt_prometheus import Gauge
 
 from . import portal as po, puppet as pu, util
@@ -73,11 +90,20 @@ from .abstract_user import AbstractUser
 from .db import Backfill, BackfillType, Message as DBMessage, PgSession, User as DBUser
 from .tgclient import MautrixTelegramClient
 from .types import TelegramID
+from .util.session_import import ImportedSession, SessionImportError
+
+try:
+    from telethon.tl.functions.contacts import GetBirthdaysRequest
+except ImportError:
+    GetBirthdaysRequest = None
 
 if TYPE_CHECKING:
     from .__main__ import TelegramBridge
 
 SearchResult = NamedTuple("SearchResult", puppet="pu.Puppet", similarity=int)
+SavedReactionTagsAccountData = EventType.find(
+    "fi.mau.telegram.saved_reaction_tags", EventType.Class.ACCOUNT_DATA
+)
 
 METRIC_LOGGED_IN = Gauge("bridge_logged_in", "Users logged into bridge")
 METRIC_CONNECTED = Gauge("bridge_connected", "Users connected to Telegram")
@@ -88,6 +114,9 @@ BridgeState.human_readable_errors.update(
         "tg-auth-key-duplicated": "The bridge accidentally logged you out",
         "tg-not-authenticated": "The stored auth token did not work",
         "tg-no-auth": "You're not logged in",
+        "tg-server-outage": (
+            "Telegram is having server issues, messages will be sent once they're resolved"
+        ),
     }
 )
 
@@ -97,14 +126,26 @@ class User(DBUser, AbstractUser, BaseUser):
     by_tgid: dict[int, User] = {}
 
     _portals_cache: dict[tuple[TelegramID, TelegramID], po.Portal] | None
+    _send_as_cache: dict[tuple[TelegramID, TelegramID], int | None]
 
     _ensure_started_lock: asyncio.Lock
     _track_connection_task: asyncio.Task | None
     _backfill_task: asyncio.Task | None
+    _power_level_resync_task: asyncio.Task | None
+    _chat_resync_task: asyncio.Task | None
+    _post_stats_task: asyncio.Task | None
+    _birthday_task: asyncio.Task | None
+    _sync_dialogs_lock: asyncio.Lock
+    _space_lock: asyncio.Lock
+    _space_children: set[RoomID] | None
     wakeup_backfill_task: asyncio.Event
     _is_backfilling: bool
     takeout_retry_immediate: asyncio.Event
     takeout_requested: bool
+    takeout_state: str
+    _takeout_started_at: float | None
+    _takeout_done_requests: int
+    _takeout_done_chats: set[tuple[int, int]]
 
     _available_emoji_reactions: set[str] | None
     _available_emoji_reactions_hash: int | None
@@ -122,6 +163,9 @@ class User(DBUser, AbstractUser, BaseUser):
         is_bot: bool = False,
         is_premium: bool = False,
         saved_contacts: int = 0,
+        notice_room: RoomID | None = None,
+        device_name: str | None = None,
+        space_room: RoomID | None = None,
     ) -> None:
         super().__init__(
             mxid=mxid,
@@ -131,6 +175,9 @@ class User(DBUser, AbstractUser, BaseUser):
             is_bot=is_bot,
             is_premium=is_premium,
             saved_contacts=saved_contacts,
+            notice_room=notice_room,
+            device_name=device_name,
+            space_room=space_room,
         )
         AbstractUser.__init__(self)
         BaseUser.__init__(self)
@@ -138,11 +185,23 @@ class User(DBUser, AbstractUser, BaseUser):
         self._track_connection_task = None
         self._is_backfilling = False
         self._portals_cache = None
+        self._send_as_cache = {}
 
         self._backfill_task = None
+        self._power_level_resync_task = None
+        self._chat_resync_task = None
+        self._post_stats_task = None
+        self._birthday_task = None
+        self._sync_dialogs_lock = asyncio.Lock()
+        self._space_lock = asyncio.Lock()
+        self._space_children = None
         self.wakeup_backfill_task = asyncio.Event()
         self.takeout_retry_immediate = asyncio.Event()
         self.takeout_requested = False
+        self.takeout_state = "idle"
+        self._takeout_started_at = None
+        self._takeout_done_requests = 0
+        self._takeout_done_chats = set()
 
         self._available_emoji_reactions = None
         self._available_emoji_reactions_hash = None
@@ -296,7 +355,13 @@ class User(DBUser, AbstractUser, BaseUser):
             await asyncio.sleep(3)
             connected = self._is_connected
             self._track_metric(METRIC_CONNECTED, connected)
-            if connected:
+            if connected and (outage := util.server_outages.remaining(self.client.session.dc_id)):
+                await self.push_bridge_state(
+                    BridgeStateEvent.TRANSIENT_DISCONNECT,
+                    error="tg-server-outage",
+                    info={"retry_in": int(outage)},
+                )
+            elif connected:
                 await self.push_bridge_state(
                     (
                         BridgeStateEvent.BACKFILLING
@@ -350,6 +415,18 @@ class User(DBUser, AbstractUser, BaseUser):
         if self._backfill_task:
             self._backfill_task.cancel()
             self._backfill_task = None
+        if self._power_level_resync_task:
+            self._power_level_resync_task.cancel()
+            self._power_level_resync_task = None
+        if self._chat_resync_task:
+            self._chat_resync_task.cancel()
+            self._chat_resync_task = None
+        if self._post_stats_task:
+            self._post_stats_task.cancel()
+            self._post_stats_task = None
+        if self._birthday_task:
+            self._birthday_task.cancel()
+            self._birthday_task = None
         await super().stop()
         self._track_metric(METRIC_CONNECTED, False)
 
@@ -366,8 +443,40 @@ class User(DBUser, AbstractUser, BaseUser):
             return
 
         self._track_metric(METRIC_LOGGED_IN, True)
+        if (
+            first_login
+            and not self.is_bot
+            and not self.notice_room
+            and self.config["bridge.create_notice_room"]
+        ):
+            await self._create_notice_room()
         if not self._backfill_task or self._backfill_task.done():
             self._backfill_task = asyncio.create_task(self._try_handle_backfill_requests_loop())
+        if (
+            not self.is_bot
+            and self.config["bridge.power_level_resync_interval"] > 0
+            and (not self._power_level_resync_task or self._power_level_resync_task.done())
+        ):
+            self._power_level_resync_task = asyncio.create_task(self._power_level_resync_loop())
+        if (
+            not self.is_bot
+            and self.config["bridge.chat_resync_interval"] > 0
+            and (not self._chat_resync_task or self._chat_resync_task.done())
+        ):
+            self._chat_resync_task = asyncio.create_task(self._chat_resync_loop())
+        if (
+            not self.is_bot
+            and self.config["bridge.post_stats.refresh_interval"] > 0
+            and (not self._post_stats_task or self._post_stats_task.done())
+        ):
+            self._post_stats_task = asyncio.create_task(self._post_stats_refresh_loop())
+        if (
+            not self.is_bot
+            and self.config["bridge.birthday_notices"]
+            and GetBirthdaysRequest is not None
+            and (not self._birthday_task or self._birthday_task.done())
+        ):
+            self._birthday_task = asyncio.create_task(self._birthday_notice_loop())
 
         try:
             puppet = await pu.Puppet.get_by_tgid(self.tgid)
@@ -382,10 +491,18 @@ class User(DBUser, AbstractUser, BaseUser):
                 self._is_backfilling = True
                 await self.sync_dialogs()
                 await self.sync_contacts()
+                await self.sync_saved_reaction_tags()
             except Exception:
                 self.log.exception("Failed to run post-login sync")
             finally:
                 self._is_backfilling = False
+        if not self.is_bot and not first_login and self.config["bridge.consistency_check.enabled"]:
+            try:
+                await self.check_portal_consistency()
+            except Exception:
+                self.log.exception("Failed to check portal consistency")
+        if not self.is_bot and self.config["bridge.personal_filtering_spaces"]:
+            background_task.create(self._add_all_portals_to_space())
 
     @property
     def _takeout_options(self) -> dict[str, bool | int]:
@@ -415,6 +532,12 @@ class User(DBUser, AbstractUser, BaseUser):
                 except asyncio.TimeoutError:
                     pass
                 self.wakeup_backfill_task.clear()
+            elif not self.config["bridge.backfill.use_takeout"]:
+                try:
+                    await self._backfill_loop_with_client(self.client, req)
+                except Exception:
+                    self.log.exception("Error in backfill loop, retrying in an hour")
+                    await asyncio.sleep(3600)
             else:
                 try:
                     await self._takeout_and_backfill(req)
@@ -422,6 +545,74 @@ class User(DBUser, AbstractUser, BaseUser):
                     self.log.exception("Error in takeout backfill loop, retrying in an hour")
                     await asyncio.sleep(3600)
 
+    async def _power_level_resync_loop(self) -> None:
+        interval = self.config["bridge.power_level_resync_interval"] * 60 * 60
+        while True:
+            await asyncio.sleep(interval)
+            portals = await self.get_cached_portals()
+            for portal in list(portals.values()):
+                if time.monotonic() - portal._power_levels_checked_at < interval:
+                    continue
+                try:
+                    await portal.resync_power_levels(self)
+                except Exception:
+                    portal.log.exception("Failed to check power levels for drift")
+                await asyncio.sleep(1)
+
+    async def _post_stats_refresh_loop(self) -> None:
+        interval = self.config["bridge.post_stats.refresh_interval"] * 60
+        limit = self.config["bridge.post_stats.recent_posts"]
+        while True:
+            await asyncio.sleep(interval)
+            portals = await self.get_cached_portals()
+            for portal in list(portals.values()):
+                if portal.peer_type != "channel" or portal.megagroup or not portal.mxid:
+                    continue
+                try:
+                    await portal.refresh_post_stats(self, limit, min_interval=interval / 2)
+                except Exception:
+                    portal.log.exception("Failed to refresh channel post stats")
+                await asyncio.sleep(1)
+
+    async def _chat_resync_loop(self) -> None:
+        interval = self.config["bridge.chat_resync_interval"] * 60 * 60
+        while True:
+            await asyncio.sleep(interval)
+            try:
+                await self.sync_dialogs(full=True)
+            except Exception:
+                self.log.exception("Failed to run periodic chat list resync")
+
+    async def _birthday_notice_loop(self) -> None:
+        while True:
+            try:
+                await self.send_birthday_notices()
+            except Exception:
+                self.log.exception("Failed to check contact birthdays")
+            await asyncio.sleep(60 * 60)
+
+    async def send_birthday_notices(self) -> None:
+        today = datetime.now(tz=timezone.utc).date()
+        res = await self.client(GetBirthdaysRequest())
+        for contact in res.contacts:
+            contact_id = TelegramID(contact.contact_id)
+            bday = contact.birthday
+            if (bday.day, bday.month) != (today.day, today.month):
+                continue
+            portal = await po.Portal.get_by_tgid(contact_id, tg_receiver=self.tgid)
+            if not portal or not portal.mxid or portal.birthday_notice_year == today.year:
+                continue
+            portal.birthday_notice_year = today.year
+            await portal.save()
+            puppet = await pu.Puppet.get_by_tgid(contact_id)
+            name = puppet.displayname or str(contact_id)
+            if bday.year:
+                text = f"🎂 {name} turns {today.year - bday.year} today"
+            else:
+                text = f"🎂 Today is {name}'s birthday"
+            self.log.debug(f"Sending birthday notice for {contact_id} to {portal.mxid}")
+            await portal.main_intent.send_notice(portal.mxid, text)
+
     async def _check_server_notice_edit(self, message: Message) -> None:
         if "Data export request" in message.message and "Accepted" in message.message:
             self.log.debug(
@@ -430,13 +621,69 @@ class User(DBUser, AbstractUser, BaseUser):
             )
             self.takeout_retry_immediate.set()
 
+    async def _create_notice_room(self) -> None:
+        # Logins through the provisioning API or the web login don't happen in a management
+        # room, so create one to have somewhere to send bridge notices.
+        self.log.debug("Creating management room for bridge notices")
+        try:
+            self.notice_room = await self.az.intent.create_room(
+                invitees=[self.mxid], is_direct=True
+            )
+        except Exception:
+            self.log.warning("Failed to create management room", exc_info=True)
+            return
+        await self.save()
+
+    async def send_bridge_notice(self, text: str) -> None:
+        if not self.notice_room:
+            self.log.debug(f"Not sending bridge notice (no notice room set): {text}")
+            return
+        try:
+            await self.az.intent.send_notice(self.notice_room, text)
+        except Exception:
+            self.log.warning(f"Failed to send bridge notice to {self.notice_room}", exc_info=True)
+
+    async def get_takeout_status(self) -> dict[str, Any]:
+        remaining = await Backfill.count_remaining(self.mxid)
+        status = {
+            "state": self.takeout_state,
+            "chats_done": len(self._takeout_done_chats),
+            "requests_done": self._takeout_done_requests,
+            "requests_remaining": remaining,
+            "eta_seconds": None,
+        }
+        if self.takeout_state == "running" and self._takeout_done_requests > 0:
+            elapsed = time.monotonic() - self._takeout_started_at
+            status["eta_seconds"] = int(elapsed / self._takeout_done_requests * remaining)
+        return status
+
+    def cancel_takeout(self) -> bool:
+        if not self._backfill_task or self._backfill_task.done():
+            return False
+        self.log.info("Cancelling backfill loop as requested")
+        self._backfill_task.cancel()
+        self._backfill_task = None
+        self.takeout_requested = False
+        self.takeout_state = "cancelled"
+        return True
+
+    def restart_takeout(self) -> None:
+        self.cancel_takeout()
+        self.takeout_state = "idle"
+        self._backfill_task = asyncio.create_task(self._try_handle_backfill_requests_loop())
+
     async def _takeout_and_backfill(self, first_req: Backfill, first_attempt: bool = True) -> None:
         self.takeout_retry_immediate.clear()
         self.takeout_requested = True
+        self.takeout_state = "pending"
         try:
             async with self.client.takeout(**self._takeout_options) as takeout_client:
                 self.takeout_requested = False
                 self.log.info("Acquired takeout client successfully")
+                if not first_attempt:
+                    await self.send_bridge_notice(
+                        "Data export request accepted, starting to backfill message history"
+                    )
                 await self._backfill_loop_with_client(takeout_client, first_req)
                 self.log.info("Backfills finished, exiting takeout")
         except TakeoutInitDelayError as e:
@@ -444,6 +691,13 @@ class User(DBUser, AbstractUser, BaseUser):
                 self.log.info(
                     f"Takeout requested, will wait for retry request or {e.seconds} seconds"
                 )
+                await self.send_bridge_notice(
+                    "Telegram requires approving a data export request before the bridge can "
+                    "backfill message history. Please accept the request in the Service "
+                    "Notifications chat on another Telegram client. Backfilling will start "
+                    "automatically once the request is accepted, or in "
+                    f"{format_duration(e.seconds)} at the latest."
+                )
             else:
                 self.log.warning(
                     f"Got takeout init delay again after retry, waiting for {e.seconds} seconds"
@@ -457,6 +711,19 @@ class User(DBUser, AbstractUser, BaseUser):
 
     async def _backfill_loop_with_client(
         self, client: MautrixTelegramClient, first_req: Backfill
+    ) -> None:
+        self.takeout_state = "running"
+        self._takeout_started_at = time.monotonic()
+        self._takeout_done_requests = 0
+        self._takeout_done_chats = set()
+        try:
+            await self._backfill_loop_iterate(client, first_req)
+        finally:
+            if self.takeout_state == "running":
+                self.takeout_state = "idle"
+
+    async def _backfill_loop_iterate(
+        self, client: MautrixTelegramClient, first_req: Backfill
     ) -> None:
         missed_reqs = 0
         while missed_reqs < 10:
@@ -477,12 +744,21 @@ class User(DBUser, AbstractUser, BaseUser):
                     TelegramID(req.portal_tgid), tg_receiver=TelegramID(req.portal_tg_receiver)
                 )
                 await req.mark_dispatched()
-                if req.type == BackfillType.HISTORICAL:
+                if req.type in (BackfillType.HISTORICAL, BackfillType.ON_DEMAND):
                     await portal.backfill(self, client, req=req)
                 elif req.type == BackfillType.SYNC_DIALOG:
                     await self._backfill_sync_dialog(portal, client, req.extra_data)
                 await req.mark_done()
+                self._takeout_done_requests += 1
+                self._takeout_done_chats.add((req.portal_tgid, req.portal_tg_receiver))
                 await asyncio.sleep(req.post_batch_delay)
+            except FloodWaitError as e:
+                self.log.warning(
+                    f"Got flood wait of {e.seconds} seconds while handling backfill request for "
+                    f"{req.portal_tgid}, pausing backfill"
+                )
+                await req.set_cooldown_timeout(e.seconds)
+                await asyncio.sleep(e.seconds)
             except Exception:
                 self.log.exception("Error handling backfill request for %s", req.portal_tgid)
                 await req.set_cooldown_timeout(1800)
@@ -589,6 +865,38 @@ class User(DBUser, AbstractUser, BaseUser):
                 except MatrixRequestError:
                     pass
 
+    async def import_session(self, imported: ImportedSession) -> TLUser:
+        """
+        Replace the session of this user with an existing authorized session (e.g. from another
+        Telethon or Pyrogram client) and return the info of the account it belongs to.
+        """
+        await self.stop()
+        session = PgSession(
+            self.mxid,
+            dc_id=imported.dc_id,
+            server_address=imported.server_address,
+            port=imported.port,
+            auth_key=AuthKey(imported.auth_key),
+        )
+        # Make sure entities and update state from any previous session don't get mixed in
+        await session.delete()
+        await session.save()
+        # Only connect without running post_login(), the caller handles the rest of the login
+        await AbstractUser.start(self)
+        try:
+            info = await self.client.get_me()
+        except (UnauthorizedError, AuthKeyError):
+            info = None
+        except Exception:
+            await self.stop()
+            await session.delete()
+            raise
+        if info is None:
+            await self.stop()
+            await session.delete()
+            raise SessionImportError("The session is not logged in or has been revoked")
+        return info
+
     async def log_out(
         self,
         delete: bool = True,
@@ -731,6 +1039,11 @@ class User(DBUser, AbstractUser, BaseUser):
                 puppet, portal, self.config["bridge.archive_tag"], peer.folder_id == 1
             )
 
+    async def set_archive_tag(self, portal: po.Portal, archived: bool) -> None:
+        puppet = await pu.Puppet.get_by_custom_mxid(self.mxid)
+        if puppet and puppet.is_real_user:
+            await self._tag_room(puppet, portal, self.config["bridge.archive_tag"], archived)
+
     async def update_pinned_dialogs(self, update: UpdatePinnedDialogs) -> None:
         if self.config["bridge.tag_only_on_create"]:
             return
@@ -758,6 +1071,37 @@ class User(DBUser, AbstractUser, BaseUser):
         )
         await self._mute_room(puppet, portal, update.notify_settings.mute_until.timestamp())
 
+    async def update_saved_reaction_tags(self, update: UpdateSavedReactionTags) -> None:
+        await self.sync_saved_reaction_tags()
+
+    async def sync_saved_reaction_tags(self) -> None:
+        portal = await po.Portal.get_by_tgid(self.tgid, tg_receiver=self.tgid)
+        if not portal or not portal.mxid:
+            return
+        puppet = await pu.Puppet.get_by_custom_mxid(self.mxid)
+        if not puppet or not puppet.is_real_user:
+            return
+        try:
+            resp = await self.client(GetSavedReactionTagsRequest(hash=0))
+        except RPCError as e:
+            self.log.debug(f"Failed to get saved reaction tags: {e}")
+            return
+        if not isinstance(resp, SavedReactionTags):
+            return
+        tags = []
+        for tag in resp.tags:
+            if isinstance(tag.reaction, ReactionEmoji):
+                reaction = tag.reaction.emoticon
+            elif isinstance(tag.reaction, ReactionCustomEmoji):
+                reaction = str(tag.reaction.document_id)
+            else:
+                continue
+            tags.append({"reaction": reaction, "title": tag.title, "count": tag.count})
+        self.log.debug(f"Syncing {len(tags)} saved reaction tags to {portal.mxid}")
+        await puppet.intent.set_account_data(
+            SavedReactionTagsAccountData, {"tags": tags}, room_id=portal.mxid
+        )
+
     @staticmethod
     def dialog_to_sync_args(dialog: Dialog) -> dict:
         return {
@@ -885,21 +1229,27 @@ class User(DBUser, AbstractUser, BaseUser):
             }
         return self._portals_cache
 
-    async def sync_dialogs(self) -> None:
+    async def sync_dialogs(self, full: bool = False) -> None:
         if self.is_bot:
             return
+        async with self._sync_dialogs_lock:
+            await self._sync_dialogs(full)
+
+    async def _sync_dialogs(self, full: bool) -> None:
+        # Full syncs check every dialog including archived ones, but creating new portals is
+        # still limited by sync_create_limit.
         creators = []
-        update_limit = self.config["bridge.sync_update_limit"] or None
+        update_limit = None if full else self.config["bridge.sync_update_limit"] or None
         create_limit = self.config["bridge.sync_create_limit"]
         index = 0
-        self.log.debug(f"Syncing dialogs ({update_limit=}, {create_limit=})")
+        self.log.debug(f"Syncing dialogs ({full=}, {update_limit=}, {create_limit=})")
         await self.push_bridge_state(BridgeStateEvent.BACKFILLING)
         puppet = await pu.Puppet.get_by_custom_mxid(self.mxid)
         dialog: Dialog
         old_portal_cache = await self.get_cached_portals()
         new_portal_cache = old_portal_cache.copy()
         async for dialog in self.client.iter_dialogs(
-            limit=update_limit, ignore_migrated=True, archived=False
+            limit=update_limit, ignore_migrated=True, archived=None if full else False
         ):
             entity = dialog.entity
             if isinstance(entity, ChatForbidden):
@@ -929,23 +1279,134 @@ class User(DBUser, AbstractUser, BaseUser):
         await self.update_direct_chats()
         self.log.debug("Dialog syncing complete")
 
+    async def check_portal_consistency(self) -> None:
+        """
+        Compare the portals the user is registered in against their Telegram dialog list to find
+        chats they left while the bridge wasn't running.
+        """
+        in_dialogs: set[tuple[TelegramID, TelegramID]] = set()
+        async for dialog in self.client.iter_dialogs(ignore_migrated=True, archived=None):
+            entity = dialog.entity
+            if isinstance(entity, ChatForbidden) or (
+                isinstance(entity, Chat) and (entity.deactivated or entity.left)
+            ):
+                continue
+            tgid = TelegramID(entity.id)
+            in_dialogs.add((tgid, self.tgid if isinstance(entity, TLUser) else tgid))
+        cleanup = self.config["bridge.consistency_check.cleanup"]
+        portals = await self.get_cached_portals()
+        for tgid_full, portal in list(portals.items()):
+            # Private chats stay usable even if the dialog was deleted
+            if portal.peer_type == "user" or tgid_full in in_dialogs:
+                continue
+            elif not cleanup:
+                self.log.warning(
+                    f"Registered in portal {portal.tgid_log} ({portal.mxid}), "
+                    "but the chat is not in the Telegram dialog list"
+                )
+                continue
+            self.log.info(f"Removing from portal {portal.tgid_log}, chat is not in dialog list")
+            if not portal.mxid:
+                await self.unregister_portal(*tgid_full)
+                continue
+            try:
+                await portal.delete_telegram_user(self.tgid, sender=None)
+                if not await portal.get_authenticated_matrix_users():
+                    await portal.cleanup_portal("Portal deleted (no Telegram users left)")
+            except Exception:
+                self.log.exception(f"Failed to clean up portal {portal.tgid_log}")
+        self.log.debug("Portal consistency check complete")
+
     async def register_portal(self, portal: po.Portal) -> None:
         self.log.trace(f"Registering portal {portal.tgid_full}")
+        # Portals may already be cached before their room is created, so the space is checked
+        # separately (add_portal_to_space is a no-op if the room is already in the space).
+        if not self.is_bot and self.config["bridge.personal_filtering_spaces"]:
+            background_task.create(self.add_portal_to_space(portal))
         if self._portals_cache is not None:
             if self._portals_cache.get(portal.tgid_full) == portal:
                 return
             self._portals_cache[portal.tgid_full] = portal
         await super().register_portal(portal.tgid, portal.tg_receiver)
 
+    async def get_space_room(self, create: bool = True) -> RoomID | None:
+        async with self._space_lock:
+            if self.space_room or not create:
+                return self.space_room
+            self.log.debug("Creating personal filtering space")
+            avatar = self.config["appservice.bot_avatar"]
+            self.space_room = await self.az.intent.create_room(
+                name="Telegram",
+                topic="Your Telegram chats",
+                invitees=[self.mxid],
+                creation_content={"type": "m.space"},
+                initial_state=(
+                    [{"type": str(EventType.ROOM_AVATAR), "content": {"url": avatar}}]
+                    if avatar and avatar != "remove"
+                    else []
+                ),
+                power_level_override={
+                    "users": {self.az.bot_mxid: 9001, self.mxid: 50},
+                },
+            )
+            self._space_children = set()
+            await self.save()
+            return self.space_room
+
+    async def _get_space_children(self, space_room: RoomID) -> set[RoomID]:
+        if self._space_children is None:
+            state = await self.az.intent.get_state(space_room)
+            self._space_children = {
+                RoomID(evt.state_key)
+                for evt in state
+                if evt.type == EventType.SPACE_CHILD and getattr(evt.content, "via", None)
+            }
+        return self._space_children
+
+    async def add_portal_to_space(self, portal: po.Portal) -> None:
+        if not portal.mxid:
+            return
+        try:
+            space_room = await self.get_space_room()
+            children = await self._get_space_children(space_room)
+            if portal.mxid in children:
+                return
+            await self.az.intent.send_state_event(
+                space_room,
+                EventType.SPACE_CHILD,
+                {"via": [self.config["homeserver.domain"]]},
+                state_key=portal.mxid,
+            )
+            children.add(portal.mxid)
+        except Exception:
+            self.log.exception(f"Failed to add {portal.mxid} to personal filtering space")
+
+    async def _add_all_portals_to_space(self) -> None:
+        for portal in list((await self.get_cached_portals()).values()):
+            if portal:
+                await self.add_portal_to_space(portal)
+
     async def unregister_portal(self, tgid: TelegramID, tg_receiver: TelegramID) -> None:
         self.log.trace(f"Unregistering portal {(tgid, tg_receiver)}")
         if self._portals_cache is not None:
             self._portals_cache.pop((tgid, tg_receiver), None)
         await super().unregister_portal(tgid, tg_receiver)
 
+    async def get_send_as(self, portal: po.Portal) -> int | None:
+        try:
+            return self._send_as_cache[portal.tgid_full]
+        except KeyError:
+            send_as = await super().get_send_as(portal.tgid, portal.tg_receiver)
+            self._send_as_cache[portal.tgid_full] = send_as
+            return send_as
+
+    async def set_send_as(self, portal: po.Portal, send_as: int | None) -> None:
+        await super().set_send_as(portal.tgid, portal.tg_receiver, send_as)
+        self._send_as_cache[portal.tgid_full] = send_as
+
     async def needs_relaybot(self, portal: po.Portal) -> bool:
         return not await self.is_logged_in() or (
-            (portal.has_bot or self.is_bot)
+            (portal.has_relay or self.is_bot)
             and portal.tgid_full not in await self.get_cached_portals()
         )
 
@@ -1035,6 +1496,16 @@ class User(DBUser, AbstractUser, BaseUser):
             else cfg.get("reactions_user_max_default", 1)
         )
 
+    async def get_max_caption_length(self, is_premium: bool | None = None) -> int:
+        if is_premium is None:
+            is_premium = self.is_premium
+        cfg = await self.get_app_config()
+        return (
+            cfg.get("caption_length_limit_premium", 2048)
+            if is_premium
+            else cfg.get("caption_length_limit_default", 1024)
+        )
+
     # endregion
     # region Class instance lookup
 
@@ -7,6 +7,8 @@ from .file_transfer import (
     transfer_thumbnail_to_matrix,
     unicode_custom_emoji_map,
 )
+from .outbound_media import ConvertedMedia, convert_for_telegram, get_conversion, get_media_class
 from .parallel_file_transfer import parallel_transfer_to_telegram
 from .recursive_dict import recursive_del, recursive_get, recursive_set
+from .server_outage import SERVER_ERRORS, server_outages
 from .tl_json import parse_tl_json
@@ -50,8 +50,9 @@ from mautrix.util import ffmpeg, magic, variation_selector
 from .. import abstract_user as au
 from ..db import TelegramFile as DBTelegramFile
 from ..tgclient import MautrixTelegramClient
-from ..util import sane_mimetypes
+from ..util import sane_mimetypes, tracing
 from .parallel_file_transfer import parallel_transfer_to_matrix
+from .round_video_converter import convert_round_video
 from .tgs_converter import convert_tgs_to
 from .webm_converter import convert_webm_to
 
@@ -271,6 +272,7 @@ async def transfer_file_to_matrix(
     is_sticker: bool = False,
     tgs_convert: dict | None = None,
     webm_convert: str | None = None,
+    round_convert: dict | None = None,
     filename: str | None = None,
     encrypt: bool = False,
     parallel_id: int | None = None,
@@ -292,20 +294,22 @@ async def transfer_file_to_matrix(
         lock = asyncio.Lock()
         transfer_locks[location_id] = lock
     async with lock:
-        return await _unlocked_transfer_file_to_matrix(
-            client,
-            intent,
-            location_id,
-            location,
-            thumbnail,
-            is_sticker,
-            tgs_convert,
-            webm_convert,
-            filename,
-            encrypt,
-            parallel_id,
-            async_upload=async_upload,
-        )
+        with tracing.span("telegram.transfer_file", location_id=location_id):
+            return await _unlocked_transfer_file_to_matrix(
+                client,
+                intent,
+                location_id,
+                location,
+                thumbnail,
+                is_sticker,
+                tgs_convert,
+                webm_convert,
+                round_convert,
+                filename,
+                encrypt,
+                parallel_id,
+                async_upload=async_upload,
+            )
 
 
 async def _unlocked_transfer_file_to_matrix(
@@ -317,6 +321,7 @@ async def _unlocked_transfer_file_to_matrix(
     is_sticker: bool,
     tgs_convert: dict | None,
     webm_convert: str | None,
+    round_convert: dict | None,
     filename: str | None,
     encrypt: bool,
     parallel_id: int | None,
@@ -328,7 +333,12 @@ async def _unlocked_transfer_file_to_matrix(
 
     converted_anim = None
 
-    if parallel_id and isinstance(location, Document) and (not is_sticker or not tgs_convert):
+    if (
+        parallel_id
+        and isinstance(location, Document)
+        and (not is_sticker or not tgs_convert)
+        and not round_convert
+    ):
         db_file = await parallel_transfer_to_matrix(
             client, intent, loc_id, location, filename, encrypt, parallel_id
         )
@@ -363,6 +373,15 @@ async def _unlocked_transfer_file_to_matrix(
             file = converted_anim.data
             image_converted = mime_type != "video/webm"
             thumbnail = None
+        elif round_convert and mime_type == "video/mp4":
+            converted_anim = await convert_round_video(
+                file, round_convert["target"], round_convert["args"]["size"]
+            )
+            mime_type = converted_anim.mime
+            file = converted_anim.data
+            width, height = converted_anim.width or None, converted_anim.height or None
+            # The converter returns the original data if conversion is disabled or failed
+            image_converted = file is not unencrypted_file
 
         decryption_info = None
         upload_mime_type = mime_type
@@ -0,0 +1,184 @@
+# mautrix-telegram - A Matrix-Telegram puppeting bridge
+# Copyright (C) 2026 Tulir Asokan
+#
+# This program is free software: you can redistribute it and/or modify
+# it under the terms of the GNU Affero General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# This program is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU Affero General Public License for more details.
+#
+# You should have received a copy of the GNU Affero General Public License
+# along with this program.  If not, see <https://www.gnu.org/licenses/>.
+from __future__ import annotations
+
+from typing import Any
+from datetime import datetime, timezone
+import base64
+import hashlib
+import json
+import os
+
+from telethon.crypto import AuthKey
+from telethon.tl.types import updates
+
+from ..db import PgSession, User as DBUser
+
+try:
+    from Crypto.Cipher import AES
+except ImportError:
+    AES = None
+
+BACKUP_VERSION = 1
+SALT_SIZE = 16
+NONCE_SIZE = 12
+# scrypt parameters for deriving the backup key from the passphrase
+SCRYPT_N = 2**15
+SCRYPT_R = 8
+SCRYPT_P = 1
+
+
+class LoginBackupError(ValueError):
+    pass
+
+
+def _derive_key(passphrase: str, salt: bytes) -> bytes:
+    return hashlib.scrypt(
+        passphrase.encode("utf-8"),
+        salt=salt,
+        n=SCRYPT_N,
+        r=SCRYPT_R,
+        p=SCRYPT_P,
+        maxmem=64 * 1024 * 1024,
+        dklen=32,
+    )
+
+
+def encrypt_backup(data: dict[str, Any], passphrase: str) -> bytes:
+    if not AES:
+        raise LoginBackupError("Encrypting backups requires pycryptodome")
+    salt = os.urandom(SALT_SIZE)
+    nonce = os.urandom(NONCE_SIZE)
+    cipher = AES.new(_derive_key(passphrase, salt), AES.MODE_GCM, nonce=nonce)
+    ciphertext, tag = cipher.encrypt_and_digest(json.dumps(data).encode("utf-8"))
+    envelope = {
+        "version": BACKUP_VERSION,
+        "salt": base64.b64encode(salt).decode("utf-8"),
+        "nonce": base64.b64encode(nonce).decode("utf-8"),
+        "ciphertext": base64.b64encode(ciphertext + tag).decode("utf-8"),
+    }
+    return json.dumps(envelope).encode("utf-8")
+
+
+def decrypt_backup(blob: bytes, passphrase: str) -> dict[str, Any]:
+    if not AES:
+        raise LoginBackupError("Decrypting backups requires pycryptodome")
+    try:
+        envelope = json.loads(blob)
+        if envelope.get("version") != BACKUP_VERSION:
+            raise LoginBackupError(f"Unsupported backup version {envelope.get('version')}")
+        salt = base64.b64decode(envelope["salt"])
+        nonce = base64.b64decode(envelope["nonce"])
+        ciphertext = base64.b64decode(envelope["ciphertext"])
+    except (ValueError, KeyError, TypeError) as e:
+        raise LoginBackupError("The file is not a login backup") from e
+    cipher = AES.new(_derive_key(passphrase, salt), AES.MODE_GCM, nonce=nonce)
+    try:
+        data = cipher.decrypt_and_verify(ciphertext[:-16], ciphertext[-16:])
+    except ValueError as e:
+        raise LoginBackupError("Wrong passphrase or corrupted backup") from e
+    return json.loads(data)
+
+
+async def export_logins() -> dict[str, Any]:
+    """
+    Collect the user rows and Telegram sessions of all logged-in users, so that they can be
+    restored into a fresh database without having to log in again.
+    """
+    logins = []
+    for user in await DBUser.all_with_tgid():
+        session = await PgSession.get(user.mxid)
+        if not session.auth_key_bytes:
+            continue
+        update_states = [
+            {
+                "entity_id": entity_id,
+                "pts": state.pts,
+                "qts": state.qts,
+                "date": int(state.date.timestamp()),
+                "seq": state.seq,
+                "unread_count": state.unread_count,
+            }
+            for entity_id, state in await session.get_update_states()
+        ]
+        logins.append(
+            {
+                "user": {
+                    "mxid": user.mxid,
+                    "tgid": user.tgid,
+                    "tg_username": user.tg_username,
+                    "tg_phone": user.tg_phone,
+                    "is_bot": user.is_bot,
+                    "is_premium": user.is_premium,
+                    "saved_contacts": user.saved_contacts,
+                    "notice_room": user.notice_room,
+                    "device_name": user.device_name,
+                    "space_room": user.space_room,
+                },
+                "session": {
+                    "dc_id": session.dc_id,
+                    "server_address": session.server_address,
+                    "port": session.port,
+                    "auth_key": base64.b64encode(session.auth_key_bytes).decode("utf-8"),
+                },
+                "update_states": update_states,
+            }
+        )
+    return {"logins": logins}
+
+
+async def import_logins(data: dict[str, Any], keep_rooms: bool = False) -> list[str]:
+    """
+    Restore logins from :func:`export_logins` output. Users that already have a session in the
+    database are skipped. Returns the Matrix user IDs of the imported users.
+    """
+    imported = []
+    for login in data.get("logins", []):
+        user_data = dict(login["user"])
+        if not keep_rooms:
+            # Room IDs are only valid if the homeserver data survived
+            user_data["notice_room"] = user_data["space_room"] = None
+        mxid = user_data["mxid"]
+        if await PgSession.has(mxid):
+            continue
+        user = DBUser(**user_data)
+        if await DBUser.get_by_mxid(mxid):
+            await user.save()
+        else:
+            await user.insert()
+        session_data = login["session"]
+        session = PgSession(
+            mxid,
+            dc_id=session_data["dc_id"],
+            server_address=session_data["server_address"],
+            port=session_data["port"],
+            auth_key=AuthKey(base64.b64decode(session_data["auth_key"])),
+        )
+        await session.save()
+        for state in login.get("update_states", []):
+            # Restoring the update state lets the bridge catch up on what it missed
+            await session.set_update_state(
+                state["entity_id"],
+                updates.State(
+                    pts=state["pts"],
+                    qts=state["qts"],
+                    date=datetime.fromtimestamp(state["date"], tz=timezone.utc),
+                    seq=state["seq"],
+                    unread_count=state["unread_count"],
+                ),
+            )
+        imported.append(mxid)
+    return imported
@@ -0,0 +1,167 @@
+# mautrix-telegram - A Matrix-Telegram puppeting bridge
+# Copyright (C) 2026 Tulir Asokan
+#
+# This program is free software: you can redistribute it and/or modify
+# it under the terms of the GNU Affero General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# This program is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU Affero General Public License for more details.
+#
+# You should have received a copy of the GNU Affero General Public License
+# along with this program.  If not, see <https://www.gnu.org/licenses/>.
+from __future__ import annotations
+
+from typing import NamedTuple
+from io import BytesIO
+import logging
+
+from mautrix.types import MediaMessageEventContent, MessageType
+from mautrix.util import ffmpeg
+
+try:
+    from PIL import Image
+except ImportError:
+    Image = None
+
+log: logging.Logger = logging.getLogger("mau.util.outbound_media")
+
+
+class MediaConversion(NamedTuple):
+    target_mime: str
+    # Pillow format name, tried before ffmpeg if set
+    image_format: str | None = None
+    ffmpeg_extension: str | None = None
+    ffmpeg_args: tuple[str, ...] = ()
+    # Set if the conversion always produces a square video of this size
+    output_size: int | None = None
+
+
+class ConvertedMedia(NamedTuple):
+    mime: str
+    data: bytes
+    width: int | None = None
+    height: int | None = None
+
+
+_to_jpeg = MediaConversion("image/jpeg", image_format="jpeg", ffmpeg_extension=".jpg")
+_to_png = MediaConversion("image/png", image_format="png", ffmpeg_extension=".png")
+_to_webp = MediaConversion("image/webp", image_format="webp", ffmpeg_extension=".webp")
+_to_opus = MediaConversion(
+    "audio/ogg", ffmpeg_extension=".ogg", ffmpeg_args=("-vn", "-c:a", "libopus")
+)
+_to_mp4 = MediaConversion(
+    "video/mp4",
+    ffmpeg_extension=".mp4",
+    ffmpeg_args=("-c:v", "libx264", "-pix_fmt", "yuv420p", "-c:a", "aac"),
+)
+ROUND_VIDEO_SIZE = 384
+# Round videos must be square and at most a minute long, so they're always re-encoded.
+_to_round_mp4 = MediaConversion(
+    "video/mp4",
+    ffmpeg_extension=".mp4",
+    ffmpeg_args=(
+        "-vf",
+        f"crop='min(iw,ih)':'min(iw,ih)',scale={ROUND_VIDEO_SIZE}:{ROUND_VIDEO_SIZE}",
+        "-t",
+        "60",
+        "-c:v",
+        "libx264",
+        "-pix_fmt",
+        "yuv420p",
+        "-c:a",
+        "aac",
+        "-movflags",
+        "+faststart",
+    ),
+    output_size=ROUND_VIDEO_SIZE,
+)
+
+# Maps (Telegram media class, Matrix mimetype) to the conversion needed before sending.
+# None means the file can be sent as-is. Wildcards like image/* are only checked if there's
+# no exact match. Anything not in the table is passed through and Telegram decides what to do.
+conversion_table: dict[tuple[str, str], MediaConversion | None] = {
+    ("photo", "image/jpeg"): None,
+    ("photo", "image/png"): None,
+    ("photo", "image/webp"): _to_png,
+    ("photo", "image/bmp"): _to_png,
+    ("photo", "image/heic"): _to_jpeg,
+    ("photo", "image/heif"): _to_jpeg,
+    ("photo", "image/avif"): _to_jpeg,
+    ("photo", "image/tiff"): _to_jpeg,
+    ("sticker", "image/webp"): None,
+    ("sticker", "image/gif"): None,
+    ("sticker", "video/webm"): None,
+    ("sticker", "application/x-tgsticker"): None,
+    ("sticker", "image/*"): _to_webp,
+    ("voice", "audio/ogg"): None,
+    ("voice", "audio/*"): _to_opus,
+    ("voice", "video/*"): _to_opus,
+    ("video", "video/mp4"): None,
+    ("video", "video/quicktime"): _to_mp4,
+    ("video", "video/x-matroska"): _to_mp4,
+    ("video", "video/x-msvideo"): _to_mp4,
+    ("round", "video/*"): _to_round_mp4,
+}
+
+
+def get_media_class(content: MediaMessageEventContent) -> str:
+    if content.msgtype == MessageType.STICKER:
+        return "sticker"
+    elif content.msgtype == MessageType.IMAGE:
+        return "photo"
+    elif content.msgtype == MessageType.VIDEO:
+        return "round" if content.get("fi.mau.telegram.round_message") else "video"
+    elif content.msgtype == MessageType.AUDIO:
+        return "voice" if "org.matrix.msc3245.voice" in content else "audio"
+    return "document"
+
+
+def get_conversion(media_class: str, mime: str | None) -> MediaConversion | None:
+    if not mime:
+        return None
+    try:
+        return conversion_table[(media_class, mime)]
+    except KeyError:
+        return conversion_table.get((media_class, f"{mime.split('/')[0]}/*"))
+
+
+def _convert_with_pillow(data: bytes, image_format: str) -> tuple[bytes, int, int]:
+    image: Image.Image = Image.open(BytesIO(data))
+    # JPEG doesn't support transparency
+    image = image.convert("RGB" if image_format == "jpeg" else "RGBA")
+    new_file = BytesIO()
+    image.save(new_file, image_format)
+    w, h = image.size
+    return new_file.getvalue(), w, h
+
+
+async def convert_for_telegram(data: bytes, mime: str | None, media_class: str) -> ConvertedMedia:
+    conv = get_conversion(media_class, mime)
+    if not conv:
+        return ConvertedMedia(mime, data)
+    if conv.image_format and Image:
+        try:
+            converted, w, h = _convert_with_pillow(data, conv.image_format)
+            return ConvertedMedia(conv.target_mime, converted, w, h)
+        except Exception as e:
+            log.debug(f"Failed to convert {mime} to {conv.target_mime} with Pillow: {e}")
+    if conv.ffmpeg_extension:
+        try:
+            converted = await ffmpeg.convert_bytes(
+                data,
+                output_extension=conv.ffmpeg_extension,
+                output_args=conv.ffmpeg_args,
+                input_mime=mime,
+                logger=log,
+            )
+            return ConvertedMedia(
+                conv.target_mime, converted, conv.output_size, conv.output_size
+            )
+        except ffmpeg.ConverterError as e:
+            log.warning(f"Failed to convert {mime} to {conv.target_mime} with ffmpeg: {e}")
+    log.warning(f"Sending {mime} as-is to Telegram as {media_class} as conversion failed")
+    return ConvertedMedia(mime, data)
@@ -0,0 +1,75 @@
+# mautrix-telegram - A Matrix-Telegram puppeting bridge
+# Copyright (C) 2026 Tulir Asokan
+#
+# This program is free software: you can redistribute it and/or modify
+# it under the terms of the GNU Affero General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# This program is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU Affero General Public License for more details.
+#
+# You should have received a copy of the GNU Affero General Public License
+# along with this program.  If not, see <https://www.gnu.org/licenses/>.
+from __future__ import annotations
+
+import logging
+
+from mautrix.util import ffmpeg
+
+from .tgs_converter import ConvertedSticker
+
+log: logging.Logger = logging.getLogger("mau.util.round_video")
+
+
+def _converter_args(target: str, size: int) -> dict[str, tuple[str, ...]]:
+    scale = f"scale={size}:{size}:force_original_aspect_ratio=increase,crop={size}:{size}"
+    if target == "webm":
+        # Make everything outside the circle transparent, so the video looks the same as in
+        # Telegram even in clients that don't know about round messages.
+        circle = "geq=lum='p(X,Y)':cb='p(X,Y)':cr='p(X,Y)'" + (
+            ":a='if(lte(hypot(X-W/2,Y-H/2),W/2),255,0)'"
+        )
+        return {
+            "output_args": (
+                "-vf",
+                f"{scale},format=yuva420p,{circle}",
+                "-c:v",
+                "libvpx-vp9",
+                "-c:a",
+                "libopus",
+            ),
+        }
+    return {
+        "output_args": (
+            "-vf",
+            scale,
+            "-c:v",
+            "libx264",
+            "-pix_fmt",
+            "yuv420p",
+            "-c:a",
+            "aac",
+            "-movflags",
+            "+faststart",
+        ),
+    }
+
+
+async def convert_round_video(file: bytes, convert_to: str, size: int = 384) -> ConvertedSticker:
+    if convert_to in ("mp4", "webm"):
+        try:
+            converted_data = await ffmpeg.convert_bytes(
+                data=file,
+                output_extension=f".{convert_to}",
+                input_mime="video/mp4",
+                **_converter_args(convert_to, size),
+            )
+            return ConvertedSticker(f"video/{convert_to}", converted_data, width=size, height=size)
+        except ffmpeg.ConverterError as e:
+            log.error(str(e))
+    elif convert_to != "disable":
+        log.warning(f"Unable to convert round video, type {convert_to} not supported")
+    return ConvertedSticker("video/mp4", file)
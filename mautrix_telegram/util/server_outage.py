@@ -0,0 +1,90 @@
+# mautrix-telegram - A Matrix-Telegram puppeting bridge
+# Copyright (C) 2026 Tulir Asokan
+#
+# This program is free software: you can redistribute it and/or modify
+# it under the terms of the GNU Affero General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# This program is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU Affero General Public License for more details.
+#
+# You should have received a copy of the GNU Affero General Public License
+# along with this program.  If not, see <https://www.gnu.org/licenses/>.
+from __future__ import annotations
+
+from collections import defaultdict, deque
+import asyncio
+import logging
+import time
+
+from telethon.errors import ServerError, TimedOutError
+
+log: logging.Logger = logging.getLogger("mau.util.server_outage")
+
+# Errors that mean Telegram itself is having problems rather than something being wrong with
+# the request. Telethon already retries these a few times before raising them.
+SERVER_ERRORS = (ServerError, TimedOutError)
+
+
+class ServerOutageTracker:
+    """
+    Tracks internal server errors per Telegram DC. When several happen in a short time, the DC is
+    considered to be having an outage and requests to it should wait with exponential backoff
+    instead of failing one by one.
+    """
+
+    threshold: int
+    window: float
+    min_backoff: float
+    max_backoff: float
+
+    _errors: dict[int, deque[float]]
+    _backoff: dict[int, float]
+    _backoff_until: dict[int, float]
+
+    def __init__(
+        self,
+        threshold: int = 3,
+        window: float = 60,
+        min_backoff: float = 5,
+        max_backoff: float = 300,
+    ) -> None:
+        self.threshold = threshold
+        self.window = window
+        self.min_backoff = min_backoff
+        self.max_backoff = max_backoff
+        self._errors = defaultdict(lambda: deque(maxlen=self.threshold))
+        self._backoff = {}
+        self._backoff_until = {}
+
+    def record_error(self, dc_id: int) -> float:
+        """Record a server error and return how long requests to the DC should wait."""
+        now = time.monotonic()
+        errors = self._errors[dc_id]
+        errors.append(now)
+        if len(errors) < self.threshold or errors[0] + self.window < now:
+            return 0
+        backoff = min(self._backoff.get(dc_id, self.min_backoff / 2) * 2, self.max_backoff)
+        self._backoff[dc_id] = backoff
+        self._backoff_until[dc_id] = now + backoff
+        log.warning(f"DC {dc_id} seems to be having an outage, backing off for {backoff} seconds")
+        return backoff
+
+    def record_success(self, dc_id: int) -> None:
+        if self._backoff.pop(dc_id, None) is not None:
+            log.info(f"DC {dc_id} seems to have recovered")
+        self._errors.pop(dc_id, None)
+        self._backoff_until.pop(dc_id, None)
+
+    def remaining(self, dc_id: int) -> float:
+        return max(self._backoff_until.get(dc_id, 0) - time.monotonic(), 0)
+
+    async def wait(self, dc_id: int) -> None:
+        while (remaining := self.remaining(dc_id)) > 0:
+            await asyncio.sleep(remaining)
+
+
+server_outages = ServerOutageTracker()
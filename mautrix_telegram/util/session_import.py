@@ -0,0 +1,122 @@
+# mautrix-telegram - A Matrix-Telegram puppeting bridge
+# Copyright (C) 2026 Tulir Asokan
+#
+# This program is free software: you can redistribute it and/or modify
+# it under the terms of the GNU Affero General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# This program is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU Affero General Public License for more details.
+#
+# You should have received a copy of the GNU Affero General Public License
+# along with this program.  If not, see <https://www.gnu.org/licenses/>.
+from __future__ import annotations
+
+from typing import NamedTuple
+import base64
+import binascii
+import struct
+
+# Imported sessions always connect to the production address of their DC. Pyrogram sessions only
+# store the DC ID, and the address in Telethon sessions isn't trusted, as it could point anywhere.
+DC_ADDRESSES = {
+    1: "149.154.175.53",
+    2: "149.154.167.51",
+    3: "149.154.175.100",
+    4: "149.154.167.91",
+    5: "91.108.56.130",
+}
+DEFAULT_PORT = 443
+AUTH_KEY_SIZE = 256
+
+
+class SessionImportError(ValueError):
+    pass
+
+
+class ImportedSession(NamedTuple):
+    dc_id: int
+    server_address: str
+    port: int
+    auth_key: bytes
+
+
+def _b64decode(data: str) -> bytes:
+    try:
+        return base64.urlsafe_b64decode(data + "=" * (-len(data) % 4))
+    except (binascii.Error, ValueError) as e:
+        raise SessionImportError("Session string is not valid base64") from e
+
+
+def _parse_telethon(data: str) -> ImportedSession:
+    raw = _b64decode(data)
+    ip_len = 4 if len(raw) == 1 + 4 + 2 + AUTH_KEY_SIZE else 16
+    try:
+        dc_id, _ip, _port, key = struct.unpack(f">B{ip_len}sH{AUTH_KEY_SIZE}s", raw)
+    except struct.error as e:
+        raise SessionImportError("Telethon session string has an invalid length") from e
+    if dc_id not in DC_ADDRESSES:
+        raise SessionImportError(f"Unknown DC ID {dc_id}")
+    return ImportedSession(dc_id, DC_ADDRESSES[dc_id], DEFAULT_PORT, key)
+
+
+# Pyrogram has changed its session string format a few times, they're told apart by length
+_pyrogram_formats = {
+    struct.calcsize(fmt): fmt
+    for fmt in (
+        ">BI?256sQ?",  # Current format with API ID and 64-bit user ID
+        ">B?256sQ?",  # Old format with 64-bit user ID
+        ">B?256sI?",  # Old format with 32-bit user ID
+    )
+}
+
+
+def _parse_pyrogram(data: str) -> ImportedSession:
+    raw = _b64decode(data)
+    try:
+        fmt = _pyrogram_formats[len(raw)]
+    except KeyError:
+        raise SessionImportError("Unrecognized session string format") from None
+    values = struct.unpack(fmt, raw)
+    if fmt.startswith(">BI"):
+        dc_id, _api_id, test_mode, key, _user_id, _is_bot = values
+    else:
+        dc_id, test_mode, key, _user_id, _is_bot = values
+    if test_mode:
+        raise SessionImportError("Test server sessions can't be imported")
+    elif dc_id not in DC_ADDRESSES:
+        raise SessionImportError(f"Unknown DC ID {dc_id}")
+    return ImportedSession(dc_id, DC_ADDRESSES[dc_id], DEFAULT_PORT, key)
+
+
+def _parse_raw(data: str) -> ImportedSession:
+    dc_id, key_hex = data.split(":", 1)
+    try:
+        dc_id = int(dc_id)
+        key = bytes.fromhex(key_hex)
+    except ValueError as e:
+        raise SessionImportError("Expected <dc id>:<hex auth key>") from e
+    if len(key) != AUTH_KEY_SIZE:
+        raise SessionImportError(f"Auth key must be {AUTH_KEY_SIZE} bytes")
+    elif dc_id not in DC_ADDRESSES:
+        raise SessionImportError(f"Unknown DC ID {dc_id}")
+    return ImportedSession(dc_id, DC_ADDRESSES[dc_id], DEFAULT_PORT, key)
+
+
+def parse_session_string(data: str) -> ImportedSession:
+    """
+    Parse a Telethon StringSession, a Pyrogram session string or a raw ``<dc id>:<hex auth key>``
+    pair into the data needed for a Telethon session.
+    """
+    data = data.strip()
+    if not data:
+        raise SessionImportError("Session string is empty")
+    elif ":" in data:
+        return _parse_raw(data)
+    elif data[0] == "1":
+        # Telethon session strings are prefixed with the string format version
+        return _parse_telethon(data[1:])
+    return _parse_pyrogram(data)
@@ -0,0 +1,67 @@
+# mautrix-telegram - A Matrix-Telegram puppeting bridge
+# Copyright (C) 2026 Tulir Asokan
+#
+# This program is free software: you can redistribute it and/or modify
+# it under the terms of the GNU Affero General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# This program is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU Affero General Public License for more details.
+#
+# You should have received a copy of the GNU Affero General Public License
+# along with this program.  If not, see <https://www.gnu.org/licenses/>.
+from __future__ import annotations
+
+from typing import Any, Iterator
+from contextlib import contextmanager
+import asyncio
+import logging
+
+try:
+    from opentelemetry import trace
+    from opentelemetry.exporter.otlp.proto.http.trace_exporter import OTLPSpanExporter
+    from opentelemetry.sdk.resources import SERVICE_NAME, Resource
+    from opentelemetry.sdk.trace import TracerProvider
+    from opentelemetry.sdk.trace.export import BatchSpanProcessor
+except ImportError:
+    trace = None
+
+log: logging.Logger = logging.getLogger("mau.util.tracing")
+
+_provider: TracerProvider | None = None
+_tracer: trace.Tracer | None = None
+
+
+def init_tracing(endpoint: str, service_name: str, headers: dict[str, str] | None = None) -> bool:
+    global _provider, _tracer
+    if trace is None:
+        log.warning("Tracing is enabled in the config, but OpenTelemetry is not installed")
+        return False
+    _provider = TracerProvider(resource=Resource.create({SERVICE_NAME: service_name}))
+    exporter = OTLPSpanExporter(endpoint=endpoint, headers=headers or None)
+    _provider.add_span_processor(BatchSpanProcessor(exporter))
+    _tracer = _provider.get_tracer("mautrix_telegram")
+    log.info(f"Sending traces to {endpoint}")
+    return True
+
+
+async def stop_tracing() -> None:
+    global _provider, _tracer
+    if _provider is not None:
+        provider, _provider, _tracer = _provider, None, None
+        # Flushes any spans that haven't been exported yet, which blocks on the exporter
+        await asyncio.get_running_loop().run_in_executor(None, provider.shutdown)
+
+
+@contextmanager
+def span(name: str, **attributes: Any) -> Iterator[None]:
+    """Trace the wrapped block as a span. Does nothing if tracing isn't enabled."""
+    if _tracer is None:
+        yield
+        return
+    attributes = {key: value for key, value in attributes.items() if value is not None}
+    with _tracer.start_as_current_span(name, attributes=attributes):
+        yield
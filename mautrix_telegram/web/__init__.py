@@ -1,2 +1,3 @@
 from .provisioning import ProvisioningAPI
 from .public import PublicBridgeWebsite
+from .webhook import WebhookAPI
@@ -19,6 +19,7 @@ from abc import abstractmethod
 import abc
 import asyncio
 import logging
+import time
 
 from aiohttp import web
 from telethon.errors import (
@@ -39,20 +40,28 @@ from telethon.errors import (
 )
 
 from mautrix.bridge import InvalidAccessToken, OnlyLoginSelf
+from mautrix.types import UserID
 from mautrix.util import background_task
 from mautrix.util.format_duration import format_duration
 
 from ...commands.telegram.auth import enter_password
 from ...puppet import Puppet
 from ...user import User
+from ...util.session_import import SessionImportError, parse_session_string
 
 
 class AuthAPI(abc.ABC):
     log: logging.Logger = logging.getLogger("mau.web.auth")
     loop: asyncio.AbstractEventLoop
+    # Login step and time.monotonic() deadline of Telegram flood waits per user. Login requests
+    # made before the deadline are rejected without contacting Telegram, so that retrying too
+    # early doesn't extend the wait. The Telethon client keeps its login state in the meantime,
+    # so the login can be resumed from the same step once the wait is over.
+    _login_flood_waits: dict[UserID, tuple[str, float]]
 
     def __init__(self, loop: asyncio.AbstractEventLoop):
         self.loop = loop
+        self._login_flood_waits = {}
 
     @abstractmethod
     def get_login_response(
@@ -66,6 +75,7 @@ class AuthAPI(abc.ABC):
         message: str = "",
         error: str = "",
         errcode: str = "",
+        retry_after: int | None = None,
     ) -> web.Response:
         raise NotImplementedError()
 
@@ -84,6 +94,40 @@ class AuthAPI(abc.ABC):
     ) -> web.Response:
         raise NotImplementedError()
 
+    def _flood_wait_response(
+        self, user: User, state: str, seconds: int, error: str
+    ) -> web.Response:
+        self._login_flood_waits[user.mxid] = (state, time.monotonic() + seconds)
+        return self.get_login_response(
+            mxid=user.mxid,
+            state=state,
+            status=429,
+            errcode="flood_wait",
+            error=f"{error} Please wait for {format_duration(seconds)} before trying again.",
+            retry_after=seconds,
+        )
+
+    def _check_flood_wait(self, user: User, state: str) -> web.Response | None:
+        try:
+            flood_state, deadline = self._login_flood_waits[user.mxid]
+        except KeyError:
+            return None
+        remaining = int(deadline - time.monotonic()) + 1
+        if remaining <= 0 or flood_state != state:
+            del self._login_flood_waits[user.mxid]
+            return None
+        return self.get_login_response(
+            mxid=user.mxid,
+            state=state,
+            status=429,
+            errcode="flood_wait",
+            error=(
+                "Telegram is still rate limiting login attempts. "
+                f"Please wait for {format_duration(remaining)} before trying again."
+            ),
+            retry_after=remaining,
+        )
+
     async def post_matrix_token(self, user: User, token: str) -> web.Response:
         puppet = await Puppet.get_by_tgid(user.tgid)
         if puppet.is_real_user:
@@ -122,6 +166,9 @@ class AuthAPI(abc.ABC):
                 errcode="phone_number_invalid",
                 error="Phone number not given.",
             )
+        flood_err = self._check_flood_wait(user, "request")
+        if flood_err is not None:
+            return flood_err
         try:
             await user.client.sign_in(phone.strip())
             return self.get_login_response(
@@ -177,15 +224,11 @@ class AuthAPI(abc.ABC):
                 ),
             )
         except FloodWaitError as e:
-            return self.get_login_response(
-                mxid=user.mxid,
-                state="request",
-                status=429,
-                errcode="flood_wait",
-                error=(
-                    "Your phone number has been temporarily blocked for flooding. "
-                    f"Please wait for {format_duration(e.seconds)} before trying again."
-                ),
+            return self._flood_wait_response(
+                user,
+                "request",
+                e.seconds,
+                "Your phone number has been temporarily blocked for flooding.",
             )
         except Exception:
             self.log.exception("Error requesting phone code")
@@ -201,11 +244,15 @@ class AuthAPI(abc.ABC):
         existing_user = await User.get_by_tgid(user_info.id)
         if existing_user and existing_user != user:
             await existing_user.log_out()
+        self._login_flood_waits.pop(user.mxid, None)
         background_task.create(user.post_login(user_info, first_login=True))
         if user.command_status and user.command_status["action"] == "Login":
             user.command_status = None
 
     async def post_login_token(self, user: User, token: str) -> web.Response:
+        flood_err = self._check_flood_wait(user, "token")
+        if flood_err:
+            return flood_err
         try:
             user_info = await user.client.sign_in(bot_token=token.strip())
             await self.postprocess_login(user, user_info)
@@ -233,6 +280,10 @@ class AuthAPI(abc.ABC):
                 errcode="bot_token_expired",
                 error="Bot token expired.",
             )
+        except FloodWaitError as e:
+            return self._flood_wait_response(
+                user, "token", e.seconds, "Too many login attempts with that bot token."
+            )
         except Exception:
             self.log.exception("Error sending bot token")
             return self.get_login_response(
@@ -242,6 +293,43 @@ class AuthAPI(abc.ABC):
                 error="Internal server error while sending token.",
             )
 
+    async def post_login_session(self, user: User, session_string: str) -> web.Response:
+        flood_err = self._check_flood_wait(user, "session")
+        if flood_err:
+            return flood_err
+        try:
+            user_info = await user.import_session(parse_session_string(session_string))
+        except SessionImportError as e:
+            return self.get_login_response(
+                mxid=user.mxid,
+                state="session",
+                status=400,
+                errcode="session_invalid",
+                error=f"Failed to import session: {e}",
+            )
+        except FloodWaitError as e:
+            return self._flood_wait_response(
+                user, "session", e.seconds, "Too many login attempts with that session."
+            )
+        except Exception:
+            self.log.exception("Error importing session")
+            return self.get_login_response(
+                mxid=user.mxid,
+                state="session",
+                status=500,
+                error="Internal server error while importing session.",
+            )
+        await self.postprocess_login(user, user_info)
+        human_tg_id = f"@{user_info.username}" if user_info.username else f"+{user_info.phone}"
+        return self.get_login_response(
+            mxid=user.mxid,
+            state="logged-in",
+            status=200,
+            username=user_info.username,
+            phone=user_info.phone,
+            human_tg_id=human_tg_id,
+        )
+
     async def post_login_code(
         self, user: User, code: int, password_in_data: bool
     ) -> web.Response | None:
@@ -253,6 +341,9 @@ class AuthAPI(abc.ABC):
                 errcode="phone_code_missing",
                 error="You must provide the code from your phone.",
             )
+        flood_err = self._check_flood_wait(user, "code")
+        if flood_err is not None:
+            return flood_err
         try:
             user_info = await user.client.sign_in(code=code)
             await self.postprocess_login(user, user_info)
@@ -290,15 +381,8 @@ class AuthAPI(abc.ABC):
                 error="That phone number has not been registered.",
             )
         except FloodWaitError as e:
-            return self.get_login_response(
-                mxid=user.mxid,
-                state="code",
-                status=429,
-                errcode="flood_wait",
-                error=(
-                    "You tried to enter your phone code too many times. "
-                    f"Please wait for {format_duration(e.seconds)} before trying again."
-                ),
+            return self._flood_wait_response(
+                user, "code", e.seconds, "You tried to enter your phone code too many times."
             )
         except SessionPasswordNeededError:
             if not password_in_data:
@@ -326,6 +410,9 @@ class AuthAPI(abc.ABC):
             )
 
     async def post_login_password(self, user: User, password: str) -> web.Response:
+        flood_err = self._check_flood_wait(user, "password")
+        if flood_err is not None:
+            return flood_err
         try:
             user_info = await user.client.sign_in(password=password.strip())
             await self.postprocess_login(user, user_info)
@@ -366,15 +453,8 @@ class AuthAPI(abc.ABC):
                 ),
             )
         except FloodWaitError as e:
-            return self.get_login_response(
-                mxid=user.mxid,
-                state="password",
-                status=429,
-                errcode="flood_wait",
-                error=(
-                    "You tried to enter your password too many times. "
-                    f"Please wait for {format_duration(e.seconds)} before trying again."
-                ),
+            return self._flood_wait_response(
+                user, "password", e.seconds, "You tried to enter your password too many times."
             )
         except Exception as e:
             self.log.exception("Error sending password")
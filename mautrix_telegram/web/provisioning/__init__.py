@@ -22,20 +22,31 @@ import json
 import logging
 
 from aiohttp import web
-from telethon.errors import SessionPasswordNeededError
+from telethon.errors import RPCError, SessionPasswordNeededError, StickersetInvalidError
 from telethon.tl.custom import QRLogin
-from telethon.tl.functions.messages import GetAllStickersRequest
-from telethon.tl.types import ChannelForbidden, ChatForbidden, TypeChat, User as TLUser
+from telethon.tl.functions.channels import JoinChannelRequest
+from telethon.tl.functions.messages import GetAllStickersRequest, GetStickerSetRequest
+from telethon.tl.types import (
+    Channel,
+    ChannelForbidden,
+    ChatForbidden,
+    InputStickerSetShortName,
+    TypeChat,
+    User as TLUser,
+)
 from telethon.utils import get_peer_id, resolve_id
 
 from mautrix.appservice import AppService
 from mautrix.client import Client
 from mautrix.errors import IntentError, MatrixRequestError
-from mautrix.types import UserID
+from mautrix.types import EventID, RoomID, UserID
 from mautrix.util import background_task
 
 from ...commands.portal.util import get_initial_state, user_has_power_level
-from ...portal import Portal
+from ...db import Backfill, BackfillType
+from ...formatter.from_telegram import parse_bot_start_link
+from ...portal import Portal, humanize_rpc_error
+from ...portal_util.emote_pack import RoomEmotes, import_sticker_pack, sticker_short_name_regex
 from ...types import TelegramID
 from ...user import User
 from ..common import AuthAPI
@@ -67,6 +78,15 @@ class ProvisioningAPI(AuthAPI):
         )
         self.app.router.add_route("POST", f"{portal_prefix}/create", self.create_chat)
         self.app.router.add_route("POST", f"{portal_prefix}/disconnect", self.disconnect_chat)
+        self.app.router.add_route("POST", f"{portal_prefix}/invite_link", self.create_invite_link)
+        self.app.router.add_route(
+            "DELETE", f"{portal_prefix}/invite_link", self.revoke_invite_link
+        )
+        self.app.router.add_route("POST", f"{portal_prefix}/backfill", self.backfill)
+        self.app.router.add_route("POST", portal_prefix + "/report/{event_id}", self.report_event)
+        self.app.router.add_route(
+            "GET", portal_prefix + "/backfill/{job_id:[0-9]+}", self.get_backfill_status
+        )
 
         user_prefix = "/v1/user/{mxid}"
         self.app.router.add_route("GET", f"{user_prefix}", self.get_user_info)
@@ -76,14 +96,22 @@ class ProvisioningAPI(AuthAPI):
             "GET", f"{user_prefix}/resolve_identifier/{{identifier}}", self.resolve_identifier
         )
         self.app.router.add_route("POST", f"{user_prefix}/pm/{{identifier}}", self.start_dm)
+        self.app.router.add_route("POST", f"{user_prefix}/join/{{identifier}}", self.join_chat)
 
         self.app.router.add_route("GET", f"{user_prefix}/stickersets", self.get_stickersets)
+        self.app.router.add_route(
+            "POST", f"{user_prefix}/stickersets/{{short_name}}/import", self.import_stickerset
+        )
 
         self.app.router.add_route("POST", f"{user_prefix}/retry_takeout", self.retry_takeout)
+        self.app.router.add_route("GET", f"{user_prefix}/takeout", self.get_takeout_status)
+        self.app.router.add_route("POST", f"{user_prefix}/takeout/cancel", self.cancel_takeout)
+        self.app.router.add_route("POST", f"{user_prefix}/takeout/restart", self.restart_takeout)
 
         self.app.router.add_route("POST", f"{user_prefix}/logout", self.logout)
         self.app.router.add_route("GET", f"{user_prefix}/login/qr", self.login_qr)
         self.app.router.add_route("POST", f"{user_prefix}/login/bot_token", self.send_bot_token)
+        self.app.router.add_route("POST", f"{user_prefix}/login/session", self.send_session)
         self.app.router.add_route("POST", f"{user_prefix}/login/request_code", self.request_code)
         self.app.router.add_route("POST", f"{user_prefix}/login/send_code", self.send_code)
         self.app.router.add_route("POST", f"{user_prefix}/login/send_password", self.send_password)
@@ -284,6 +312,14 @@ class ProvisioningAPI(AuthAPI):
                 400, "body_value_invalid", "Given chat type is not valid."
             )
 
+        invite = data.get("invite", [])
+        if not isinstance(invite, list) or any(
+            not isinstance(mxid, str) or not mxid.startswith("@") for mxid in invite
+        ):
+            return self.get_error_response(
+                400, "body_value_invalid", "Invite list must be a list of Matrix user IDs."
+            )
+
         supergroup = type == "supergroup"
         type = {
             "supergroup": "channel",
@@ -302,7 +338,9 @@ class ProvisioningAPI(AuthAPI):
             tg_receiver=TelegramID(0),
         )
         try:
-            await portal.create_telegram_chat(user, supergroup=supergroup)
+            await portal.create_telegram_chat(
+                user, supergroup=supergroup, invite=[UserID(mxid) for mxid in invite]
+            )
         except ValueError as e:
             await portal.delete()
             return self.get_error_response(500, "unknown_error", e.args[0])
@@ -354,6 +392,251 @@ class ProvisioningAPI(AuthAPI):
             background_task.create(coro)
         return web.json_response({}, status=200 if sync else 202)
 
+    async def _get_invite_link_portal(
+        self, request: web.Request
+    ) -> tuple[Portal | None, User | None, web.Response | None]:
+        err = self.check_authorization(request)
+        if err is not None:
+            return None, None, err
+
+        portal = await Portal.get_by_mxid(request.match_info["mxid"])
+        if not portal or not portal.tgid:
+            return (
+                None,
+                None,
+                self.get_error_response(404, "portal_not_found", "Room is not a portal."),
+            )
+
+        user, err = await self.get_user(
+            request.query.get("user_id", None), expect_logged_in=True, require_puppeting=False
+        )
+        if err is not None:
+            return None, None, err
+        elif not await user_has_power_level(portal.mxid, self.az.intent, user, "invite"):
+            return (
+                None,
+                None,
+                self.get_error_response(
+                    403,
+                    "not_enough_permissions",
+                    "You do not have the permissions to manage invite links in that room.",
+                ),
+            )
+        return portal, user, None
+
+    async def create_invite_link(self, request: web.Request) -> web.Response:
+        portal, user, err = await self._get_invite_link_portal(request)
+        if err is not None:
+            return err
+        data = await self.get_data(request) or {}
+        uses = data.get("uses")
+        expire = data.get("expire")
+        title = data.get("title")
+        request_needed = data.get("request_needed", False)
+        if any(isinstance(val, bool) or not isinstance(val or 0, int) for val in (uses, expire)):
+            return self.get_error_response(
+                400, "body_value_invalid", "uses and expire must be integers."
+            )
+        elif not isinstance(title or "", str):
+            return self.get_error_response(400, "body_value_invalid", "title must be a string.")
+        elif not isinstance(request_needed, bool):
+            return self.get_error_response(
+                400, "body_value_invalid", "request_needed must be a boolean."
+            )
+        # Telegram stores both as 32-bit integers
+        elif uses is not None and not 0 <= uses < 2**31:
+            return self.get_error_response(400, "body_value_invalid", "uses is out of range.")
+        elif expire is not None and not 0 <= expire < 2**31:
+            return self.get_error_response(
+                400, "body_value_invalid", "expire must be a valid unix timestamp."
+            )
+        try:
+            link = await portal.get_invite_link(
+                user,
+                uses=uses,
+                expire=datetime.datetime.fromtimestamp(expire) if expire else None,
+                request_needed=request_needed,
+                title=title,
+            )
+        except ValueError as e:
+            return self.get_error_response(400, "body_value_invalid", e.args[0])
+        except RPCError as e:
+            return self.get_error_response(403, "invite_link_failed", humanize_rpc_error(e))
+        return web.json_response({"link": link}, status=200)
+
+    async def revoke_invite_link(self, request: web.Request) -> web.Response:
+        portal, user, err = await self._get_invite_link_portal(request)
+        if err is not None:
+            return err
+        link = request.query.get("link")
+        if not link:
+            return self.get_error_response(400, "link_missing", "Invite link not given.")
+        try:
+            await portal.revoke_invite_link(user, link)
+        except ValueError as e:
+            return self.get_error_response(400, "body_value_invalid", e.args[0])
+        except RPCError as e:
+            return self.get_error_response(403, "invite_link_failed", humanize_rpc_error(e))
+        return web.json_response({}, status=200)
+
+    async def _get_backfill_portal(
+        self, request: web.Request
+    ) -> tuple[Portal | None, User | None, web.Response | None]:
+        err = self.check_authorization(request)
+        if err is not None:
+            return None, None, err
+
+        portal = await Portal.get_by_mxid(request.match_info["mxid"])
+        if not portal or not portal.tgid:
+            return (
+                None,
+                None,
+                self.get_error_response(404, "portal_not_found", "Room is not a portal."),
+            )
+
+        user, err = await self.get_user(
+            request.query.get("user_id", None), expect_logged_in=True, require_puppeting=False
+        )
+        if err is not None:
+            return None, None, err
+        elif not self.bridge.config["bridge.backfill.enable"]:
+            return (
+                None,
+                None,
+                self.get_error_response(
+                    400, "backfill_disabled", "Backfilling is disabled in the bridge config."
+                ),
+            )
+        return portal, user, None
+
+    async def backfill(self, request: web.Request) -> web.Response:
+        portal, user, err = await self._get_backfill_portal(request)
+        if err is not None:
+            return err
+        elif not user.is_admin and not await self.az.state_store.is_joined(
+            portal.mxid, user.mxid
+        ):
+            return self.get_error_response(403, "not_in_room", "You are not in that room.")
+        elif not await user_has_power_level(portal.mxid, self.az.intent, user, "backfill"):
+            return self.get_error_response(
+                403,
+                "not_enough_permissions",
+                "You do not have the permissions to backfill that room.",
+            )
+        normal_groups = self.bridge.config["bridge.backfill.normal_groups"]
+        if not normal_groups and portal.peer_type == "chat":
+            return self.get_error_response(
+                400, "backfill_disabled", "Backfilling normal groups is disabled."
+            )
+        data = await self.get_data(request) or {}
+        count = data.get("count")
+        before = data.get("before")
+        if any(isinstance(val, bool) or not isinstance(val or 0, int) for val in (count, before)):
+            return self.get_error_response(
+                400, "body_value_invalid", "count and before must be integers."
+            )
+        elif not isinstance(data.get("media", True), bool):
+            return self.get_error_response(400, "body_value_invalid", "media must be a boolean.")
+        elif count is not None and count <= 0:
+            return self.get_error_response(400, "body_value_invalid", "count must be positive.")
+        anchor_msg_id = None
+        if before:
+            try:
+                if before < 0:
+                    raise ValueError("negative timestamp")
+                before_date = datetime.datetime.fromtimestamp(before)
+            except (OverflowError, OSError, ValueError):
+                return self.get_error_response(
+                    400, "body_value_invalid", "before must be a valid unix timestamp."
+                )
+            try:
+                messages = await user.client.get_messages(
+                    portal.peer, limit=1, offset_date=before_date
+                )
+            except RPCError as e:
+                return self.get_error_response(403, "backfill_failed", humanize_rpc_error(e))
+            if not messages:
+                return self.get_error_response(
+                    400, "no_messages_before", "There are no messages before the given date."
+                )
+            # Backfilling is done before the anchor, so add one to include the found message
+            anchor_msg_id = messages[0].id + 1
+        job = await portal.enqueue_backfill(
+            user,
+            priority=0,
+            max_batches=1,
+            messages_per_batch=count,
+            anchor_msg_id=anchor_msg_id,
+            extra_data={"skip_media": not data.get("media", True)},
+            type=BackfillType.ON_DEMAND,
+        )
+        return web.json_response({"job_id": job.queue_id}, status=202)
+
+    async def report_event(self, request: web.Request) -> web.Response:
+        err = self.check_authorization(request)
+        if err is not None:
+            return err
+
+        portal = await Portal.get_by_mxid(request.match_info["mxid"])
+        if not portal or not portal.tgid:
+            return self.get_error_response(404, "portal_not_found", "Room is not a portal.")
+
+        user, err = await self.get_user(
+            request.query.get("user_id", None), expect_logged_in=True, require_puppeting=False
+        )
+        if err is not None:
+            return err
+        elif not user.is_admin and not await self.az.state_store.is_joined(
+            portal.mxid, user.mxid
+        ):
+            return self.get_error_response(403, "not_in_room", "You are not in that room.")
+
+        data = await self.get_data(request) or {}
+        reason = data.get("reason")
+        if not isinstance(reason or "", str):
+            return self.get_error_response(400, "body_value_invalid", "reason must be a string.")
+        try:
+            report_reason = await portal.handle_matrix_report(
+                user, EventID(request.match_info["event_id"]), reason
+            )
+        except ValueError as e:
+            return self.get_error_response(404, "message_not_found", str(e))
+        except RPCError as e:
+            return self.get_error_response(403, "report_failed", humanize_rpc_error(e))
+        reason_name = type(report_reason).__name__.removeprefix("InputReportReason")
+        return web.json_response({"reason": reason_name.lower()})
+
+    async def get_backfill_status(self, request: web.Request) -> web.Response:
+        portal, user, err = await self._get_backfill_portal(request)
+        if err is not None:
+            return err
+        job = await Backfill.get(int(request.match_info["job_id"]))
+        if (
+            not job
+            or job.user_mxid != user.mxid
+            or (job.portal_tgid, job.portal_tg_receiver) != portal.tgid_full
+        ):
+            return self.get_error_response(404, "job_not_found", "Backfill job not found.")
+        if job.completed_at:
+            status = "done"
+        elif job.cooldown_timeout and job.cooldown_timeout > datetime.datetime.now():
+            status = "cooldown"
+        elif job.dispatch_time:
+            status = "running"
+        else:
+            status = "queued"
+        return web.json_response(
+            {
+                "job_id": job.queue_id,
+                "status": status,
+                "count": job.messages_per_batch,
+                "skip_media": bool(job.extra_data.get("skip_media")),
+                "dispatched_at": _timestamp(job.dispatch_time),
+                "completed_at": _timestamp(job.completed_at),
+                "cooldown_until": _timestamp(job.cooldown_timeout),
+            }
+        )
+
     async def get_user_info(self, request: web.Request) -> web.Response:
         data, user, err = await self.get_user_request_info(
             request, expect_logged_in=None, require_puppeting=False
@@ -418,13 +701,14 @@ class ProvisioningAPI(AuthAPI):
         return web.json_response(data=await user.sync_contacts())
 
     async def _resolve_id(
-        self, request: web.Request
-    ) -> tuple[Portal | None, User | None, TLUser | None, web.Response | None]:
+        self, request: web.Request, allow_channels: bool = False
+    ) -> tuple[Portal | None, User | None, TLUser | Channel | None, web.Response | None]:
         data, user, err = await self.get_user_request_info(request, expect_logged_in=True)
         if err is not None:
             return None, user, None, err
         try:
-            identifier: str | int = request.match_info["identifier"]
+            identifier: str | int
+            identifier, _ = parse_bot_start_link(request.match_info["identifier"])
             if isinstance(identifier, str) and identifier.isdecimal():
                 identifier = int(identifier)
             target = await user.client.get_entity(identifier)
@@ -455,6 +739,8 @@ class ProvisioningAPI(AuthAPI):
                     status=404,
                 ),
             )
+        elif allow_channels and isinstance(target, Channel):
+            return await Portal.get_by_entity(target), user, target, None
         elif not isinstance(target, TLUser):
             return (
                 None,
@@ -471,10 +757,31 @@ class ProvisioningAPI(AuthAPI):
         portal = await Portal.get_by_entity(target, tg_receiver=user.tgid)
         return portal, user, target, None
 
+    @staticmethod
+    def _get_start_param(request: web.Request) -> str | None:
+        _, start_param = parse_bot_start_link(request.match_info["identifier"])
+        return request.query.get("start") or start_param
+
+    @staticmethod
+    def _get_channel_info(portal: Portal, target: Channel) -> dict:
+        return {
+            "room_id": portal.mxid,
+            "id": portal.tgid,
+            "chat_info": {
+                "title": target.title,
+                "username": target.username,
+                "type": "supergroup" if target.megagroup else "channel",
+                "participants_count": target.participants_count,
+                "joined": not target.left,
+            },
+        }
+
     async def resolve_identifier(self, request: web.Request) -> web.Response:
-        portal, user, target, err = await self._resolve_id(request)
+        portal, user, target, err = await self._resolve_id(request, allow_channels=True)
         if err is not None:
             return err
+        elif isinstance(target, Channel):
+            return web.json_response(self._get_channel_info(portal, target), status=200)
         puppet = await portal.get_dm_puppet()
         await puppet.update_info(user, target)
         return web.json_response(
@@ -483,6 +790,7 @@ class ProvisioningAPI(AuthAPI):
                 "just_created": False,
                 "id": portal.tgid,
                 "contact_info": puppet.contact_info,
+                "start_param": self._get_start_param(request) if target.bot else None,
             },
             status=200,
         )
@@ -491,22 +799,58 @@ class ProvisioningAPI(AuthAPI):
         portal, user, target, err = await self._resolve_id(request)
         if err is not None:
             return err
+        start_param = self._get_start_param(request)
+        if start_param and not target.bot:
+            return self.get_error_response(
+                400, "not_a_bot", "Start parameters can only be used with bots."
+            )
         puppet = await portal.get_dm_puppet()
         if portal.mxid:
             just_created = False
         else:
             await portal.create_matrix_room(user, target, [user.mxid])
             just_created = True
+        if start_param:
+            try:
+                await portal.start_bot(user, start_param)
+            except RPCError as e:
+                return self.get_error_response(403, "start_bot_failed", humanize_rpc_error(e))
         return web.json_response(
             {
                 "room_id": portal.mxid,
                 "just_created": just_created,
                 "id": portal.tgid,
                 "contact_info": puppet.contact_info,
+                "start_param": start_param,
             },
             status=201 if just_created else 200,
         )
 
+    async def join_chat(self, request: web.Request) -> web.Response:
+        portal, user, target, err = await self._resolve_id(request, allow_channels=True)
+        if err is not None:
+            return err
+        elif not isinstance(target, Channel):
+            return self.get_error_response(
+                400, "not_a_chat", "Identifier is not a public channel or supergroup."
+            )
+        if target.left:
+            try:
+                updates = await user.client(JoinChannelRequest(channel=target))
+            except RPCError as e:
+                return self.get_error_response(403, "join_failed", humanize_rpc_error(e))
+            target = next((chat for chat in updates.chats if chat.id == target.id), target)
+        if portal.mxid:
+            await portal.invite_to_matrix([user.mxid])
+            just_created = False
+        else:
+            await portal.create_matrix_room(user, target, [user.mxid])
+            just_created = True
+        return web.json_response(
+            {**self._get_channel_info(portal, target), "just_created": just_created},
+            status=201 if just_created else 200,
+        )
+
     async def get_stickersets(self, request: web.Request) -> web.Response:
         _, user, err = await self.get_user_request_info(
             request, expect_logged_in=True, want_data=False
@@ -519,6 +863,64 @@ class ProvisioningAPI(AuthAPI):
             resp.append(stickerset.short_name)
         return web.json_response(resp, status=200)
 
+    async def import_stickerset(self, request: web.Request) -> web.Response:
+        data, user, err = await self.get_user_request_info(request, expect_logged_in=True)
+        if err is not None:
+            return err
+        short_name = request.match_info["short_name"]
+        room_id = data.get("room_id")
+        if not sticker_short_name_regex.fullmatch(short_name):
+            return self.get_error_response(
+                400, "short_name_invalid", "That doesn't look like a sticker pack short name."
+            )
+        elif not isinstance(room_id, str) or not room_id.startswith("!"):
+            return self.get_error_response(400, "room_id_invalid", "room_id must be a room ID.")
+
+        portal = await Portal.get_by_mxid(RoomID(room_id))
+        intent = portal.main_intent if portal else self.az.intent
+        try:
+            await intent.ensure_joined(RoomID(room_id))
+        except (MatrixRequestError, IntentError):
+            return self.get_error_response(
+                403, "bot_not_in_room", "The bridge bot couldn't join the room."
+            )
+        if not await user_has_power_level(RoomID(room_id), intent, user, RoomEmotes):
+            return self.get_error_response(
+                403,
+                "not_enough_permissions",
+                "You do not have the permissions to add sticker packs to that room.",
+            )
+        try:
+            sticker_set = await user.client(
+                GetStickerSetRequest(InputStickerSetShortName(short_name), hash=0)
+            )
+        except StickersetInvalidError:
+            return self.get_error_response(
+                404, "stickerset_not_found", "That sticker pack doesn't exist."
+            )
+        except RPCError as e:
+            return self.get_error_response(403, "stickerset_failed", humanize_rpc_error(e))
+        try:
+            count = await import_sticker_pack(
+                user, intent, RoomID(room_id), sticker_set, self.bridge.config, self.log
+            )
+        except MatrixRequestError as e:
+            return self.get_error_response(
+                403, "send_state_failed", f"Failed to add the sticker pack to the room: {e}"
+            )
+        if not count:
+            return self.get_error_response(
+                500, "import_failed", "Failed to import any stickers from that pack."
+            )
+        return web.json_response(
+            {
+                "title": sticker_set.set.title,
+                "short_name": sticker_set.set.short_name,
+                "count": count,
+            },
+            status=200,
+        )
+
     async def retry_takeout(self, request: web.Request) -> web.Response:
         data, user, err = await self.get_user_request_info(
             request, expect_logged_in=True, want_data=False
@@ -535,6 +937,39 @@ class ProvisioningAPI(AuthAPI):
         user.takeout_retry_immediate.set()
         return web.json_response({}, status=200)
 
+    async def get_takeout_status(self, request: web.Request) -> web.Response:
+        _, user, err = await self.get_user_request_info(
+            request, expect_logged_in=True, want_data=False
+        )
+        if err is not None:
+            return err
+        return web.json_response(await user.get_takeout_status(), status=200)
+
+    async def cancel_takeout(self, request: web.Request) -> web.Response:
+        _, user, err = await self.get_user_request_info(
+            request, expect_logged_in=True, want_data=False
+        )
+        if err is not None:
+            return err
+        if not user.cancel_takeout():
+            return self.get_error_response(
+                400, "takeout_not_running", "There's no takeout or backfill running."
+            )
+        return web.json_response({}, status=200)
+
+    async def restart_takeout(self, request: web.Request) -> web.Response:
+        _, user, err = await self.get_user_request_info(
+            request, expect_logged_in=True, want_data=False
+        )
+        if err is not None:
+            return err
+        if not self.bridge.config["bridge.backfill.enable"]:
+            return self.get_error_response(
+                400, "backfill_disabled", "Backfilling is disabled in the bridge config."
+            )
+        user.restart_takeout()
+        return web.json_response({}, status=200)
+
     async def login_qr(self, request: web.Request) -> web.Response:
         _, user, err = await self.get_user_request_info(request, websocket=True)
         if err is not None:
@@ -585,6 +1020,12 @@ class ProvisioningAPI(AuthAPI):
             return err
         return await self.post_login_token(user, data.get("token", ""))
 
+    async def send_session(self, request: web.Request) -> web.Response:
+        data, user, err = await self.get_user_request_info(request)
+        if err is not None:
+            return err
+        return await self.post_login_session(user, data.get("session", ""))
+
     async def request_code(self, request: web.Request) -> web.Response:
         data, user, err = await self.get_user_request_info(request)
         if err is not None:
@@ -675,7 +1116,9 @@ class ProvisioningAPI(AuthAPI):
         message="",
         error="",
         errcode="",
+        retry_after: int | None = None,
     ) -> web.Response:
+        headers = {}
         if username or phone:
             resp = {
                 "state": "logged-in",
@@ -694,7 +1137,10 @@ class ProvisioningAPI(AuthAPI):
             }
             if state:
                 resp["state"] = state
-        return web.json_response(resp, status=status)
+            if retry_after is not None:
+                resp["retry_after"] = retry_after
+                headers["Retry-After"] = str(retry_after)
+        return web.json_response(resp, status=status, headers=headers)
 
     def check_authorization(self, request: web.Request) -> web.Response | None:
         auth = request.headers.get("Authorization", "")
@@ -792,3 +1238,7 @@ class ProvisioningAPI(AuthAPI):
         user, err = await self.get_user(mxid, expect_logged_in, require_puppeting)
 
         return data, user, err
+
+
+def _timestamp(dt: datetime.datetime | None) -> int | None:
+    return int(dt.timestamp()) if dt else None
@@ -136,6 +136,7 @@ class PublicBridgeWebsite(AuthAPI):
         message: str = "",
         error: str = "",
         errcode: str = "",
+        retry_after: int | None = None,
     ) -> web.Response:
         return web.Response(
             status=status,
@@ -0,0 +1,109 @@
+# mautrix-telegram - A Matrix-Telegram puppeting bridge
+# Copyright (C) 2026 Tulir Asokan
+#
+# This program is free software: you can redistribute it and/or modify
+# it under the terms of the GNU Affero General Public License as published by
+# the Free Software Foundation, either version 3 of the License, or
+# (at your option) any later version.
+#
+# This program is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU Affero General Public License for more details.
+#
+# You should have received a copy of the GNU Affero General Public License
+# along with this program.  If not, see <https://www.gnu.org/licenses/>.
+from __future__ import annotations
+
+from typing import TYPE_CHECKING, Any
+import hmac
+import json
+import logging
+
+from aiohttp import web
+from telethon.errors import RPCError
+
+from mautrix.types import RoomID, UserID
+
+from ...portal import Portal
+from ...user import User
+
+if TYPE_CHECKING:
+    from ...__main__ import TelegramBridge
+
+
+class WebhookAPI:
+    """Simple incoming webhooks that post messages to a portal through a user's account."""
+
+    log: logging.Logger = logging.getLogger("mau.web.webhook")
+    hooks: dict[str, dict[str, Any]]
+    app: web.Application
+
+    def __init__(self, bridge: "TelegramBridge") -> None:
+        self.hooks = bridge.config["appservice.webhook.hooks"] or {}
+        self.app = web.Application(loop=bridge.loop)
+        self.app.router.add_route("POST", "/v1/{hook_id}", self.post_message)
+
+    @staticmethod
+    def get_error_response(status: int, errcode: str, error: str) -> web.Response:
+        return web.json_response({"error": error, "errcode": errcode}, status=status)
+
+    def _check_authorization(self, request: web.Request) -> dict[str, Any] | None:
+        hook_id = request.match_info["hook_id"]
+        hook = self.hooks.get(hook_id)
+        if not isinstance(hook, dict) or not hook.get("secret"):
+            return None
+        elif not isinstance(hook.get("user"), str) or not isinstance(hook.get("room"), str):
+            self.log.warning(f"Webhook {hook_id} is missing the user or room in the config")
+            return None
+        auth = request.headers.get("Authorization", "")
+        if not hmac.compare_digest(auth.encode("utf-8"), f"Bearer {hook['secret']}".encode()):
+            return None
+        return hook
+
+    async def post_message(self, request: web.Request) -> web.Response:
+        hook = self._check_authorization(request)
+        if not hook:
+            return self.get_error_response(
+                401, "webhook_secret_invalid", "Unknown webhook or invalid secret."
+            )
+
+        if request.content_type == "application/json":
+            try:
+                data = await request.json()
+            except json.JSONDecodeError:
+                return self.get_error_response(400, "json_invalid", "Malformed JSON.")
+            if not isinstance(data, dict):
+                return self.get_error_response(400, "json_invalid", "Body must be an object.")
+            text, html = data.get("text"), data.get("html")
+            if not isinstance(text or "", str) or not isinstance(html or "", str):
+                return self.get_error_response(
+                    400, "body_value_invalid", "text and html must be strings."
+                )
+        else:
+            try:
+                text, html = await request.text(), None
+            except UnicodeDecodeError:
+                return self.get_error_response(400, "body_invalid", "Body must be valid text.")
+        if not text and not html:
+            return self.get_error_response(400, "message_empty", "No message text given.")
+
+        user = await User.get_by_mxid(UserID(hook["user"]), create=False)
+        if not user or not await user.is_logged_in():
+            return self.get_error_response(
+                403, "not_logged_in", "The webhook user is not logged into Telegram."
+            )
+        portal = await Portal.get_by_mxid(RoomID(hook["room"]))
+        if not portal:
+            return self.get_error_response(
+                404, "portal_not_found", "The webhook room is not a portal."
+            )
+
+        try:
+            message_ids = await portal.send_webhook_message(user, text, html)
+        except RPCError as e:
+            self.log.warning(f"Failed to send webhook message to {portal.tgid_log}: {e}")
+            return self.get_error_response(
+                502, "telegram_error", f"Telegram rejected the message: {e}"
+            )
+        return web.json_response({"message_ids": message_ids})